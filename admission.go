@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AnnotationProtected marks a workload ("true") whose restarts the admission
+// webhook rejects during freeze windows.
+const AnnotationProtected = "restarter.io/protected"
+
+// FreezeWebhook is a validating admission webhook that rejects changes of
+// the restartedAt pod template annotation, i.e. rollout restarts by this tool,
+// kubectl or anyone else, on protected workloads during freeze windows. Other
+// changes are always allowed.
+type FreezeWebhook struct {
+	// Windows are the freeze windows, in the maintenance window format.
+	Windows []MaintenanceWindow
+	// Selector additionally protects workloads whose labels it matches; nil
+	// protects only annotated workloads.
+	Selector labels.Selector
+	// Now returns the current time; nil means time.Now.
+	Now func() time.Time
+}
+
+// ServeHTTP answers an admission.k8s.io/v1 AdmissionReview.
+func (h *FreezeWebhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxRequestBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading the request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(data, &review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := h.Review(review.Request)
+	response.UID = review.Request.UID
+	review.Request, review.Response = nil, response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		debugf("Error writing the response: %v\n", err)
+	}
+}
+
+// Review decides on a single admission request.
+func (h *FreezeWebhook) Review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	if req.Operation != admissionv1.Update {
+		return allowed
+	}
+	name := strings.ToLower(req.Kind.Kind) + "/" + req.Namespace + "/" + req.Name
+	newMeta, newTemplate, err := decodeAdmissionObject(req.Kind.Kind, req.Object.Raw)
+	if err != nil {
+		// Failing closed here would block every update of the workload.
+		warnf("Allowing %s: %v\n", name, err)
+		return allowed
+	}
+	if newTemplate == nil {
+		return allowed
+	}
+	_, oldTemplate, err := decodeAdmissionObject(req.Kind.Kind, req.OldObject.Raw)
+	if err != nil {
+		warnf("Allowing %s: %v\n", name, err)
+		return allowed
+	}
+	if oldTemplate.Annotations[RestartedAtAnnotation] == newTemplate.Annotations[RestartedAtAnnotation] {
+		return allowed
+	}
+	if !h.protected(newMeta) {
+		return allowed
+	}
+
+	now := time.Now()
+	if h.Now != nil {
+		now = h.Now()
+	}
+	for _, window := range h.Windows {
+		if window.Contains(now) {
+			message := fmt.Sprintf("%s is protected and restarts are frozen during %s", name, window)
+			infof("Rejected restart of %s by %s: freeze window %s\n", name, req.UserInfo.Username, window)
+			return &admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result:  &metav1.Status{Status: metav1.StatusFailure, Message: message, Reason: metav1.StatusReasonForbidden, Code: http.StatusForbidden},
+			}
+		}
+	}
+	return allowed
+}
+
+// protected reports whether the workload is annotated as protected or matches
+// the selector.
+func (h *FreezeWebhook) protected(meta *metav1.ObjectMeta) bool {
+	if value, ok := meta.Annotations[AnnotationProtected]; ok {
+		protected, err := strconv.ParseBool(value)
+		if err != nil {
+			warnf("Ignoring invalid %s annotation value %q\n", AnnotationProtected, value)
+		} else {
+			return protected
+		}
+	}
+	return h.Selector != nil && h.Selector.Matches(labels.Set(meta.Labels))
+}
+
+// decodeAdmissionObject decodes the workload of an admission request. Other
+// kinds yield a nil template.
+func decodeAdmissionObject(kind string, raw []byte) (*metav1.ObjectMeta, *v1.PodTemplateSpec, error) {
+	var obj runtime.Object
+	var meta *metav1.ObjectMeta
+	switch kind {
+	case KindDeployment:
+		deployment := &appsv1.Deployment{}
+		obj, meta = deployment, &deployment.ObjectMeta
+	case KindStatefulSet:
+		statefulSet := &appsv1.StatefulSet{}
+		obj, meta = statefulSet, &statefulSet.ObjectMeta
+	case KindDaemonSet:
+		daemonSet := &appsv1.DaemonSet{}
+		obj, meta = daemonSet, &daemonSet.ObjectMeta
+	default:
+		return nil, nil, nil
+	}
+	if err := json.Unmarshal(raw, obj); err != nil {
+		return nil, nil, fmt.Errorf("error decoding %s: %v", strings.ToLower(kind), err)
+	}
+	workload := &Workload{Kind: kind, Object: obj}
+	return meta, workload.PodTemplate(), nil
+}
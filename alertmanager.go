@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxAlertPayload bounds the size of an accepted webhook body.
+const maxAlertPayload = 1 << 20
+
+// alertStatusFiring is the status of alerts that have not resolved.
+const alertStatusFiring = "firing"
+
+// AlertPayload is the body Alertmanager posts to webhook receivers.
+type AlertPayload struct {
+	Version  string  `json:"version"`
+	Status   string  `json:"status"`
+	Receiver string  `json:"receiver"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// Alert is a single alert of a webhook payload.
+type Alert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// alertWorkloadLabels maps the alert labels that name a workload, as
+// kube-state-metrics exports them, to its kind.
+var alertWorkloadLabels = []struct {
+	label string
+	kind  string
+}{
+	{"deployment", KindDeployment},
+	{"statefulset", KindStatefulSet},
+	{"daemonset", KindDaemonSet},
+}
+
+// AlertReceiver restarts the workloads named by firing Alertmanager alerts.
+// An alert names a workload with a namespace label plus a deployment,
+// statefulset or daemonset label, or with a pod label, in which case the
+// pod's owner is restarted.
+type AlertReceiver struct {
+	Client  kubernetes.Interface
+	Options RunOptions
+	// Namespaces, when set, are the only namespaces alerts may act on.
+	Namespaces []string
+	// NamespaceLabel is the alert label holding the namespace.
+	NamespaceLabel string
+	// RunContext derives the context of a single webhook from the server's,
+	// e.g. to apply --timeout.
+	RunContext func(ctx context.Context) (context.Context, context.CancelFunc)
+	// BaseContext outlives single requests, so a restart in progress is not
+	// abandoned when Alertmanager gives up on the request.
+	BaseContext context.Context
+	// Output receives the results of every webhook.
+	Output io.Writer
+	Format string
+
+	writeMu sync.Mutex
+}
+
+// ServeHTTP processes a webhook and answers with its results as JSON.
+func (a *AlertReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	var payload AlertPayload
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxAlertPayload)).Decode(&payload); err != nil {
+		warnf("Rejecting webhook from %s: %v\n", req.RemoteAddr, err)
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	infof("Received %d alerts from receiver %s\n", len(payload.Alerts), payload.Receiver)
+
+	ctx, cancel := a.RunContext(a.BaseContext)
+	defer cancel()
+	results := a.Process(ctx, payload.Alerts)
+
+	a.writeMu.Lock()
+	if err := WriteResults(a.Output, a.Format, results); err != nil {
+		errorf("Error writing results: %v\n", err)
+	}
+	a.writeMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Report{Results: results}); err != nil {
+		debugf("Error answering webhook: %v\n", err)
+	}
+}
+
+// Process restarts the workloads named by the firing alerts, each once, and
+// returns one Result per alert that names a workload. Resolved alerts are
+// ignored.
+func (a *AlertReceiver) Process(ctx context.Context, alerts []Alert) []Result {
+	runner := NewRunner(a.Client, a.Options)
+	var results []Result
+	for _, alert := range alerts {
+		if alert.Status != alertStatusFiring {
+			continue
+		}
+		name := alert.Labels["alertname"]
+		rule := &Rule{Name: "alert:" + name}
+		namespace := alert.Labels[a.NamespaceLabel]
+		result := Result{Rule: rule.Name, Namespace: namespace, Pod: alert.Labels["pod"], Status: StatusMatched, Trigger: "alert " + name}
+		if namespace == "" {
+			debugf("Ignoring alert %s without a %s label\n", name, a.NamespaceLabel)
+			continue
+		}
+		if !a.namespaceAllowed(rule, namespace) {
+			infof("Skipping alert %s: namespace %s is not processed\n", name, namespace)
+			result.Status, result.Reason = StatusSkipped, "namespace "+namespace+" is not processed"
+			results = append(results, result)
+			continue
+		}
+
+		workload, err := a.alertWorkload(ctx, alert, namespace)
+		if err != nil {
+			errorf("Error resolving the workload of alert %s: %v\n", name, err)
+			result.fail(err)
+			results = append(results, result)
+			continue
+		}
+		if workload == nil {
+			debugf("Ignoring alert %s: no workload or pod label\n", name)
+			continue
+		}
+		nsAnnotations := namespaceAnnotations(ctx, namespace, a.Options.Scope, a.Client)
+		results = append(results, runner.processWorkload(ctx, rule, workload, "alert "+name, result, nsAnnotations))
+	}
+	return results
+}
+
+// namespaceAllowed applies Namespaces and the excluded namespaces.
+func (a *AlertReceiver) namespaceAllowed(rule *Rule, namespace string) bool {
+	if namespaceExcluded(rule, namespace, a.Options.ExcludeNamespaces) {
+		return false
+	}
+	if len(a.Namespaces) == 0 {
+		return true
+	}
+	for _, name := range a.Namespaces {
+		if name == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// alertWorkload returns the workload an alert names, or nil if it names none.
+func (a *AlertReceiver) alertWorkload(ctx context.Context, alert Alert, namespace string) (*Workload, error) {
+	for _, label := range alertWorkloadLabels {
+		if name := alert.Labels[label.label]; name != "" {
+			workload := &Workload{Kind: label.kind, Namespace: namespace, Name: name}
+			if err := RefreshWorkload(ctx, workload, a.Client); err != nil {
+				return nil, err
+			}
+			return workload, nil
+		}
+	}
+	name := alert.Labels["pod"]
+	if name == "" {
+		return nil, nil
+	}
+	pod, err := getPod(ctx, namespace, name, a.Client)
+	if err != nil {
+		return nil, err
+	}
+	return ResolveWorkload(ctx, pod, a.Client)
+}
+
+func getPod(ctx context.Context, namespace, name string, client kubernetes.Interface) (*v1.Pod, error) {
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting pod %s: %v", name, err)
+	}
+	return pod, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxRequestBody bounds the size of an accepted request body.
+const maxRequestBody = 1 << 20
+
+// RestartRequest is the body of POST /restart, as JSON or YAML: the fields
+// of a rule in the rules file that select pods, plus an optional dry run.
+// The server's strategy, cooldown, maintenance windows and hooks always
+// apply, so a caller cannot set them.
+type RestartRequest struct {
+	Name               string         `yaml:"name"`
+	Namespaces         []string       `yaml:"namespaces"`
+	NamespaceSelector  string         `yaml:"namespaceSelector"`
+	ExcludeNamespaces  []string       `yaml:"excludeNamespaces"`
+	PodSelector        string         `yaml:"podSelector"`
+	FieldSelector      string         `yaml:"fieldSelector"`
+	Match              []string       `yaml:"match"`
+	Expression         string         `yaml:"expression"`
+	Matchers           []MatcherRule  `yaml:"matchers"`
+	Release            string         `yaml:"release"`
+	WorkloadExpression string         `yaml:"workloadExpression"`
+	OnlyUnhealthy      bool           `yaml:"onlyUnhealthy"`
+	OOMKills           int32          `yaml:"oomKills"`
+	OOMWindow          *time.Duration `yaml:"oomWindow"`
+	RestartCount       int32          `yaml:"restartCount"`
+	RestartWindow      *time.Duration `yaml:"restartWindow"`
+	Events             []EventRule    `yaml:"events"`
+	ImageDrift         bool           `yaml:"imageDrift"`
+	OlderThan          string         `yaml:"olderThan"`
+	Action             string         `yaml:"action"`
+	DryRun             bool           `yaml:"dryRun"`
+}
+
+// rule converts the request into a rule, to be validated.
+func (r *RestartRequest) rule() *Rule {
+	name := r.Name
+	if name == "" {
+		name = "api"
+	}
+	return &Rule{
+		Name:               name,
+		Namespaces:         r.Namespaces,
+		NamespaceSelector:  r.NamespaceSelector,
+		ExcludeNamespaces:  r.ExcludeNamespaces,
+		PodSelector:        r.PodSelector,
+		FieldSelector:      r.FieldSelector,
+		Match:              r.Match,
+		Expression:         r.Expression,
+		Matchers:           r.Matchers,
+		Release:            r.Release,
+		WorkloadExpression: r.WorkloadExpression,
+		OnlyUnhealthy:      r.OnlyUnhealthy,
+		OOMKills:           r.OOMKills,
+		OOMWindow:          r.OOMWindow,
+		RestartCount:       r.RestartCount,
+		RestartWindow:      r.RestartWindow,
+		Events:             r.Events,
+		ImageDrift:         r.ImageDrift,
+		OlderThan:          r.OlderThan,
+		Action:             r.Action,
+	}
+}
+
+// APIResponse is the body of every API answer.
+type APIResponse struct {
+	Results []Result `json:"results"`
+	// Error is set when the request was rejected or the run could not list
+	// some of its namespaces or pods.
+	Error string `json:"error,omitempty"`
+}
+
+// APIServer exposes the restart engine over HTTP:
+//
+//	POST /restart     restarts the workloads behind the pods the body selects
+//	GET  /candidates  reports what a restart with the query's selection would do
+//
+// Every request is a run of its own, with its own restart limit.
+type APIServer struct {
+	Client  kubernetes.Interface
+	Options RunOptions
+	// RunContext derives the context of a single request from BaseContext,
+	// e.g. to apply --timeout.
+	RunContext func(ctx context.Context) (context.Context, context.CancelFunc)
+	// BaseContext outlives single requests, so a restart in progress is not
+	// abandoned when its client disconnects.
+	BaseContext context.Context
+	// Output receives the results of every restart request.
+	Output io.Writer
+	Format string
+
+	writeMu sync.Mutex
+}
+
+// Handler returns the API's routes.
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/restart", s.handleRestart)
+	mux.HandleFunc("/candidates", s.handleCandidates)
+	return mux
+}
+
+func (s *APIServer) handleRestart(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("only POST is supported"))
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxRequestBody))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("error reading the request: %v", err))
+		return
+	}
+	var request RestartRequest
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	options := s.Options
+	options.DryRun = options.DryRun || request.DryRun
+	s.run(w, request.rule(), options)
+}
+
+func (s *APIServer) handleCandidates(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("only GET is supported"))
+		return
+	}
+	rule, err := queryRule(req.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	options := s.Options
+	options.DryRun = true
+	s.run(w, rule, options)
+}
+
+// run validates the rule, processes it and answers with its results.
+func (s *APIServer) run(w http.ResponseWriter, rule *Rule, options RunOptions) {
+	if err := validateRequestRule(rule, s.Options.Scope); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := s.RunContext(s.BaseContext)
+	defer cancel()
+	results, err := NewRunner(s.Client, options).ProcessRule(ctx, rule)
+	if !options.DryRun {
+		s.writeMu.Lock()
+		if err := WriteResults(s.Output, s.Format, results); err != nil {
+			errorf("Error writing results: %v\n", err)
+		}
+		s.writeMu.Unlock()
+	}
+
+	response := APIResponse{Results: results}
+	status := http.StatusOK
+	if err != nil {
+		response.Error, status = err.Error(), http.StatusBadGateway
+	}
+	if results == nil {
+		response.Results = []Result{}
+	}
+	writeAPIResponse(w, status, response)
+}
+
+// validateRequestRule validates a rule received over an API. Unlike the
+// selection flags, such a rule must say which pods it selects, and in
+// namespace scope which namespaces. Hooks run commands on the server or in
+// the cluster's pods and are never accepted from callers, nor are settings
+// that would override the server's guardrails.
+func validateRequestRule(rule *Rule, scope string) error {
+	if len(rule.PreRestart) > 0 || len(rule.PostRestart) > 0 {
+		return fmt.Errorf("preRestart and postRestart hooks cannot be set over the API")
+	}
+	if rule.Strategy != "" || rule.Cooldown != nil || len(rule.MaintenanceWindows) > 0 {
+		return fmt.Errorf("strategy, cooldown and maintenanceWindows cannot be set over the API")
+	}
+	if field, err := rule.Validate(); err != nil {
+		return fmt.Errorf("invalid %s: %v", field, err)
+	}
+	if rule.PodSelector == "" && len(rule.Match) == 0 {
+		return fmt.Errorf("one of podSelector or match is required")
+	}
+	if scope == ScopeNamespace && (len(rule.Namespaces) == 0 || rule.NamespaceSelector != "") {
+		return fmt.Errorf("namespaces are required and namespaceSelector is not supported with --scope=%s", ScopeNamespace)
+	}
+	return nil
+}
+
+// queryRule builds a rule from the query parameters of GET /candidates.
+// namespace and match may be repeated.
+func queryRule(query url.Values) (*Rule, error) {
+	rule := &Rule{
+		Name:              "api",
+		Namespaces:        query["namespace"],
+		NamespaceSelector: query.Get("namespaceSelector"),
+		PodSelector:       query.Get("podSelector"),
+		FieldSelector:     query.Get("fieldSelector"),
+		Match:             query["match"],
+		OlderThan:         query.Get("olderThan"),
+	}
+	if value := query.Get("onlyUnhealthy"); value != "" {
+		onlyUnhealthy, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid onlyUnhealthy: %v", err)
+		}
+		rule.OnlyUnhealthy = onlyUnhealthy
+	}
+	return rule, nil
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIResponse(w, status, APIResponse{Results: []Result{}, Error: err.Error()})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, response APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		debugf("Error writing the response: %v\n", err)
+	}
+}
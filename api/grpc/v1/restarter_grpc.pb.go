@@ -0,0 +1,175 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: api/grpc/v1/restarter.proto
+
+package restarterv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// RestarterClient is the client API for Restarter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RestarterClient interface {
+	// ListCandidates reports what a restart of the selection would do.
+	ListCandidates(ctx context.Context, in *ListCandidatesRequest, opts ...grpc.CallOption) (*ListCandidatesResponse, error)
+	// Restart restarts the workloads behind the selected pods and streams the
+	// progress of every workload as it happens, ending with its result.
+	Restart(ctx context.Context, in *RestartRequest, opts ...grpc.CallOption) (Restarter_RestartClient, error)
+}
+
+type restarterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRestarterClient(cc grpc.ClientConnInterface) RestarterClient {
+	return &restarterClient{cc}
+}
+
+func (c *restarterClient) ListCandidates(ctx context.Context, in *ListCandidatesRequest, opts ...grpc.CallOption) (*ListCandidatesResponse, error) {
+	out := new(ListCandidatesResponse)
+	err := c.cc.Invoke(ctx, "/restarter.v1.Restarter/ListCandidates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *restarterClient) Restart(ctx context.Context, in *RestartRequest, opts ...grpc.CallOption) (Restarter_RestartClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Restarter_ServiceDesc.Streams[0], "/restarter.v1.Restarter/Restart", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &restarterRestartClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Restarter_RestartClient interface {
+	Recv() (*RestartEvent, error)
+	grpc.ClientStream
+}
+
+type restarterRestartClient struct {
+	grpc.ClientStream
+}
+
+func (x *restarterRestartClient) Recv() (*RestartEvent, error) {
+	m := new(RestartEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RestarterServer is the server API for Restarter service.
+// All implementations must embed UnimplementedRestarterServer
+// for forward compatibility
+type RestarterServer interface {
+	// ListCandidates reports what a restart of the selection would do.
+	ListCandidates(context.Context, *ListCandidatesRequest) (*ListCandidatesResponse, error)
+	// Restart restarts the workloads behind the selected pods and streams the
+	// progress of every workload as it happens, ending with its result.
+	Restart(*RestartRequest, Restarter_RestartServer) error
+	mustEmbedUnimplementedRestarterServer()
+}
+
+// UnimplementedRestarterServer must be embedded to have forward compatible implementations.
+type UnimplementedRestarterServer struct {
+}
+
+func (UnimplementedRestarterServer) ListCandidates(context.Context, *ListCandidatesRequest) (*ListCandidatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCandidates not implemented")
+}
+func (UnimplementedRestarterServer) Restart(*RestartRequest, Restarter_RestartServer) error {
+	return status.Errorf(codes.Unimplemented, "method Restart not implemented")
+}
+func (UnimplementedRestarterServer) mustEmbedUnimplementedRestarterServer() {}
+
+// UnsafeRestarterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RestarterServer will
+// result in compilation errors.
+type UnsafeRestarterServer interface {
+	mustEmbedUnimplementedRestarterServer()
+}
+
+func RegisterRestarterServer(s grpc.ServiceRegistrar, srv RestarterServer) {
+	s.RegisterService(&Restarter_ServiceDesc, srv)
+}
+
+func _Restarter_ListCandidates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCandidatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RestarterServer).ListCandidates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/restarter.v1.Restarter/ListCandidates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RestarterServer).ListCandidates(ctx, req.(*ListCandidatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Restarter_Restart_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RestartRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RestarterServer).Restart(m, &restarterRestartServer{stream})
+}
+
+type Restarter_RestartServer interface {
+	Send(*RestartEvent) error
+	grpc.ServerStream
+}
+
+type restarterRestartServer struct {
+	grpc.ServerStream
+}
+
+func (x *restarterRestartServer) Send(m *RestartEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Restarter_ServiceDesc is the grpc.ServiceDesc for Restarter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Restarter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "restarter.v1.Restarter",
+	HandlerType: (*RestarterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListCandidates",
+			Handler:    _Restarter_ListCandidates_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Restart",
+			Handler:       _Restarter_Restart_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/grpc/v1/restarter.proto",
+}
@@ -0,0 +1,234 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestartPolicySpec selects pods the same way a rule in the rules file does
+// and says when the workloads behind them are restarted.
+type RestartPolicySpec struct {
+	// Namespaces limits the policy to these namespaces. Empty means every
+	// namespace selected by NamespaceSelector.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects namespaces by label when Namespaces is empty.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ExcludeNamespaces are skipped in addition to the operator's exclusions.
+	// +optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// PodSelector selects pods by label.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// FieldSelector is passed to the pod list call, e.g. status.phase=Running.
+	// +optional
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// Match holds pod name regular expressions combined with OR semantics.
+	// +optional
+	Match []string `json:"match,omitempty"`
+
+	// Triggers narrow the selected pods down to those worth acting on.
+	// +optional
+	Triggers RestartTriggers `json:"triggers,omitempty"`
+
+	// Interval is how often the policy is evaluated. Defaults to 5m.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Backoff re-evaluates the policy sooner than Interval after a failed
+	// evaluation, with exponentially growing delays.
+	// +optional
+	Backoff *Backoff `json:"backoff,omitempty"`
+	// Cooldown skips workloads restarted less than this long ago.
+	// +optional
+	Cooldown *metav1.Duration `json:"cooldown,omitempty"`
+	// MaintenanceWindows limit restarts to weekly windows such as
+	// "Sat 02:00-04:00 UTC". Restarts outside them are skipped.
+	// +optional
+	MaintenanceWindows []string `json:"maintenanceWindows,omitempty"`
+	// Action is either "restart" (default) or "report".
+	// +kubebuilder:validation:Enum=restart;report
+	// +optional
+	Action string `json:"action,omitempty"`
+	// Strategy is "rollout" (default), "evict", "delete" or "scale-cycle".
+	// +kubebuilder:validation:Enum=rollout;evict;delete;scale-cycle
+	// +optional
+	Strategy string `json:"strategy,omitempty"`
+	// MaxRestarts caps the restarts of a single evaluation. Zero means no
+	// limit.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRestarts int32 `json:"maxRestarts,omitempty"`
+	// DryRun reports the workloads that would be restarted without changing
+	// anything.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// Suspend stops evaluating the policy until it is cleared.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// RestartTriggers mirror the trigger fields of a rule. A pod qualifies when
+// any configured trigger fires; without triggers every selected pod does.
+type RestartTriggers struct {
+	// OnlyUnhealthy fires for pods in CrashLoopBackOff or ImagePullBackOff,
+	// or running but not Ready.
+	// +optional
+	OnlyUnhealthy bool `json:"onlyUnhealthy,omitempty"`
+	// OOMKills fires for containers OOM-killed within OOMWindow that have
+	// restarted at least this many times.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	OOMKills int32 `json:"oomKills,omitempty"`
+	// +optional
+	OOMWindow *metav1.Duration `json:"oomWindow,omitempty"`
+	// RestartCount fires for containers that restarted at least this many
+	// times, the last time within RestartWindow.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RestartCount int32 `json:"restartCount,omitempty"`
+	// +optional
+	RestartWindow *metav1.Duration `json:"restartWindow,omitempty"`
+	// Events fire for pods with repeated Kubernetes Events, such as failing
+	// liveness probes or FailedMount.
+	// +optional
+	Events []EventTrigger `json:"events,omitempty"`
+	// ImageDrift fires for pods whose image tag, e.g. :latest, now points to
+	// another digest in the registry than the one they run.
+	// +optional
+	ImageDrift bool `json:"imageDrift,omitempty"`
+	// OlderThan only lets through pods running at least this long, e.g. 30d.
+	// +optional
+	OlderThan string `json:"olderThan,omitempty"`
+}
+
+// EventTrigger fires when Events of a pod with Reason occurred at least Count
+// times within Window.
+type EventTrigger struct {
+	// Reason is the Event reason, e.g. Unhealthy or FailedMount.
+	Reason string `json:"reason"`
+	// Message, when set, must be contained in the Event message.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Count is how many occurrences fire the trigger. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Count int32 `json:"count,omitempty"`
+	// Window is how far back occurrences count. Defaults to 1h.
+	// +optional
+	Window *metav1.Duration `json:"window,omitempty"`
+}
+
+// Backoff delays re-evaluations after consecutive failures, starting at
+// Initial and multiplying by Factor up to Max, but never beyond the policy's
+// interval.
+type Backoff struct {
+	// Initial is the delay after the first failure. Defaults to 30s.
+	// +optional
+	Initial *metav1.Duration `json:"initial,omitempty"`
+	// Max caps the delay. Defaults to 10m.
+	// +optional
+	Max *metav1.Duration `json:"max,omitempty"`
+	// Factor multiplies the delay after every further failure. Defaults to 2.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Factor int32 `json:"factor,omitempty"`
+}
+
+// RestartPolicyStatus records the outcome of the last evaluation.
+type RestartPolicyStatus struct {
+	// ObservedGeneration is the generation the last evaluation used.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastRunTime is when the policy was last evaluated.
+	// +optional
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+	// Matched is the number of pods that matched in the last evaluation.
+	// +optional
+	Matched int32 `json:"matched,omitempty"`
+	// Restarted is the number of workloads restarted in the last evaluation.
+	// +optional
+	Restarted int32 `json:"restarted,omitempty"`
+	// Failed is the number of pods whose workload could not be handled in the
+	// last evaluation.
+	// +optional
+	Failed int32 `json:"failed,omitempty"`
+	// TotalRestarted is the number of workloads restarted by all evaluations
+	// so far.
+	// +optional
+	TotalRestarted int64 `json:"totalRestarted,omitempty"`
+	// ConsecutiveFailures counts the evaluations in a row that could not
+	// list their pods or failed a restart.
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+	// Workloads lists the workloads acted on in the last evaluation.
+	// +optional
+	Workloads []WorkloadStatus `json:"workloads,omitempty"`
+	// Error is set when the last evaluation could not list its pods.
+	// +optional
+	Error string `json:"error,omitempty"`
+	// Conditions are the Ready and Suspended conditions of the policy.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// Condition types of a RestartPolicy.
+const (
+	// ConditionReady is True when the last evaluation listed every pod and
+	// handled every matched workload.
+	ConditionReady = "Ready"
+	// ConditionSuspended is True while the policy is suspended.
+	ConditionSuspended = "Suspended"
+)
+
+// Condition reasons of a RestartPolicy.
+const (
+	ReasonEvaluated      = "Evaluated"
+	ReasonInvalidSpec    = "InvalidSpec"
+	ReasonListFailed     = "ListFailed"
+	ReasonRestartsFailed = "RestartsFailed"
+	ReasonSuspended      = "Suspended"
+	ReasonActive         = "Active"
+)
+
+// WorkloadStatus is the outcome for one workload.
+type WorkloadStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Status is the result status, e.g. restarted, skipped or failed.
+	Status string `json:"status"`
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// RestartPolicy declares which pods to watch and when to restart the
+// workloads that own them.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=rp
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Last Run",type=date,JSONPath=`.status.lastRunTime`
+// +kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matched`
+// +kubebuilder:printcolumn:name="Restarted",type=integer,JSONPath=`.status.restarted`
+// +kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+type RestartPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RestartPolicySpec   `json:"spec,omitempty"`
+	Status RestartPolicyStatus `json:"status,omitempty"`
+}
+
+// RestartPolicyList is a list of RestartPolicy objects.
+// +kubebuilder:object:root=true
+type RestartPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RestartPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RestartPolicy{}, &RestartPolicyList{})
+}
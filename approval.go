@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations of the two-phase approval workflow. With RequireApproval, a
+// workload that would be restarted is annotated AnnotationPendingApproval
+// instead, and is only restarted once a human or another system annotates it
+// AnnotationApproved=true. Both are removed after the restart.
+const (
+	AnnotationPendingApproval = "restarter.io/pending-approval"
+	AnnotationApproved        = "restarter.io/approved"
+)
+
+// approvalGranted reports whether the workload is annotated
+// restarter.io/approved=true.
+func approvalGranted(annotations map[string]string) bool {
+	value, ok := annotations[AnnotationApproved]
+	if !ok {
+		return false
+	}
+	approved, err := strconv.ParseBool(value)
+	if err != nil {
+		warnf("Ignoring invalid %s annotation value %q\n", AnnotationApproved, value)
+		return false
+	}
+	return approved
+}
+
+// RequestApproval annotates the workload as pending approval. The value says
+// when and why the restart was requested, e.g.
+// "2026-10-15T08:00:00Z: rule nightly, pod web-5d8f (CrashLoopBackOff)".
+func RequestApproval(ctx context.Context, workload *Workload, why string, client kubernetes.Interface) error {
+	value := time.Now().UTC().Format(time.RFC3339) + ": " + why
+	if err := patchAnnotations(ctx, workload, map[string]*string{AnnotationPendingApproval: &value}, client); err != nil {
+		return fmt.Errorf("error requesting approval: %w", err)
+	}
+	return nil
+}
+
+// ClearApproval removes the approval annotations after the approved restart,
+// so the next restart needs a new approval.
+func ClearApproval(ctx context.Context, workload *Workload, client kubernetes.Interface) error {
+	if err := patchAnnotations(ctx, workload, map[string]*string{AnnotationPendingApproval: nil, AnnotationApproved: nil}, client); err != nil {
+		return fmt.Errorf("error clearing the approval: %w", err)
+	}
+	return nil
+}
+
+// patchAnnotations sets the workload's annotations with a merge patch; nil
+// values remove them.
+func patchAnnotations(ctx context.Context, workload *Workload, annotations map[string]*string, client kubernetes.Interface) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	apps := client.AppsV1()
+	return callAPI(ctx, func(ctx context.Context) error {
+		var err error
+		switch workload.Kind {
+		case KindDeployment:
+			_, err = apps.Deployments(workload.Namespace).Patch(ctx, workload.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		case KindStatefulSet:
+			_, err = apps.StatefulSets(workload.Namespace).Patch(ctx, workload.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		case KindDaemonSet:
+			_, err = apps.DaemonSets(workload.Namespace).Patch(ctx, workload.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		default:
+			err = fmt.Errorf("unsupported workload kind %s", workload.Kind)
+		}
+		return err
+	})
+}
+
+// requestApproval marks a workload that passed every check as pending
+// approval instead of restarting it. A workload already pending keeps its
+// original request.
+func (r *Runner) requestApproval(ctx context.Context, rule *Rule, workload *Workload, source string, result Result, log logFields) Result {
+	kind := strings.ToLower(workload.Kind)
+	result.Status, result.Reason = StatusSkipped, "pending approval"
+	if requested, ok := workload.Annotations[AnnotationPendingApproval]; ok {
+		log.infof("Skipping %s %s/%s: pending approval since %s\n", kind, workload.Namespace, workload.Name, requested)
+		return result
+	}
+	if r.Options.DryRun {
+		log.infof("[dry-run] Would request approval to restart %s %s/%s (%s)\n", kind, workload.Namespace, workload.Name, source)
+		result.Status = StatusDryRun
+		return result
+	}
+	why := source
+	if rule.Name != "" {
+		why = "rule " + rule.Name + ", " + source
+	}
+	if result.Trigger != "" {
+		why += " (" + result.Trigger + ")"
+	}
+	if err := RequestApproval(ctx, workload, why, r.Client); err != nil {
+		log.errorf("Error requesting approval to restart %s: %v\n", workload, err)
+		result.fail(err)
+		return result
+	}
+	log.infof("Requested approval to restart %s %s/%s: annotate it %s=true\n", kind, workload.Namespace, workload.Name, AnnotationApproved)
+	return result
+}
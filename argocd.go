@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Argo CD marks the resources an Application manages with one of these,
+// depending on its resource tracking method. The tracking ID is
+// "APP:GROUP/KIND:NAMESPACE/NAME", with APP prefixed by "NAMESPACE_" for
+// Applications outside Argo CD's own namespace.
+const (
+	AnnotationArgoCDTrackingID = "argocd.argoproj.io/tracking-id"
+	LabelArgoCDInstance        = "argocd.argoproj.io/instance"
+)
+
+// What to do with workloads managed by Argo CD.
+const (
+	// ArgoCDRestart restarts them like any other workload.
+	ArgoCDRestart = "restart"
+	// ArgoCDSkip leaves them to Argo CD.
+	ArgoCDSkip = "skip"
+)
+
+// argoCDTimeout bounds each request to the Argo CD API server.
+const argoCDTimeout = 30 * time.Second
+
+// ValidateArgoCDMode rejects unknown --argocd values.
+func ValidateArgoCDMode(mode string) error {
+	switch mode {
+	case ArgoCDRestart, ArgoCDSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q (want %s or %s)", mode, ArgoCDRestart, ArgoCDSkip)
+}
+
+// ArgoCDApplication returns the Argo CD Application that manages the
+// workload, as NAME or NAMESPACE_NAME, or "" when there is none.
+func ArgoCDApplication(workload *Workload) string {
+	if id := workload.Annotations[AnnotationArgoCDTrackingID]; id != "" {
+		if app, _, found := strings.Cut(id, ":"); found && app != "" {
+			return app
+		}
+	}
+	if obj, ok := workload.Object.(metav1.Object); ok {
+		return obj.GetLabels()[LabelArgoCDInstance]
+	}
+	return ""
+}
+
+// ArgoCD governs workloads managed by Argo CD. Restarts themselves are not
+// reverted by Argo CD, even with self-healing: the restartedAt annotation is
+// applied with a field manager of its own and is not part of the desired
+// state Argo CD compares against. With Server set, the owning Application is
+// also synced after each restart, so Argo CD reconciles, and shows, the
+// restarted workload straight away. A nil ArgoCD restarts Argo CD managed
+// workloads without syncing.
+type ArgoCD struct {
+	// Mode is ArgoCDRestart or ArgoCDSkip.
+	Mode string
+	// Server is the base URL of the Argo CD API server, e.g.
+	// https://argocd.example.com, and Token a bearer token allowed to sync
+	// the Applications.
+	Server string
+	Token  string
+	HTTP   *http.Client
+}
+
+// skips reports whether workloads of app are left alone.
+func (a *ArgoCD) skips(app string) bool {
+	return a != nil && app != "" && a.Mode == ArgoCDSkip
+}
+
+// Sync asks the Argo CD API server to sync the Application app.
+func (a *ArgoCD) Sync(ctx context.Context, app string) error {
+	if a == nil || a.Server == "" {
+		return nil
+	}
+	endpoint := strings.TrimSuffix(a.Server, "/") + "/api/v1/applications/"
+	if namespace, name, found := strings.Cut(app, "_"); found {
+		endpoint += url.PathEscape(name) + "/sync?appNamespace=" + url.QueryEscape(namespace)
+	} else {
+		endpoint += url.PathEscape(app) + "/sync"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader([]byte(`{"prune":false}`)))
+	if err != nil {
+		return fmt.Errorf("error creating the sync request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+	client := a.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error syncing Argo CD application %s: %v", app, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("error syncing Argo CD application %s: %s: %s", app, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
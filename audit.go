@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// AuditLog appends a JSON line to a file for every mutating action: every
+// restart, eviction, pause, resume, undo and orphan deletion that was
+// attempted, whether it succeeded or not. Dry runs and skipped workloads are not
+// recorded. A nil AuditLog records nothing.
+type AuditLog struct {
+	host string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time  time.Time  `json:"time"`
+	Actor AuditActor `json:"actor"`
+	// Action is restart, evict, pause, resume or undo for workloads, and delete
+	// or evict for orphaned pods, whose Target kind is Pod.
+	Action string      `json:"action"`
+	Target AuditTarget `json:"target"`
+	Rule   string      `json:"rule,omitempty"`
+	// Pod is the matched pod that selected the target.
+	Pod     string `json:"pod,omitempty"`
+	Trigger string `json:"trigger,omitempty"`
+	// Source says what asked for the action, e.g. "pod web-5d8f" or
+	// "configuration change".
+	Source  string `json:"source,omitempty"`
+	Status  string `json:"status"`
+	Rollout string `json:"rollout,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditActor identifies who took an action.
+type AuditActor struct {
+	User string `json:"user"`
+	Host string `json:"host"`
+}
+
+// AuditTarget is the object an action changed.
+type AuditTarget struct {
+	// Cluster is set in runs against several clusters.
+	Cluster   string `json:"cluster,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// OpenAuditLog opens, or creates, the audit file for appending.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the audit log: %v", err)
+	}
+	host, _ := os.Hostname()
+	return &AuditLog{file: file, host: host}, nil
+}
+
+// Record appends the outcome of an action on the result's workload, taken as
+// the Kubernetes identity actor. Write errors are logged; they do not undo or
+// fail the action.
+func (a *AuditLog) Record(action, actor string, result Result, source string) {
+	if a == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:    time.Now().UTC(),
+		Actor:   AuditActor{User: actor, Host: a.host},
+		Action:  action,
+		Target:  AuditTarget{Cluster: result.Cluster, Kind: result.Kind, Namespace: result.Namespace, Name: result.Workload},
+		Rule:    result.Rule,
+		Pod:     result.Pod,
+		Trigger: result.Trigger,
+		Source:  source,
+		Status:  result.Status,
+		Rollout: result.Rollout,
+		Error:   result.Error,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		errorf("Error encoding the audit entry: %v\n", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		errorf("Error writing the audit log: %v\n", err)
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		errorf("Error syncing the audit log: %v\n", err)
+	}
+}
+
+// configIdentity returns the user a client config authenticates as, as far
+// as it can be told without asking the API server: the basic auth user, the
+// common name of the client certificate, or the subject of a JWT bearer
+// token such as a service account token. Credentials obtained by exec or
+// auth provider plugins are not known in advance and yield "unknown".
+func configIdentity(config *rest.Config) string {
+	if config.Username != "" {
+		return config.Username
+	}
+	certData := config.CertData
+	if len(certData) == 0 && config.CertFile != "" {
+		certData, _ = os.ReadFile(config.CertFile)
+	}
+	if block, _ := pem.Decode(certData); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil && cert.Subject.CommonName != "" {
+			return cert.Subject.CommonName
+		}
+	}
+	token := config.BearerToken
+	if token == "" && config.BearerTokenFile != "" {
+		data, _ := os.ReadFile(config.BearerTokenFile)
+		token = strings.TrimSpace(string(data))
+	}
+	if subject := tokenSubject(token); subject != "" {
+		return subject
+	}
+	return "unknown"
+}
+
+// tokenSubject returns the sub claim of a JWT, without verifying it.
+func tokenSubject(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults of a backoff that leaves fields unset.
+const (
+	DefaultBackoffInitial = 30 * time.Second
+	DefaultBackoffMax     = 10 * time.Minute
+	DefaultBackoffFactor  = 2
+)
+
+// Backoff retries a failed run sooner than its next regular run, with delays
+// growing exponentially from Initial by Factor up to Max. A run fails when
+// some namespaces or pods cannot be listed or a restart fails.
+type Backoff struct {
+	Initial time.Duration `yaml:"initial"`
+	Max     time.Duration `yaml:"max"`
+	Factor  int32         `yaml:"factor"`
+}
+
+// validate checks the backoff and fills in defaults.
+func (b *Backoff) validate() error {
+	if b.Initial < 0 || b.Max < 0 {
+		return fmt.Errorf("backoff durations must not be negative")
+	}
+	if b.Factor < 0 {
+		return fmt.Errorf("backoff factor must not be negative")
+	}
+	if b.Initial == 0 {
+		b.Initial = DefaultBackoffInitial
+	}
+	if b.Max == 0 {
+		b.Max = DefaultBackoffMax
+	}
+	if b.Factor == 0 {
+		b.Factor = DefaultBackoffFactor
+	}
+	if b.Max < b.Initial {
+		return fmt.Errorf("backoff max %s is shorter than initial %s", b.Max, b.Initial)
+	}
+	return nil
+}
+
+// Delay returns how long to wait before retrying after the given number of
+// consecutive failures, at least one.
+func (b Backoff) Delay(failures int) time.Duration {
+	delay := b.Initial
+	for i := 1; i < failures && delay < b.Max; i++ {
+		delay *= time.Duration(b.Factor)
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
+
+// runFailed reports whether a run backs off: it could not list everything or
+// a restart failed.
+func runFailed(results []Result, listFailed bool) bool {
+	if listFailed {
+		return true
+	}
+	for _, result := range results {
+		if result.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// WorkloadCache serves the pods, namespaces and owners the scans of the
+// long-running modes read from shared informers, instead of listing and
+// getting them from the API server on every scan. Writes, and the re-read
+// of a workload right before it is restarted, still go to the API server.
+// A nil WorkloadCache reads everything from the API server.
+type WorkloadCache struct {
+	pods corelisters.PodLister
+	// namespaces is nil in namespace scope, which never reads Namespace
+	// objects.
+	namespaces   corelisters.NamespaceLister
+	replicaSets  appslisters.ReplicaSetLister
+	deployments  appslisters.DeploymentLister
+	statefulSets appslisters.StatefulSetLister
+	daemonSets   appslisters.DaemonSetLister
+}
+
+// NewWorkloadCache registers the informers of the cache with the factory,
+// which must be started and synced before the cache is used. Without
+// namespaces, Namespace objects are not cached.
+func NewWorkloadCache(factory informers.SharedInformerFactory, namespaces bool) *WorkloadCache {
+	apps := factory.Apps().V1()
+	c := &WorkloadCache{
+		pods:         factory.Core().V1().Pods().Lister(),
+		replicaSets:  apps.ReplicaSets().Lister(),
+		deployments:  apps.Deployments().Lister(),
+		statefulSets: apps.StatefulSets().Lister(),
+		daemonSets:   apps.DaemonSets().Lister(),
+	}
+	if namespaces {
+		c.namespaces = factory.Core().V1().Namespaces().Lister()
+	}
+	return c
+}
+
+// startWorkloadCache starts a cache of the cluster, or of the single
+// namespace the rules target in namespace scope, and waits until it has
+// synced or ctx is cancelled.
+func startWorkloadCache(ctx context.Context, client kubernetes.Interface, rules []Rule, scope string) (*WorkloadCache, error) {
+	var factoryOptions []informers.SharedInformerOption
+	if scope == ScopeNamespace {
+		namespace, err := singleNamespace(rules)
+		if err != nil {
+			return nil, err
+		}
+		factoryOptions = append(factoryOptions, informers.WithNamespace(namespace))
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, factoryOptions...)
+	workloadCache := NewWorkloadCache(factory, scope != ScopeNamespace)
+	factory.Start(ctx.Done())
+	infof("Waiting for the workload caches to sync\n")
+	markSynced := cacheSync.syncing("workloads")
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("error syncing the %v cache", informerType)
+		}
+	}
+	markSynced()
+	return workloadCache, nil
+}
+
+// EachPod calls fn for the cached pods of the namespace that the rule's pod
+// and field selectors select, in the order of their names, as listed from
+// the API server. The pods are shared with the cache and must not be
+// modified.
+func (c *WorkloadCache) EachPod(namespace string, rule *Rule, fn func(*v1.Pod)) error {
+	selector, err := labels.Parse(rule.podSelector())
+	if err != nil {
+		return fmt.Errorf("invalid pod selector: %v", err)
+	}
+	pods, err := c.pods.Pods(namespace).List(selector)
+	if err != nil {
+		return fmt.Errorf("error reading pods from the cache: %v", err)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	for _, pod := range pods {
+		if ruleSelectsPodFields(rule, pod) {
+			fn(pod)
+		}
+	}
+	return nil
+}
+
+// Namespaces returns the names of the cached namespaces the selector
+// selects.
+func (c *WorkloadCache) Namespaces(selector string) ([]string, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %v", err)
+	}
+	namespaces, err := c.namespaces.List(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("error reading namespaces from the cache: %v", err)
+	}
+	names := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		names = append(names, namespace.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// NamespaceAnnotations returns the annotations of a cached namespace, none
+// in namespace scope.
+func (c *WorkloadCache) NamespaceAnnotations(name string) map[string]string {
+	if c.namespaces == nil {
+		return nil
+	}
+	namespace, err := c.namespaces.Get(name)
+	if err != nil {
+		warnf("Could not read annotations of namespace %s: %v\n", name, err)
+		return nil
+	}
+	return namespace.Annotations
+}
+
+// ReplicaSet implements ownerReader. Objects are copied out of the cache, as
+// workloads are modified by the restart.
+func (c *WorkloadCache) ReplicaSet(_ context.Context, namespace, name string) (*appsv1.ReplicaSet, error) {
+	replicaSet, err := c.replicaSets.ReplicaSets(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return replicaSet.DeepCopy(), nil
+}
+
+// Deployment implements ownerReader.
+func (c *WorkloadCache) Deployment(_ context.Context, namespace, name string) (*appsv1.Deployment, error) {
+	deployment, err := c.deployments.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return deployment.DeepCopy(), nil
+}
+
+// StatefulSet implements ownerReader.
+func (c *WorkloadCache) StatefulSet(_ context.Context, namespace, name string) (*appsv1.StatefulSet, error) {
+	statefulSet, err := c.statefulSets.StatefulSets(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return statefulSet.DeepCopy(), nil
+}
+
+// DaemonSet implements ownerReader.
+func (c *WorkloadCache) DaemonSet(_ context.Context, namespace, name string) (*appsv1.DaemonSet, error) {
+	daemonSet, err := c.daemonSets.DaemonSets(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return daemonSet.DeepCopy(), nil
+}
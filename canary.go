@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Canary states of a Runner.
+const (
+	canaryUnpicked = iota
+	canaryRunning
+	canaryPassed
+	canaryFailed
+)
+
+// CanaryOptions configures canary runs, where the first matched Deployment
+// is restarted and verified alone before any other workload is touched.
+type CanaryOptions struct {
+	// Enabled turns canary runs on.
+	Enabled bool
+	// HealthURL, when set, must answer GET with a 2xx status within
+	// HealthTimeout once the canary's rollout finished.
+	HealthURL     string
+	HealthTimeout time.Duration
+}
+
+// needsCanary reports whether the run still has to pick its canary.
+func (r *Runner) needsCanary() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Options.Canary.Enabled && r.canary == canaryUnpicked
+}
+
+// mayBeCanary reports whether the pod may belong to a Deployment, the only
+// kind of canary, judging by its controller: pods of StatefulSets and
+// DaemonSets are held back until the canary is settled.
+func mayBeCanary(pod *v1.Pod) bool {
+	owner := metav1.GetControllerOf(pod)
+	return owner != nil && owner.Kind == "ReplicaSet"
+}
+
+// takeCanary makes workload the canary of the run if it is a Deployment and
+// none was picked yet, and reports whether it did.
+func (r *Runner) takeCanary(workload *Workload) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.Options.Canary.Enabled || r.canary != canaryUnpicked || workload.Kind != KindDeployment {
+		return false
+	}
+	r.canary, r.canaryWorkload = canaryRunning, workload.String()
+	return true
+}
+
+// settleCanary records whether the canary passed.
+func (r *Runner) settleCanary(passed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.canary = canaryFailed
+	if passed {
+		r.canary = canaryPassed
+	}
+}
+
+// canaryFailure returns why the rest of the run is aborted, or "" when the
+// canary did not fail.
+func (r *Runner) canaryFailure() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.canary != canaryFailed {
+		return ""
+	}
+	return "canary " + r.canaryWorkload + " failed"
+}
+
+// verifyCanary checks the canary's health URL, if any, after its rollout
+// finished.
+func verifyCanary(ctx context.Context, options CanaryOptions) error {
+	if options.HealthURL == "" {
+		return nil
+	}
+	return WaitForProbe(ctx, options.HealthURL, options.HealthTimeout)
+}
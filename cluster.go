@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// clusterKey is the context key of the cluster a run acts on.
+type clusterKey struct{}
+
+// withCluster returns a context for a run against one of several clusters,
+// named after its kubeconfig context. The name is attached to the run's
+// results, log messages, metrics and prompts.
+func withCluster(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, clusterKey{}, name)
+}
+
+// contextCluster returns the cluster of a multi-cluster run, or "" for a run
+// against a single cluster.
+func contextCluster(ctx context.Context) string {
+	name, _ := ctx.Value(clusterKey{}).(string)
+	return name
+}
+
+// logFor returns the log fields of ctx: the cluster, when there is one.
+func logFor(ctx context.Context) logFields {
+	if cluster := contextCluster(ctx); cluster != "" {
+		return withFields("cluster", cluster)
+	}
+	return nil
+}
+
+// clusterPrefix returns "[name] " when ctx names a cluster, to tell apart
+// the prompts and text messages of parallel runs.
+func clusterPrefix(ctx context.Context) string {
+	if cluster := contextCluster(ctx); cluster != "" {
+		return "[" + cluster + "] "
+	}
+	return ""
+}
+
+// clusterRun is the part of a multi-cluster run that acts on one cluster.
+type clusterRun struct {
+	Name    string
+	Client  kubernetes.Interface
+	Options RunOptions
+	Rules   []Rule
+}
+
+// runClusters processes every cluster concurrently, a Runner each, and
+// returns the report of each cluster in order. MaxRestarts applies to each
+// cluster on its own.
+func runClusters(ctx context.Context, runs []clusterRun) []RunReport {
+	reports := make([]RunReport, len(runs))
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runner := NewRunner(runs[i].Client, runs[i].Options)
+			runner.Run(withCluster(ctx, runs[i].Name), runs[i].Rules)
+			reports[i] = runner.Report()
+		}(i)
+	}
+	wg.Wait()
+	return reports
+}
+
+// mergeReports combines the reports of the clusters of a run that started
+// at started.
+func mergeReports(options RunOptions, started time.Time, reports []RunReport) RunReport {
+	var results []Result
+	failed := false
+	namespaces, pods := 0, 0
+	for _, report := range reports {
+		results = append(results, report.Results...)
+		failed = failed || report.ListFailed
+		namespaces += report.Namespaces
+		pods += report.Pods
+	}
+	report := newRunReport(options, started, results, failed)
+	report.Namespaces, report.Pods = namespaces, pods
+	return report
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newAdmissionCommand(opts *globalOptions) *cobra.Command {
+	var listen, path, certFile, keyFile, protectedSelector string
+	var freezeSpecs []string
+	cmd := &cobra.Command{
+		Use:   "admission",
+		Short: "Serve a validating admission webhook that freezes restarts",
+		Long: `admission serves a validating admission webhook that rejects rollout
+restarts, i.e. changes of the kubectl.kubernetes.io/restartedAt pod template
+annotation, of protected deployments, statefulsets and daemonsets during the
+freeze windows. It applies to restarts by anyone, not only by this tool.
+
+A workload is protected when it is annotated restarter.io/protected=true or its
+labels match --protected-selector; restarter.io/protected=false exempts it.
+The API server only calls webhooks over HTTPS, so --tls-cert-file and
+--tls-key-file are required; both are reloaded when they change. See
+config/webhook for a ValidatingWebhookConfiguration.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(freezeSpecs) == 0 {
+				return configError("at least one --freeze-window is required")
+			}
+			webhook := &FreezeWebhook{}
+			for _, spec := range freezeSpecs {
+				window, err := ParseMaintenanceWindow(spec)
+				if err != nil {
+					return configError("invalid --freeze-window: %v", err)
+				}
+				webhook.Windows = append(webhook.Windows, window)
+			}
+			if protectedSelector != "" {
+				selector, err := labels.Parse(protectedSelector)
+				if err != nil {
+					return configError("invalid --protected-selector: %v", err)
+				}
+				webhook.Selector = selector
+			}
+			if certFile == "" || keyFile == "" {
+				return configError("--tls-cert-file and --tls-key-file are required")
+			}
+			certificates := &certificateFiles{certFile: certFile, keyFile: keyFile}
+			if _, err := certificates.GetCertificate(nil); err != nil {
+				return configError("%v", err)
+			}
+			return runAdmission(cmd.Context(), listen, path, webhook, certificates)
+		},
+	}
+	cmd.Flags().StringVar(&listen, "listen", ":8443", "address to serve the webhook on")
+	cmd.Flags().StringVar(&path, "path", "/validate", "HTTP path of the webhook")
+	cmd.Flags().StringVar(&certFile, "tls-cert-file", "", "file holding the serving certificate, PEM encoded")
+	cmd.Flags().StringVar(&keyFile, "tls-key-file", "", "file holding the key of the serving certificate, PEM encoded")
+	cmd.Flags().StringArrayVar(&freezeSpecs, "freeze-window", nil, "weekly window in which restarts of protected workloads are rejected, e.g. \"Fri 16:00-23:59 UTC\"; repeatable")
+	cmd.Flags().StringVar(&protectedSelector, "protected-selector", "", "label selector of workloads protected without an annotation")
+	return cmd
+}
+
+func runAdmission(ctx context.Context, listen, path string, webhook *FreezeWebhook, certificates *certificateFiles) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.Handle(path, webhook)
+	infof("Serving the admission webhook at %s with %d freeze window(s)\n", path, len(webhook.Windows))
+	return serveHTTP(ctx, listen, mux, &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certificates.GetCertificate,
+	})
+}
+
+// certificateFiles loads a certificate and its key from files, reloading them
+// when they change so rotated certificates, e.g. by cert-manager, are picked
+// up without a restart.
+type certificateFiles struct {
+	certFile, keyFile string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modified    time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate. When a reload fails,
+// the previous certificate is kept.
+func (c *certificateFiles) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	modified, err := c.lastModified()
+	if err == nil && c.certificate != nil && !modified.After(c.modified) {
+		return c.certificate, nil
+	}
+	if err == nil {
+		var certificate tls.Certificate
+		if certificate, err = tls.LoadX509KeyPair(c.certFile, c.keyFile); err == nil {
+			if c.certificate != nil {
+				infof("Reloaded the serving certificate\n")
+			}
+			c.certificate, c.modified = &certificate, modified
+			return c.certificate, nil
+		}
+	}
+	if c.certificate == nil {
+		return nil, fmt.Errorf("error loading the serving certificate: %v", err)
+	}
+	warnf("Keeping the previous serving certificate: %v\n", err)
+	return c.certificate, nil
+}
+
+// lastModified returns the later modification time of the two files.
+func (c *certificateFiles) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newAlertmanagerCommand(opts *globalOptions) *cobra.Command {
+	var listen, path, namespaceLabel string
+	var wait bool
+	var waitTimeout time.Duration
+	var strategy, pdbCheck string
+	var dryRun bool
+	var metrics metricsOptions
+	var health healthOptions
+	cmd := &cobra.Command{
+		Use:   "alertmanager",
+		Short: "Restart the workloads named by Prometheus Alertmanager alerts",
+		Long: `alertmanager serves a webhook receiver for Prometheus Alertmanager and
+restarts the workload each firing alert names through its labels: a namespace
+label plus a deployment, statefulset or daemonset label, or a pod label whose
+owner is restarted. Resolved alerts are ignored. --namespace limits the
+namespaces alerts may act on; the other pod selection flags and --config do
+not apply.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := opts.runOptions()
+			options.DryRun = dryRun
+			options.Wait, options.WaitTimeout = wait, waitTimeout
+			options.Strategy, options.PDBCheck = strategy, pdbCheck
+			if err := validateRestartOptions(options); err != nil {
+				return err
+			}
+			if namespaceLabel == "" {
+				return configError("invalid --namespace-label: must not be empty")
+			}
+			return runAlertmanager(cmd.Context(), opts, options, listen, path, namespaceLabel, &metrics, &health)
+		},
+	}
+	cmd.Flags().StringVar(&listen, "listen", ":9095", "address to serve the webhook on")
+	cmd.Flags().StringVar(&path, "path", "/alerts", "HTTP path of the webhook")
+	cmd.Flags().StringVar(&namespaceLabel, "namespace-label", "namespace", "alert label holding the namespace of the workload")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for each rollout to finish and report its status")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "how long --wait waits for a single rollout, and --strategy=evict for each pod")
+	cmd.Flags().StringVar(&strategy, "strategy", StrategyRollout, "how to restart workloads: rollout (bump the pod template), evict (evict pods one at a time, honoring PodDisruptionBudgets), delete (delete all pods at once) or scale-cycle (scale to zero and back)")
+	_ = cmd.RegisterFlagCompletionFunc("strategy", cobra.FixedCompletions(strategyNames(), cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&pdbCheck, "pdb-check", PDBCheckSkip, "what to do when a rollout restart would violate a PodDisruptionBudget: skip, warn or off")
+	_ = cmd.RegisterFlagCompletionFunc("pdb-check", cobra.FixedCompletions([]string{PDBCheckSkip, PDBCheckWarn, PDBCheckOff}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the workloads that would be restarted without changing anything")
+	metrics.addFlags(cmd.Flags())
+	health.addFlags(cmd.Flags())
+	return cmd
+}
+
+func runAlertmanager(ctx context.Context, opts *globalOptions, options RunOptions, listen, path, namespaceLabel string, metrics *metricsOptions, health *healthOptions) error {
+	client, err := opts.runClient(&options)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := metrics.start(ctx); err != nil {
+		return err
+	}
+	if err := health.start(ctx, client); err != nil {
+		return err
+	}
+
+	// Requests keep running on a context that survives the signal, so the
+	// server can let restarts in progress finish while it shuts down.
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiver := &AlertReceiver{
+		Client:         client,
+		Options:        options,
+		Namespaces:     opts.namespaces,
+		NamespaceLabel: namespaceLabel,
+		RunContext:     opts.runContext,
+		BaseContext:    base,
+		Output:         os.Stdout,
+		Format:         opts.output,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, receiver)
+	infof("Serving the Alertmanager webhook at %s\n", path)
+	return serveHTTP(ctx, listen, mux, nil)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newContextsCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "contexts",
+		Short: "List the contexts in the kubeconfig; the current one is marked with *",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := PrintContexts(opts.kubeconfig); err != nil {
+				return configError("error listing contexts: %v", err)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newDrainCommand(opts *globalOptions) *cobra.Command {
+	var wait bool
+	var waitTimeout time.Duration
+	var strategy, pdbCheck, nodeSelector string
+	var dryRun bool
+	var leader leaderElectionOptions
+	var metrics metricsOptions
+	var health healthOptions
+	cmd := &cobra.Command{
+		Use:   "drain",
+		Short: "Restart workloads off nodes as soon as they are cordoned",
+		Long: `drain watches the nodes and, when one is cordoned, e.g. by kubectl drain
+or a cluster autoscaler, rollout-restarts the workloads owning the matched
+pods scheduled on it. Their replacements start on other nodes while the old
+pods are still serving, instead of only after the drain evicts them, which
+makes failovers of databases and other stateful workloads gentler.
+
+Pods are selected by the usual flags or --config, narrowed to the cordoned
+node; triggers still apply when given. Nodes already cordoned when drain
+starts are left alone.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := opts.runOptions()
+			options.DryRun = dryRun
+			options.Wait, options.WaitTimeout = wait, waitTimeout
+			options.Strategy, options.PDBCheck = strategy, pdbCheck
+			if err := validateRestartOptions(options); err != nil {
+				return err
+			}
+			if _, err := labels.Parse(nodeSelector); err != nil {
+				return configError("invalid --node-selector: %v", err)
+			}
+			return runDrain(cmd.Context(), opts, options, nodeSelector, &leader, &metrics, &health)
+		},
+	}
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for each rollout to finish and report its status")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "how long --wait waits for a single rollout, and --strategy=evict for each pod")
+	cmd.Flags().StringVar(&strategy, "strategy", StrategyRollout, "how to restart workloads: rollout (bump the pod template), evict (evict pods one at a time, honoring PodDisruptionBudgets), delete (delete all pods at once) or scale-cycle (scale to zero and back)")
+	_ = cmd.RegisterFlagCompletionFunc("strategy", cobra.FixedCompletions(strategyNames(), cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&pdbCheck, "pdb-check", PDBCheckSkip, "what to do when a rollout restart would violate a PodDisruptionBudget: skip, warn or off")
+	_ = cmd.RegisterFlagCompletionFunc("pdb-check", cobra.FixedCompletions([]string{PDBCheckSkip, PDBCheckWarn, PDBCheckOff}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the workloads that would be restarted without changing anything")
+	cmd.Flags().StringVar(&nodeSelector, "node-selector", "", "label selector of the nodes to watch, e.g. node-role.kubernetes.io/database=")
+	leader.addFlags(cmd.Flags())
+	metrics.addFlags(cmd.Flags())
+	health.addFlags(cmd.Flags())
+	return cmd
+}
+
+func runDrain(ctx context.Context, opts *globalOptions, options RunOptions, nodeSelector string, leader *leaderElectionOptions, metrics *metricsOptions, health *healthOptions) error {
+	rules, err := opts.rules()
+	if err != nil {
+		return err
+	}
+	client, err := opts.runClient(&options)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := metrics.start(ctx); err != nil {
+		return err
+	}
+	if err := health.start(ctx, client); err != nil {
+		return err
+	}
+
+	watcher := &DrainWatcher{
+		Client:       client,
+		Rules:        rules,
+		Options:      options,
+		NodeSelector: nodeSelector,
+		RunContext:   opts.runContext,
+		Output:       os.Stdout,
+		Format:       opts.output,
+	}
+	return leader.run(ctx, client, watcher.Run)
+}
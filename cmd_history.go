@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func newHistoryCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "history",
+		Short: "List the last restart of every workload recorded in --history-configmap",
+		Long: `history lists the last restart of every workload recorded in the ConfigMap
+given by --history-configmap, most recent first. --namespace limits it to
+workloads in those namespaces.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.history == nil {
+				return configError("--history-configmap is required")
+			}
+			client, err := opts.clientset()
+			if err != nil {
+				return err
+			}
+			history := *opts.history
+			history.Client = client
+			entries, err := history.Entries(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if len(opts.namespaces) > 0 {
+				wanted := map[string]bool{}
+				for _, namespace := range opts.namespaces {
+					wanted[namespace] = true
+				}
+				kept := entries[:0]
+				for _, entry := range entries {
+					if wanted[entry.Namespace] {
+						kept = append(kept, entry)
+					}
+				}
+				entries = kept
+			}
+			return WriteHistory(os.Stdout, opts.output, entries)
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+
+	restarterv1alpha1 "github.com/testpractive123/assessment-devops.git/api/v1alpha1"
+)
+
+func newOperatorCommand(opts *globalOptions) *cobra.Command {
+	var wait bool
+	var waitTimeout time.Duration
+	var pdbCheck string
+	var dryRun bool
+	var leader leaderElectionOptions
+	var metrics metricsOptions
+	var health healthOptions
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Run as a cluster operator that evaluates RestartPolicy objects",
+		Long: `operator runs a controller that evaluates every RestartPolicy
+(restarter.io/v1alpha1) on its interval and records the outcome in the policy
+status. Install the CRD from config/crd first. The pod selection flags and
+--config do not apply; each policy carries its own selectors and triggers.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.scope != ScopeCluster {
+				return configError("the operator watches cluster-scoped RestartPolicies and needs --scope=%s", ScopeCluster)
+			}
+			options := opts.runOptions()
+			options.DryRun = dryRun
+			options.Wait, options.WaitTimeout = wait, waitTimeout
+			options.PDBCheck = pdbCheck
+			if err := validateRestartOptions(options); err != nil {
+				return err
+			}
+			return runOperator(cmd.Context(), opts, options, &leader, &metrics, &health)
+		},
+	}
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for each rollout to finish and report its status")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "how long --wait waits for a single rollout, and --strategy=evict for each pod")
+	cmd.Flags().StringVar(&pdbCheck, "pdb-check", PDBCheckSkip, "what to do when a rollout restart would violate a PodDisruptionBudget: skip, warn or off")
+	_ = cmd.RegisterFlagCompletionFunc("pdb-check", cobra.FixedCompletions([]string{PDBCheckSkip, PDBCheckWarn, PDBCheckOff}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "evaluate every policy as if it had dryRun set")
+	leader.addFlags(cmd.Flags())
+	metrics.addFlags(cmd.Flags())
+	health.addFlags(cmd.Flags())
+	return cmd
+}
+
+func runOperator(ctx context.Context, opts *globalOptions, options RunOptions, leader *leaderElectionOptions, metrics *metricsOptions, health *healthOptions) error {
+	ctrl.SetLogger(logr.New(logSink{}))
+	kubeConfig, err := opts.restConfig()
+	if err != nil {
+		return err
+	}
+	options.RestConfig = kubeConfig
+	clientset, err := opts.clientset()
+	if err != nil {
+		return err
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := restarterv1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	mgr, err := ctrl.NewManager(kubeConfig, ctrl.Options{
+		Scheme:                 scheme,
+		MetricsBindAddress:     metrics.bindAddress(),
+		HealthProbeBindAddress: health.bindAddress(),
+		// The manager runs its own election over the same Lease settings.
+		LeaderElection:                leader.enabled,
+		LeaderElectionResourceLock:    resourcelock.LeasesResourceLock,
+		LeaderElectionNamespace:       leader.leaseNamespace(),
+		LeaderElectionID:              leader.id,
+		LeaderElectionReleaseOnCancel: true,
+		LeaseDuration:                 &leader.leaseDuration,
+		RenewDeadline:                 &leader.renewDeadline,
+		RetryPeriod:                   &leader.retryPeriod,
+	})
+	if err != nil {
+		return configError("error creating the controller manager: %v", err)
+	}
+	if err := addHealthChecks(mgr, clientset); err != nil {
+		return configError("error setting up the health probes: %v", err)
+	}
+	reconciler := &RestartPolicyReconciler{Client: mgr.GetClient(), Clientset: clientset, Options: options}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		return configError("error setting up the RestartPolicy controller: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	infof("Starting the RestartPolicy operator\n")
+	return mgr.Start(ctx)
+}
+
+// addHealthChecks gives the manager's probes the checks of the other modes:
+// readyz fails until the manager's caches have synced and while the API server
+// does not answer.
+func addHealthChecks(mgr ctrl.Manager, clientset kubernetes.Interface) error {
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("caches", func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+		defer cancel()
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return fmt.Errorf("caches not synced")
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return mgr.AddReadyzCheck("apiserver", func(req *http.Request) error {
+		return checkAPIServer(req.Context(), clientset)
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newPauseCommand(opts *globalOptions) *cobra.Command {
+	return newSetPausedCommand(opts, OperationPause, "Pause the rollouts of the Deployments that own matching pods")
+}
+
+func newResumeCommand(opts *globalOptions) *cobra.Command {
+	return newSetPausedCommand(opts, OperationResume, "Resume the paused rollouts of the Deployments that own matching pods")
+}
+
+func newSetPausedCommand(opts *globalOptions, operation string, short string) *cobra.Command {
+	var dryRun, assumeYes bool
+	cmd := &cobra.Command{
+		Use:   operation,
+		Short: short,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := opts.runOptions()
+			options.Operation = operation
+			options.DryRun = dryRun
+			options.Confirmer = NewConfirmer(assumeYes)
+			return runOnce(cmd.Context(), opts, options)
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the deployments that would be changed without changing anything")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "do not ask for confirmation")
+	return cmd
+}
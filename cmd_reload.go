@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newReloadCommand(opts *globalOptions) *cobra.Command {
+	var wait bool
+	var waitTimeout, resync time.Duration
+	var strategy, pdbCheck string
+	var dryRun bool
+	var leader leaderElectionOptions
+	var metrics metricsOptions
+	var health healthOptions
+	cmd := &cobra.Command{
+		Use:   "reload",
+		Short: "Restart workloads when their ConfigMaps or Secrets change",
+		Long: `reload watches ConfigMaps and Secrets and rollout-restarts the deployments,
+statefulsets and daemonsets that use them when their contents change, so the
+pods pick up the new configuration.
+
+Workloads opt in with the restarter.io/reload=true annotation, which covers the
+ConfigMaps and Secrets their pod template mounts or reads environment
+variables from, or by listing names in restarter.io/reload-configmaps and
+restarter.io/reload-secrets. The hash of the contents is recorded in the
+restarter.io/config-hash annotation of each workload, so changes made while
+reload was not running are acted on when it starts.
+
+--namespace limits the watched namespaces; the other pod selection flags and
+--config do not apply.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := opts.runOptions()
+			options.DryRun = dryRun
+			options.Wait, options.WaitTimeout = wait, waitTimeout
+			options.Strategy, options.PDBCheck = strategy, pdbCheck
+			if err := validateRestartOptions(options); err != nil {
+				return err
+			}
+			if options.Scope == ScopeNamespace && len(opts.namespaces) != 1 {
+				return configError("--scope=%s watches a single namespace, got %d", ScopeNamespace, len(opts.namespaces))
+			}
+			if resync <= 0 {
+				return configError("invalid --resync: must be positive")
+			}
+			return runReload(cmd.Context(), opts, options, resync, &leader, &metrics, &health)
+		},
+	}
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for each rollout to finish and report its status")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "how long --wait waits for a single rollout, and --strategy=evict for each pod")
+	cmd.Flags().StringVar(&strategy, "strategy", StrategyRollout, "how to restart workloads: rollout (bump the pod template), evict (evict pods one at a time, honoring PodDisruptionBudgets), delete (delete all pods at once) or scale-cycle (scale to zero and back)")
+	_ = cmd.RegisterFlagCompletionFunc("strategy", cobra.FixedCompletions(strategyNames(), cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&pdbCheck, "pdb-check", PDBCheckSkip, "what to do when a rollout restart would violate a PodDisruptionBudget: skip, warn or off")
+	_ = cmd.RegisterFlagCompletionFunc("pdb-check", cobra.FixedCompletions([]string{PDBCheckSkip, PDBCheckWarn, PDBCheckOff}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the workloads that would be restarted without changing anything")
+	cmd.Flags().DurationVar(&resync, "resync", 10*time.Minute, "how often every workload is checked again, retrying skipped restarts")
+	leader.addFlags(cmd.Flags())
+	metrics.addFlags(cmd.Flags())
+	health.addFlags(cmd.Flags())
+	return cmd
+}
+
+func runReload(ctx context.Context, opts *globalOptions, options RunOptions, resync time.Duration, leader *leaderElectionOptions, metrics *metricsOptions, health *healthOptions) error {
+	client, err := opts.runClient(&options)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := metrics.start(ctx); err != nil {
+		return err
+	}
+	if err := health.start(ctx, client); err != nil {
+		return err
+	}
+
+	reloader := &Reloader{
+		Client:     client,
+		Options:    options,
+		Namespaces: opts.namespaces,
+		Resync:     resync,
+		RunContext: opts.runContext,
+		Output:     os.Stdout,
+		Format:     opts.output,
+	}
+	return leader.run(ctx, client, reloader.Run)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+func newRestartCommand(opts *globalOptions) *cobra.Command {
+	var deleteOrphans, evictOrphans bool
+	var wait, rollbackOnFailure, requireApproval bool
+	var waitTimeout time.Duration
+	var canary CanaryOptions
+	var strategy, pdbCheck string
+	var dryRun, assumeYes bool
+	cmd := &cobra.Command{
+		Use:   "restart",
+		Short: "Rollout-restart the workloads that own matching pods",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := opts.runOptions()
+			options.DryRun = dryRun
+			options.Wait, options.WaitTimeout = wait || rollbackOnFailure, waitTimeout
+			options.RollbackOnFailure = rollbackOnFailure
+			options.DeleteOrphans = deleteOrphans || evictOrphans
+			options.EvictOrphans = evictOrphans
+			options.Strategy, options.PDBCheck = strategy, pdbCheck
+			options.RequireApproval = requireApproval
+			options.Canary = canary
+			if err := validateRestartOptions(options); err != nil {
+				return err
+			}
+			options.Confirmer = NewConfirmer(assumeYes)
+			return runOnce(cmd.Context(), opts, options)
+		},
+	}
+	cmd.Flags().BoolVar(&deleteOrphans, "delete-orphans", false, "delete matched pods that have no controlling workload instead of failing")
+	cmd.Flags().BoolVar(&evictOrphans, "evict-orphans", false, "like --delete-orphans, but use the Eviction API so PodDisruptionBudgets are honored")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for each rollout to finish and report its status")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "how long --wait waits for a single rollout, and --strategy=evict for each pod")
+	cmd.Flags().BoolVar(&rollbackOnFailure, "rollback-on-failure", false, "roll back to the previous revision when a rollout does not become healthy within --wait-timeout (implies --wait)")
+	cmd.Flags().BoolVar(&canary.Enabled, "canary", false, "restart the first matched deployment alone, wait for its rollout and --canary-health-url, and abort the rest if it fails")
+	cmd.Flags().StringVar(&canary.HealthURL, "canary-health-url", "", "URL that must answer GET with a 2xx status once the canary's rollout finished")
+	cmd.Flags().DurationVar(&canary.HealthTimeout, "canary-health-timeout", DefaultHealthCheckTimeout, "how long --canary-health-url is retried before the canary fails")
+	cmd.Flags().BoolVar(&requireApproval, "require-approval", false, "annotate workloads that would be restarted "+AnnotationPendingApproval+" instead, and only restart those annotated "+AnnotationApproved+"=true")
+	cmd.Flags().StringVar(&strategy, "strategy", StrategyRollout, "how to restart workloads: rollout (bump the pod template), evict (evict pods one at a time, honoring PodDisruptionBudgets), delete (delete all pods at once) or scale-cycle (scale to zero and back)")
+	_ = cmd.RegisterFlagCompletionFunc("strategy", cobra.FixedCompletions(strategyNames(), cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&pdbCheck, "pdb-check", PDBCheckSkip, "what to do when a rollout restart would violate a PodDisruptionBudget: skip, warn or off")
+	_ = cmd.RegisterFlagCompletionFunc("pdb-check", cobra.FixedCompletions([]string{PDBCheckSkip, PDBCheckWarn, PDBCheckOff}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the workloads that would be restarted without changing anything")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "restart without asking for confirmation")
+	return cmd
+}
+
+func newListCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "Show matching pods and the workloads that would be restarted",
+		Long: `list resolves the workloads behind matching pods without changing anything.
+It is equivalent to "restart --dry-run" but defaults to table output.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("output") {
+				opts.output = OutputTable
+			}
+			options := opts.runOptions()
+			options.DryRun = true
+			return runOnce(cmd.Context(), opts, options)
+		},
+	}
+}
+
+// validateRestartOptions checks --strategy, --pdb-check, --canary-health-url
+// and the flags they cannot be combined with.
+func validateRestartOptions(options RunOptions) error {
+	if err := ValidateStrategy(options.Strategy); err != nil {
+		return configError("invalid --strategy: %v", err)
+	}
+	if err := ValidatePDBCheck(options.PDBCheck); err != nil {
+		return configError("invalid --pdb-check: %v", err)
+	}
+	if options.RollbackOnFailure && !restartStrategies[options.Strategy].RollsBack() {
+		return configError("--rollback-on-failure cannot be used with --strategy=%s", options.Strategy)
+	}
+	if options.Canary.HealthURL != "" && !options.Canary.Enabled {
+		return configError("--canary-health-url requires --canary")
+	}
+	if options.Canary.HealthURL != "" {
+		if err := validateHTTPURL(options.Canary.HealthURL); err != nil {
+			return configError("invalid --canary-health-url: %v", err)
+		}
+	}
+	return nil
+}
+
+// runOnce processes every rule a single time and writes the results.
+func runOnce(ctx context.Context, opts *globalOptions, options RunOptions) error {
+	var rules []Rule
+	var client kubernetes.Interface
+	var clusters []clusterRun
+	var err error
+	// One ID for the run across every cluster.
+	options.RunID = newRunID()
+	if opts.multiCluster() {
+		clusters, err = opts.clusterRuns(options)
+	} else if rules, err = opts.rules(); err == nil {
+		client, err = opts.clients(&options)
+	}
+	if err != nil {
+		return err
+	}
+	for _, cluster := range clusters {
+		if err := opts.checkPermissions(ctx, cluster.Client, cluster.Rules, cluster.Options, false); err != nil {
+			return configError("cluster %s: %v", cluster.Name, err)
+		}
+	}
+	if clusters == nil {
+		if err := opts.checkPermissions(ctx, client, rules, options, false); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop, release := interruptible(ctx)
+	defer release()
+	options.Stop = stop
+	options.Confirmer.stopOn(stop)
+	ctx, cancel := opts.runContext(ctx)
+	defer cancel()
+	var results []Result
+	var failed bool
+	var report RunReport
+	var clusterReports []RunReport
+	if clusters != nil {
+		started := time.Now()
+		clusterReports = runClusters(ctx, clusters)
+		report = mergeReports(options, started, clusterReports)
+		results, failed = report.Results, report.ListFailed
+	} else {
+		runner := NewRunner(client, options)
+		results, failed = runner.Run(ctx, rules)
+		report = runner.Report()
+	}
+
+	if err := WriteResults(os.Stdout, opts.output, results); err != nil {
+		errorf("Error writing results: %v\n", err)
+		failed = true
+	}
+	if clusterReports == nil {
+		logSummary(report)
+	} else {
+		for _, clusterReport := range clusterReports {
+			logSummary(clusterReport)
+		}
+		withFields("clusters", len(clusterReports), "matched", len(results)).
+			infof("Total across %s: %s\n", plural(len(clusterReports), "cluster"), report.Summary)
+	}
+	if opts.pushgatewayURL != "" {
+		if err := pushMetrics(opts.pushgatewayURL, opts.pushgatewayJob, report, !runFailed(results, failed)); err != nil {
+			warnf("%v\n", err)
+		}
+	}
+	if stopped(stop) {
+		interrupted := 0
+		for _, result := range results {
+			if result.Reason == ReasonInterrupted {
+				interrupted++
+			}
+		}
+		warnf("Interrupted: %s; %d not started\n", Summarize(results), interrupted)
+		return &ExitError{Code: ExitInterrupted}
+	}
+	if code := ExitCode(results, failed); code != ExitOK {
+		return &ExitError{Code: code}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	restarterv1 "github.com/testpractive123/assessment-devops.git/api/grpc/v1"
+)
+
+func newServeCommand(opts *globalOptions) *cobra.Command {
+	var listen, grpcListen, tokenFile string
+	var wait bool
+	var waitTimeout time.Duration
+	var strategy, pdbCheck string
+	var dryRun bool
+	var metrics metricsOptions
+	var health healthOptions
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API that runs restarts on demand",
+		Long: `serve exposes the restart engine over HTTP so other automation can trigger
+restarts without shelling out:
+
+  POST /restart     restart the workloads behind the pods selected by the body,
+                    a rule in the rules file format (JSON or YAML) that may also
+                    set "dryRun": true
+  GET  /candidates  report what a restart would do; the query takes namespace
+                    and match (both repeatable), namespaceSelector, podSelector,
+                    fieldSelector, onlyUnhealthy and olderThan
+
+With --grpc-listen it also serves the restarter.v1.Restarter gRPC service
+(api/grpc/v1/restarter.proto), whose Restart call streams the progress and
+rollout status of every workload as it happens.
+
+Requests must set podSelector or match. The pod selection flags and --config
+do not apply; the other global flags do.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := opts.runOptions()
+			options.DryRun = dryRun
+			options.Wait, options.WaitTimeout = wait, waitTimeout
+			options.Strategy, options.PDBCheck = strategy, pdbCheck
+			if err := validateRestartOptions(options); err != nil {
+				return err
+			}
+			token, err := readToken(tokenFile)
+			if err != nil {
+				return err
+			}
+			if listen == "" && grpcListen == "" {
+				return configError("one of --listen or --grpc-listen is required")
+			}
+			return runServe(cmd.Context(), opts, options, listen, grpcListen, token, &metrics, &health)
+		},
+	}
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "address to serve the HTTP API on (empty disables it)")
+	cmd.Flags().StringVar(&grpcListen, "grpc-listen", "", "address to serve the gRPC API on (empty disables it)")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "file holding a bearer token every request and call must present")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for each rollout to finish and report its status")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "how long --wait waits for a single rollout, and --strategy=evict for each pod")
+	cmd.Flags().StringVar(&strategy, "strategy", StrategyRollout, "how to restart workloads: rollout (bump the pod template), evict (evict pods one at a time, honoring PodDisruptionBudgets), delete (delete all pods at once) or scale-cycle (scale to zero and back)")
+	_ = cmd.RegisterFlagCompletionFunc("strategy", cobra.FixedCompletions(strategyNames(), cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&pdbCheck, "pdb-check", PDBCheckSkip, "what to do when a rollout restart would violate a PodDisruptionBudget: skip, warn or off")
+	_ = cmd.RegisterFlagCompletionFunc("pdb-check", cobra.FixedCompletions([]string{PDBCheckSkip, PDBCheckWarn, PDBCheckOff}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "answer every request as a dry run")
+	metrics.addFlags(cmd.Flags())
+	health.addFlags(cmd.Flags())
+	return cmd
+}
+
+func runServe(ctx context.Context, opts *globalOptions, options RunOptions, listen, grpcListen, token string, metrics *metricsOptions, health *healthOptions) error {
+	client, err := opts.runClient(&options)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := metrics.start(ctx); err != nil {
+		return err
+	}
+	if err := health.start(ctx, client); err != nil {
+		return err
+	}
+	// Either server failing stops the other.
+	ctx, cancelServers := context.WithCancel(ctx)
+	defer cancelServers()
+
+	// Like the Alertmanager receiver, requests run on a context that survives
+	// the signal so restarts in progress can finish during shutdown.
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if token == "" {
+		warnf("Serving the API without authentication; set --token-file unless the network is trusted\n")
+	}
+
+	var servers []func() error
+	if listen != "" {
+		server := &APIServer{
+			Client:      client,
+			Options:     options,
+			RunContext:  opts.runContext,
+			BaseContext: base,
+			Output:      os.Stdout,
+			Format:      opts.output,
+		}
+		servers = append(servers, func() error {
+			return serveHTTP(ctx, listen, requireToken(token, server.Handler()), nil)
+		})
+	}
+	if grpcListen != "" {
+		server := &GRPCServer{
+			Client:      client,
+			Options:     options,
+			RunContext:  opts.runContext,
+			BaseContext: base,
+			Output:      os.Stdout,
+			Format:      opts.output,
+		}
+		servers = append(servers, func() error {
+			return serveGRPC(ctx, grpcListen, server, token)
+		})
+	}
+
+	errs := make(chan error, len(servers))
+	for _, serve := range servers {
+		serve := serve
+		go func() {
+			err := serve()
+			cancelServers()
+			errs <- err
+		}()
+	}
+	var firstErr error
+	for range servers {
+		if err := <-errs; firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// serveGRPC serves the gRPC API on listen until ctx is cancelled, then lets
+// calls in progress finish for up to shutdownTimeout.
+func serveGRPC(ctx context.Context, listen string, service restarterv1.RestarterServer, token string) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return configError("invalid --grpc-listen: %v", err)
+	}
+	server := grpc.NewServer(grpcTokenInterceptors(token)...)
+	restarterv1.RegisterRestarterServer(server, service)
+	infof("Serving gRPC on %s\n", listener.Addr())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		warnf("gRPC calls still in progress after %s are abandoned\n", shutdownTimeout)
+		server.Stop()
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newUndoCommand(opts *globalOptions) *cobra.Command {
+	var toRevision int64
+	var dryRun, assumeYes, force bool
+	cmd := &cobra.Command{
+		Use:   "undo KIND/NAME",
+		Short: "Roll a workload back to its previous revision, like kubectl rollout undo",
+		Long: `undo rolls a Deployment, StatefulSet or DaemonSet back to the revision
+before its current one, reverting the last restart, or to --to-revision. KIND
+is deployment, statefulset or daemonset, or their kubectl short names; the
+namespace is --namespace or the context's namespace.
+
+Without --to-revision, undo only reverts a restart: it refuses when the
+current revision changed more than the restartedAt annotation, i.e. when
+someone deployed a new version since, unless --force is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kind, name, err := ParseWorkloadRef(args[0])
+			if err != nil {
+				return configError("invalid workload: %v", err)
+			}
+			if toRevision < 0 {
+				return configError("invalid --to-revision: must not be negative")
+			}
+			namespace, err := undoNamespace(opts)
+			if err != nil {
+				return err
+			}
+			kubeConfig, err := opts.restConfig()
+			if err != nil {
+				return err
+			}
+			client, err := opts.newClientset(kubeConfig)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			workload, err := GetWorkload(ctx, kind, namespace, name, client)
+			if err != nil {
+				return err
+			}
+			revisions, err := WorkloadRevisions(ctx, workload, client)
+			if err != nil {
+				return err
+			}
+			revision, err := UndoTarget(revisions, toRevision, force)
+			if err != nil {
+				return fmt.Errorf("cannot roll back %s: %v", workload, err)
+			}
+			if dryRun {
+				infof("[dry-run] Would roll back %s to revision %d\n", workload, revision.Number)
+				return nil
+			}
+			if !NewConfirmer(assumeYes).Confirm(fmt.Sprintf("Roll back %s to revision %d?", workload, revision.Number)) {
+				infof("Skipping %s\n", workload)
+				return nil
+			}
+			result := Result{Namespace: namespace, Kind: kind, Workload: name, Status: StatusRestarted, Rollout: RolloutRolledBack}
+			err = UndoWorkload(ctx, workload, revision, client)
+			if err != nil {
+				result.Rollout = ""
+				result.fail(err)
+			}
+			opts.audit.Record("undo", configIdentity(kubeConfig), result, fmt.Sprintf("revision %d", revision.Number))
+			if err != nil {
+				return err
+			}
+			infof("Rolled back %s to revision %d\n", workload, revision.Number)
+			return nil
+		},
+	}
+	cmd.Flags().Int64Var(&toRevision, "to-revision", 0, "revision to roll back to (defaults to the previous one)")
+	cmd.Flags().BoolVar(&force, "force", false, "roll back to the previous revision even when the current one is not just a restart")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the revision that would be rolled back to without changing anything")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "do not ask for confirmation")
+	return cmd
+}
+
+// undoNamespace returns the single namespace undo acts in: --namespace or the
+// context's namespace.
+func undoNamespace(opts *globalOptions) (string, error) {
+	switch len(opts.namespaces) {
+	case 0:
+		namespace, err := DefaultNamespace(opts.kubeconfig, opts.context)
+		if err != nil {
+			return "", configError("error getting the context's namespace: %v", err)
+		}
+		return namespace, nil
+	case 1:
+		return opts.namespaces[0], nil
+	}
+	return "", configError("undo takes a single --namespace")
+}
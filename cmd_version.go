@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, injected at build time with
+//
+//	-ldflags "-X main.version=... -X main.gitCommit=... -X main.buildDate=..."
+//
+// See the Makefile.
+var (
+	version   = "dev"
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+// VersionInfo describes this build.
+type VersionInfo struct {
+	Version         string `json:"version"`
+	GitCommit       string `json:"gitCommit"`
+	BuildDate       string `json:"buildDate"`
+	GoVersion       string `json:"goVersion"`
+	ClientGoVersion string `json:"clientGoVersion"`
+	Platform        string `json:"platform"`
+}
+
+// GetVersionInfo returns the build metadata. The client-go version is read
+// from the module information embedded by the Go toolchain.
+func GetVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:         version,
+		GitCommit:       gitCommit,
+		BuildDate:       buildDate,
+		GoVersion:       runtime.Version(),
+		ClientGoVersion: "unknown",
+		Platform:        runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range buildInfo.Deps {
+			if dep.Path == "k8s.io/client-go" {
+				info.ClientGoVersion = dep.Version
+			}
+		}
+		if info.GitCommit == "unknown" {
+			for _, setting := range buildInfo.Settings {
+				if setting.Key == "vcs.revision" {
+					info.GitCommit = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+func newVersionCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version and build metadata",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := GetVersionInfo()
+			if IsMachineReadable(opts.output) {
+				// JSON is valid YAML, so one encoding serves both formats.
+				data, err := json.MarshalIndent(info, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(data))
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Version:    %s\n", info.Version)
+			fmt.Fprintf(cmd.OutOrStdout(), "Git commit: %s\n", info.GitCommit)
+			fmt.Fprintf(cmd.OutOrStdout(), "Build date: %s\n", info.BuildDate)
+			fmt.Fprintf(cmd.OutOrStdout(), "Go:         %s\n", info.GoVersion)
+			fmt.Fprintf(cmd.OutOrStdout(), "client-go:  %s\n", info.ClientGoVersion)
+			fmt.Fprintf(cmd.OutOrStdout(), "Platform:   %s\n", info.Platform)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newWatchCommand(opts *globalOptions) *cobra.Command {
+	var deleteOrphans, evictOrphans bool
+	var wait, rollbackOnFailure, requireApproval bool
+	var waitTimeout time.Duration
+	var strategy, pdbCheck string
+	var dryRun bool
+	var interval, resync time.Duration
+	var useInformers, useCache bool
+	var schedules []string
+	var timezone string
+	var leader leaderElectionOptions
+	var metrics metricsOptions
+	var health healthOptions
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Run restarts repeatedly as a long-lived daemon",
+		Long: `watch rescans the cluster every --interval and restarts the workloads that
+own matching pods, until it receives SIGINT or SIGTERM. It never prompts.
+
+With --schedule, or rules that set schedules, it runs each rule at the times
+given by its cron expressions instead.
+
+With --informers it instead follows pod changes through shared informers and
+reacts to matching pods as soon as a trigger fires, re-evaluating every pod
+each --resync.
+
+With --cache, pods, namespaces and the workloads that own them are read
+from shared informer caches instead of the API server on every scan.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := opts.runOptions()
+			options.DryRun = dryRun
+			options.Wait, options.WaitTimeout = wait || rollbackOnFailure, waitTimeout
+			options.RollbackOnFailure = rollbackOnFailure
+			options.DeleteOrphans = deleteOrphans || evictOrphans
+			options.EvictOrphans = evictOrphans
+			options.Strategy, options.PDBCheck = strategy, pdbCheck
+			options.RequireApproval = requireApproval
+			if err := validateRestartOptions(options); err != nil {
+				return err
+			}
+			if useInformers {
+				if len(schedules) > 0 {
+					return configError("--schedule cannot be used with --informers")
+				}
+				return runInformers(cmd.Context(), opts, options, resync, useCache, &leader, &metrics, &health)
+			}
+			return runWatch(cmd.Context(), opts, options, interval, useCache, schedules, timezone, &leader, &metrics, &health)
+		},
+	}
+	cmd.Flags().BoolVar(&deleteOrphans, "delete-orphans", false, "delete matched pods that have no controlling workload instead of failing")
+	cmd.Flags().BoolVar(&evictOrphans, "evict-orphans", false, "like --delete-orphans, but use the Eviction API so PodDisruptionBudgets are honored")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for each rollout to finish and report its status")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute, "how long --wait waits for a single rollout, and --strategy=evict for each pod")
+	cmd.Flags().BoolVar(&rollbackOnFailure, "rollback-on-failure", false, "roll back to the previous revision when a rollout does not become healthy within --wait-timeout (implies --wait)")
+	cmd.Flags().BoolVar(&requireApproval, "require-approval", false, "annotate workloads that would be restarted "+AnnotationPendingApproval+" instead, and only restart those annotated "+AnnotationApproved+"=true")
+	cmd.Flags().StringVar(&strategy, "strategy", StrategyRollout, "how to restart workloads: rollout (bump the pod template), evict (evict pods one at a time, honoring PodDisruptionBudgets), delete (delete all pods at once) or scale-cycle (scale to zero and back)")
+	_ = cmd.RegisterFlagCompletionFunc("strategy", cobra.FixedCompletions(strategyNames(), cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&pdbCheck, "pdb-check", PDBCheckSkip, "what to do when a rollout restart would violate a PodDisruptionBudget: skip, warn or off")
+	_ = cmd.RegisterFlagCompletionFunc("pdb-check", cobra.FixedCompletions([]string{PDBCheckSkip, PDBCheckWarn, PDBCheckOff}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the workloads that would be restarted without changing anything")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Minute, "time between scans")
+	cmd.Flags().StringArrayVar(&schedules, "schedule", nil, "cron expression at which to run the rules, e.g. \"0 3 * * 6\"; repeatable")
+	cmd.Flags().StringVar(&timezone, "timezone", "", "IANA timezone of --schedule and of rule schedules without their own (defaults to the local timezone)")
+	cmd.Flags().BoolVar(&useInformers, "informers", false, "react to pod changes through shared informers instead of rescanning every --interval")
+	cmd.Flags().DurationVar(&resync, "resync", 10*time.Minute, "with --informers, how often every cached pod is re-evaluated")
+	cmd.Flags().BoolVar(&useCache, "cache", false, "read pods, namespaces and the workloads owning them from shared informer caches instead of the API server on every scan")
+	leader.addFlags(cmd.Flags())
+	metrics.addFlags(cmd.Flags())
+	health.addFlags(cmd.Flags())
+	return cmd
+}
+
+func runWatch(ctx context.Context, opts *globalOptions, options RunOptions, interval time.Duration, useCache bool, schedules []string, timezone string, leader *leaderElectionOptions, metrics *metricsOptions, health *healthOptions) error {
+	if interval <= 0 {
+		return configError("invalid --interval: must be positive")
+	}
+	rules, err := opts.rules()
+	if err != nil {
+		return err
+	}
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return configError("invalid --timezone: %v", err)
+		}
+	}
+	for _, spec := range schedules {
+		if _, err := ParseSchedule(withTimezone(spec, timezone)); err != nil {
+			return configError("invalid --schedule: %v", err)
+		}
+	}
+	scheduled := len(schedules) > 0
+	// Rules with their own interval or a backoff run on their own, like
+	// scheduled ones.
+	for i := range rules {
+		scheduled = scheduled || len(rules[i].Schedules) > 0 || rules[i].Interval != nil || rules[i].Backoff != nil
+	}
+	if useCache && options.Scope == ScopeNamespace {
+		if _, err := singleNamespace(rules); err != nil {
+			return configError("--cache: %v", err)
+		}
+	}
+	client, err := opts.clients(&options)
+	if err != nil {
+		return err
+	}
+	if err := opts.checkPermissions(ctx, client, rules, options, useCache); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := metrics.start(ctx); err != nil {
+		return err
+	}
+	if err := health.start(ctx, client); err != nil {
+		return err
+	}
+	if useCache {
+		if options.Cache, err = startWorkloadCache(ctx, client, rules, options.Scope); err != nil {
+			return err
+		}
+	}
+
+	if scheduled {
+		scheduler := &Scheduler{
+			Client:     client,
+			Rules:      rules,
+			Options:    options,
+			Schedules:  schedules,
+			Interval:   interval,
+			Timezone:   timezone,
+			RunContext: opts.runContext,
+			Output:     os.Stdout,
+			Format:     opts.output,
+		}
+		return leader.run(ctx, client, scheduler.Run)
+	}
+	return leader.run(ctx, client, func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			scanCtx, cancel := opts.runContext(ctx)
+			runner := NewRunner(client, options)
+			results, _ := runner.Run(scanCtx, rules)
+			cancel()
+			if err := WriteResults(os.Stdout, opts.output, results); err != nil {
+				errorf("Error writing results: %v\n", err)
+			}
+			logSummary(runner.Report())
+
+			select {
+			case <-ctx.Done():
+				infof("Shutting down\n")
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func runInformers(ctx context.Context, opts *globalOptions, options RunOptions, resync time.Duration, cacheWorkloads bool, leader *leaderElectionOptions, metrics *metricsOptions, health *healthOptions) error {
+	if resync <= 0 {
+		return configError("invalid --resync: must be positive")
+	}
+	rules, err := opts.rules()
+	if err != nil {
+		return err
+	}
+	if err := ValidateWatchRules(rules); err != nil {
+		return configError("--informers: %v", err)
+	}
+	if options.Scope == ScopeNamespace {
+		if _, err := singleNamespace(rules); err != nil {
+			return configError("--informers: %v", err)
+		}
+	}
+	client, err := opts.runClient(&options)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := metrics.start(ctx); err != nil {
+		return err
+	}
+	if err := health.start(ctx, client); err != nil {
+		return err
+	}
+
+	watcher := &PodWatcher{
+		Client:         client,
+		Rules:          rules,
+		Options:        options,
+		Resync:         resync,
+		Output:         os.Stdout,
+		Format:         opts.output,
+		CacheWorkloads: cacheWorkloads,
+	}
+	return leader.run(ctx, client, watcher.Run)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// registerCompletions wires dynamic completion for the global flags. The
+// generated scripts come from cobra's built-in completion command.
+func registerCompletions(cmd *cobra.Command, opts *globalOptions) {
+	namespaces := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeNamespaces(cmd.Context(), opts, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+	_ = cmd.RegisterFlagCompletionFunc("namespace", namespaces)
+	_ = cmd.RegisterFlagCompletionFunc("exclude-namespaces", namespaces)
+	contexts := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		contexts, _, err := ListContexts(opts.kubeconfig)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return filterPrefix(contexts, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+	_ = cmd.RegisterFlagCompletionFunc("context", contexts)
+	_ = cmd.RegisterFlagCompletionFunc("contexts", contexts)
+	_ = cmd.RegisterFlagCompletionFunc("kube-api-content-type", cobra.FixedCompletions([]string{ContentTypeProtobuf, ContentTypeJSON}, cobra.ShellCompDirectiveNoFileComp))
+	_ = cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{OutputText, OutputTable, OutputSummary, OutputReleases, OutputJSON, OutputYAML}, cobra.ShellCompDirectiveNoFileComp))
+	_ = cmd.RegisterFlagCompletionFunc("log-level", cobra.FixedCompletions([]string{"debug", "info", "warn", "error"}, cobra.ShellCompDirectiveNoFileComp))
+	_ = cmd.RegisterFlagCompletionFunc("log-format", cobra.FixedCompletions([]string{LogFormatText, LogFormatJSON}, cobra.ShellCompDirectiveNoFileComp))
+	_ = cmd.RegisterFlagCompletionFunc("pagerduty-severity", cobra.FixedCompletions([]string{PagerDutyCritical, PagerDutyError, PagerDutyWarning, PagerDutyInfo}, cobra.ShellCompDirectiveNoFileComp))
+	_ = cmd.RegisterFlagCompletionFunc("opsgenie-priority", cobra.FixedCompletions([]string{"P1", "P2", "P3", "P4", "P5"}, cobra.ShellCompDirectiveNoFileComp))
+	_ = cmd.MarkPersistentFlagFilename("kubeconfig")
+	_ = cmd.MarkPersistentFlagFilename("config", "yaml", "yml")
+}
+
+// completeNamespaces queries the cluster for namespace names. Progress
+// messages are discarded so they cannot corrupt the completion output.
+func completeNamespaces(ctx context.Context, opts *globalOptions, toComplete string) []string {
+	logOutput = io.Discard
+	client, err := opts.clientset()
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil
+	}
+
+	// Complete the last element of a comma-separated list.
+	prefix := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		prefix, toComplete = toComplete[:i+1], toComplete[i+1:]
+	}
+	var names []string
+	for _, namespace := range namespaces.Items {
+		if strings.HasPrefix(namespace.Name, toComplete) {
+			names = append(names, prefix+namespace.Name)
+		}
+	}
+	return names
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	var matches []string
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			matches = append(matches, v)
+		}
+	}
+	return matches
+}
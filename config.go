@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/testpractive123/assessment-devops.git/pkg/restarter"
+)
+
+// Rule actions.
+const (
+	ActionRestart = "restart"
+	ActionReport  = "report"
+)
+
+// Config is the on-disk configuration passed with --config.
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+	// Deny lists namespaces and workloads never acted on, in addition to
+	// --deny-namespaces and --deny-workloads.
+	Deny *DenyList `yaml:"deny"`
+}
+
+// Rule describes one set of pods to act on.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Namespaces limits the rule to the listed namespaces.
+	Namespaces []string `yaml:"namespaces"`
+	// NamespaceSelector is a label selector evaluated against Namespaces.
+	NamespaceSelector string `yaml:"namespaceSelector"`
+	// ExcludeNamespaces are skipped in addition to the global exclusions.
+	ExcludeNamespaces []string `yaml:"excludeNamespaces"`
+	PodSelector       string   `yaml:"podSelector"`
+	// FieldSelector is passed to the Pods().List call, e.g. status.phase=Running.
+	FieldSelector string `yaml:"fieldSelector"`
+	// Match holds pod name regular expressions combined with OR semantics.
+	Match []string `yaml:"match"`
+	// Expression is a CEL expression over the pod that must hold, e.g.
+	// pod.status.containerStatuses.exists(c, c.restartCount > 5).
+	Expression string `yaml:"expression"`
+	// Matchers must all match as well. Besides the built-in types, programs
+	// embedding restarter can register their own with
+	// restarter.RegisterMatcher.
+	Matchers []MatcherRule `yaml:"matchers"`
+	// Release limits the rule to the workloads of this Helm release, whose
+	// pods carry the app.kubernetes.io/instance label.
+	Release string `yaml:"release"`
+	// WorkloadExpression is a CEL expression over the resolved workload that
+	// must hold for it to be acted on, e.g. workload.spec.replicas > 1.
+	WorkloadExpression string `yaml:"workloadExpression"`
+	// OnlyUnhealthy limits the rule to pods in CrashLoopBackOff,
+	// ImagePullBackOff or not Ready.
+	OnlyUnhealthy bool `yaml:"onlyUnhealthy"`
+	// OOMKills fires for containers OOM-killed within OOMWindow that have
+	// restarted at least this many times.
+	OOMKills  int32          `yaml:"oomKills"`
+	OOMWindow *time.Duration `yaml:"oomWindow"`
+	// RestartCount fires for containers that have restarted at least this
+	// many times, the last time within RestartWindow.
+	RestartCount  int32          `yaml:"restartCount"`
+	RestartWindow *time.Duration `yaml:"restartWindow"`
+	// Events fire for pods with repeated Kubernetes Events, e.g. FailedMount.
+	Events []EventRule `yaml:"events"`
+	// ImageDrift fires for pods whose image tag, e.g. :latest, now points to
+	// another digest in the registry.
+	ImageDrift bool `yaml:"imageDrift"`
+	// OlderThan only lets through pods running at least this long, e.g. 30d.
+	OlderThan string `yaml:"olderThan"`
+	// Action is either "restart" (default) or "report".
+	Action string `yaml:"action"`
+	// Strategy overrides --strategy for this rule, e.g. scale-cycle for a
+	// workload that must stop completely before starting again.
+	Strategy string `yaml:"strategy"`
+	// PreRestart hooks run before each restart of the rule's workloads,
+	// PostRestart hooks after each successful one.
+	PreRestart  []Hook `yaml:"preRestart"`
+	PostRestart []Hook `yaml:"postRestart"`
+	// Cooldown overrides --cooldown for this rule, e.g. 30m.
+	Cooldown *time.Duration `yaml:"cooldown"`
+	// Schedules are cron expressions at which watch runs the rule; they
+	// override --schedule.
+	Schedules []string `yaml:"schedules"`
+	// Timezone is the IANA timezone of the schedules, e.g. Europe/Berlin.
+	Timezone string `yaml:"timezone"`
+	// Interval overrides --interval for this rule in watch, e.g. 1m for a
+	// rule that must react quickly or 1h for an expensive one.
+	Interval *time.Duration `yaml:"interval"`
+	// Backoff retries failed runs of the rule in watch before its next
+	// interval or schedule.
+	Backoff *Backoff `yaml:"backoff"`
+	// MaintenanceWindows override --maintenance-window for this rule, e.g.
+	// "Sat 02:00-04:00 UTC".
+	MaintenanceWindows []string `yaml:"maintenanceWindows"`
+
+	matcher  *PodMatcher
+	triggers *Triggers
+	windows  []MaintenanceWindow
+	gate     *restarter.Expression
+}
+
+// EventRule is an event trigger in the rules file.
+type EventRule struct {
+	// Reason is the Event reason, e.g. Unhealthy or FailedMount.
+	Reason string `yaml:"reason"`
+	// Message, when set, must be contained in the Event message, e.g.
+	// "Liveness probe failed".
+	Message string `yaml:"message"`
+	// Count is how many occurrences fire the trigger (default 1).
+	Count int32 `yaml:"count"`
+	// Window is how far back occurrences count (default 1h).
+	Window *time.Duration `yaml:"window"`
+}
+
+// MatcherRule is a pod matcher in the rules file.
+type MatcherRule struct {
+	// Type is a matcher type: substring, regex, label, cel or a registered
+	// custom type.
+	Type string `yaml:"type"`
+	// Value configures the matcher, e.g. the regular expression of a regex
+	// matcher.
+	Value string `yaml:"value"`
+}
+
+// ParseEventRule parses an event trigger written as REASON[:COUNT[:WINDOW]],
+// e.g. "Unhealthy:5:10m".
+func ParseEventRule(spec string) (EventRule, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	event := EventRule{Reason: parts[0]}
+	if len(parts) > 1 {
+		count, err := strconv.ParseInt(parts[1], 10, 32)
+		if err != nil {
+			return event, fmt.Errorf("invalid count in %q", spec)
+		}
+		event.Count = int32(count)
+	}
+	if len(parts) > 2 {
+		window, err := ParseDuration(parts[2])
+		if err != nil {
+			return event, fmt.Errorf("invalid window in %q: %v", spec, err)
+		}
+		event.Window = &window
+	}
+	return event, nil
+}
+
+// ConfigError is a validation error tied to a position in the config file.
+type ConfigError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// LoadConfig reads and validates a configuration file. Unknown fields and
+// invalid values are reported with the line they appear on.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, &ConfigError{Path: path, Msg: err.Error()}
+	}
+
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, &ConfigError{Path: path, Msg: err.Error()}
+	}
+	if len(config.Rules) == 0 {
+		return nil, &ConfigError{Path: path, Msg: "no rules defined"}
+	}
+
+	if config.Deny != nil {
+		if err := config.Deny.Validate(); err != nil {
+			line := 0
+			if node := mappingValue(documentNode(&root), "deny"); node != nil {
+				line = node.Line
+			}
+			return nil, &ConfigError{Path: path, Line: line, Msg: fmt.Sprintf("deny: %v", err)}
+		}
+	}
+
+	ruleNodes := sequenceItems(mappingValue(documentNode(&root), "rules"))
+	for i := range config.Rules {
+		var ruleNode *yaml.Node
+		if i < len(ruleNodes) {
+			ruleNode = ruleNodes[i]
+		}
+		if field, err := config.Rules[i].Validate(); err != nil {
+			line := 0
+			if ruleNode != nil {
+				line = ruleNode.Line
+				if valueNode := mappingValue(ruleNode, field); valueNode != nil {
+					line = valueNode.Line
+				}
+			}
+			return nil, &ConfigError{Path: path, Line: line, Msg: fmt.Sprintf("rule %d: %v", i+1, err)}
+		}
+	}
+	return &config, nil
+}
+
+// Validate checks the rule, fills in defaults and prepares its matcher. On
+// failure it also returns the YAML field that caused the error.
+func (r *Rule) Validate() (string, error) {
+	if r.Action == "" {
+		r.Action = ActionRestart
+	}
+	if r.Action != ActionRestart && r.Action != ActionReport {
+		return "action", fmt.Errorf("unknown action %q (want %q or %q)", r.Action, ActionRestart, ActionReport)
+	}
+	if r.Strategy != "" {
+		if err := ValidateStrategy(r.Strategy); err != nil {
+			return "strategy", err
+		}
+	}
+	for i := range r.PreRestart {
+		if err := r.PreRestart[i].validate(HookPreRestart); err != nil {
+			return HookPreRestart, err
+		}
+	}
+	for i := range r.PostRestart {
+		if err := r.PostRestart[i].validate(HookPostRestart); err != nil {
+			return HookPostRestart, err
+		}
+	}
+	if len(r.Namespaces) > 0 && r.NamespaceSelector != "" {
+		return "namespaceSelector", fmt.Errorf("namespaces and namespaceSelector are mutually exclusive")
+	}
+	if _, err := labels.Parse(r.NamespaceSelector); err != nil {
+		return "namespaceSelector", fmt.Errorf("invalid namespaceSelector: %v", err)
+	}
+	if _, err := labels.Parse(r.PodSelector); err != nil {
+		return "podSelector", fmt.Errorf("invalid podSelector: %v", err)
+	}
+	if errs := validation.IsValidLabelValue(r.Release); len(errs) > 0 {
+		return "release", fmt.Errorf("invalid release %q: %s", r.Release, strings.Join(errs, "; "))
+	}
+	if _, err := fields.ParseSelector(r.FieldSelector); err != nil {
+		return "fieldSelector", fmt.Errorf("invalid fieldSelector: %v", err)
+	}
+	if r.Cooldown != nil && *r.Cooldown < 0 {
+		return "cooldown", fmt.Errorf("cooldown must not be negative")
+	}
+	if r.Timezone != "" {
+		if _, err := time.LoadLocation(r.Timezone); err != nil {
+			return "timezone", fmt.Errorf("invalid timezone: %v", err)
+		}
+	}
+	for _, spec := range r.Schedules {
+		if _, err := ParseSchedule(withTimezone(spec, r.Timezone)); err != nil {
+			return "schedules", err
+		}
+	}
+	if r.Interval != nil && *r.Interval <= 0 {
+		return "interval", fmt.Errorf("interval must be positive")
+	}
+	if r.Backoff != nil {
+		if err := r.Backoff.validate(); err != nil {
+			return "backoff", err
+		}
+	}
+	r.windows = nil
+	for _, spec := range r.MaintenanceWindows {
+		window, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			return "maintenanceWindows", err
+		}
+		r.windows = append(r.windows, window)
+	}
+	patterns, err := CompilePatterns(r.Match)
+	if err != nil {
+		return "match", err
+	}
+	r.matcher = &PodMatcher{LabelSelector: r.podSelector(), Patterns: patterns}
+	if r.Expression != "" {
+		if r.matcher.Expression, err = restarter.CompileExpression(r.Expression, restarter.ExpressionPod); err != nil {
+			return "expression", err
+		}
+	}
+	for _, spec := range r.Matchers {
+		matcher, err := restarter.NewMatcher(spec.Type, spec.Value)
+		if err != nil {
+			return "matchers", err
+		}
+		r.matcher.Matchers = append(r.matcher.Matchers, matcher)
+	}
+	r.gate = nil
+	if r.WorkloadExpression != "" {
+		if r.gate, err = restarter.CompileExpression(r.WorkloadExpression, restarter.ExpressionWorkload); err != nil {
+			return "workloadExpression", err
+		}
+	}
+	if r.OOMKills < 0 {
+		return "oomKills", fmt.Errorf("oomKills must not be negative")
+	}
+	if r.RestartCount < 0 {
+		return "restartCount", fmt.Errorf("restartCount must not be negative")
+	}
+	r.triggers = &Triggers{
+		OnlyUnhealthy: r.OnlyUnhealthy,
+		OOMKills:      r.OOMKills,
+		OOMWindow:     DefaultOOMWindow,
+		RestartCount:  r.RestartCount,
+		RestartWindow: DefaultRestartWindow,
+		ImageDrift:    r.ImageDrift,
+	}
+	if r.OOMWindow != nil {
+		r.triggers.OOMWindow = *r.OOMWindow
+	}
+	for _, event := range r.Events {
+		if event.Reason == "" {
+			return "events", fmt.Errorf("events need a reason")
+		}
+		if event.Count < 0 {
+			return "events", fmt.Errorf("event count must not be negative")
+		}
+		trigger := EventTrigger{Reason: event.Reason, Message: event.Message, Count: event.Count, Window: DefaultEventWindow}
+		if trigger.Count == 0 {
+			trigger.Count = 1
+		}
+		if event.Window != nil {
+			trigger.Window = *event.Window
+		}
+		r.triggers.Events = append(r.triggers.Events, trigger)
+	}
+	if r.RestartWindow != nil {
+		r.triggers.RestartWindow = *r.RestartWindow
+	}
+	if r.OlderThan != "" {
+		olderThan, err := ParseDuration(r.OlderThan)
+		if err != nil {
+			return "olderThan", err
+		}
+		r.triggers.OlderThan = olderThan
+	}
+	return "", nil
+}
+
+// podSelector returns the label selector the rule's pods are listed with:
+// PodSelector, narrowed to the pods of Release.
+func (r *Rule) podSelector() string {
+	if r.Release == "" {
+		return r.PodSelector
+	}
+	selector := LabelInstance + "=" + r.Release
+	if r.PodSelector != "" {
+		selector = r.PodSelector + "," + selector
+	}
+	return selector
+}
+
+// needsPodStatus reports whether matching pods looks at more than their
+// metadata. Expressions and matchers may look at anything.
+func (r *Rule) needsPodStatus() bool {
+	return r.triggers.NeedsPodStatus() || r.matcher.Expression != nil || len(r.matcher.Matchers) > 0
+}
+
+func documentNode(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+	return node
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func sequenceItems(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
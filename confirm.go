@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Confirmer asks the operator before each restart. Answering "a" approves the
+// rest of the batch and "q" declines it.
+type Confirmer struct {
+	// mu serializes prompts from concurrent workers.
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+	// lines receives the lines read from in, once reading started.
+	lines chan line
+	// stop, when closed, declines the pending prompt and everything after.
+	stop <-chan struct{}
+	// decided is set once the operator answered for the whole batch.
+	decided bool
+	approve bool
+}
+
+// NewConfirmer returns a Confirmer reading from stdin, or nil when prompting
+// is disabled (--yes) or stdin is not a terminal.
+func NewConfirmer(assumeYes bool) *Confirmer {
+	if assumeYes || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return &Confirmer{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+}
+
+// line is a line read from the terminal.
+type line struct {
+	text string
+	err  error
+}
+
+// stopOn makes a closed stop answer "quit" to the pending and later prompts,
+// so an interrupt does not leave the run waiting for input.
+func (c *Confirmer) stopOn(stop <-chan struct{}) {
+	if c != nil {
+		c.stop = stop
+	}
+}
+
+// readLine returns the next line the operator typed, or an error once stop
+// is closed. The terminal is read on its own goroutine so a prompt can be
+// abandoned.
+func (c *Confirmer) readLine() (string, error) {
+	if c.lines == nil {
+		c.lines = make(chan line)
+		go func() {
+			for {
+				text, err := c.in.ReadString('\n')
+				c.lines <- line{text: text, err: err}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	select {
+	case l := <-c.lines:
+		return l.text, l.err
+	case <-c.stop:
+		fmt.Fprintln(c.out)
+		return "", errInterrupted
+	}
+}
+
+// Confirm reports whether the action described by prompt may proceed. A nil
+// Confirmer approves everything.
+func (c *Confirmer) Confirm(prompt string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.decided {
+		return c.approve
+	}
+	for {
+		fmt.Fprintf(c.out, "%s [y/N/a(ll)/q(uit)] ", prompt)
+		answer, err := c.readLine()
+		if err != nil && answer == "" {
+			// EOF: treat as "no" for everything that is left.
+			c.decided, c.approve = true, false
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "", "n", "no":
+			return false
+		case "a", "all":
+			c.decided, c.approve = true, true
+			return true
+		case "q", "quit":
+			c.decided, c.approve = true, false
+			return false
+		}
+	}
+}
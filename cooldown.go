@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// LastRestart returns when the workload was last rollout-restarted, read from
+// its pod template's restartedAt annotation.
+func LastRestart(workload *Workload) (time.Time, bool) {
+	template := workload.PodTemplate()
+	if template == nil {
+		return time.Time{}, false
+	}
+	value, ok := template.Annotations[RestartedAtAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	restartedAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return restartedAt, true
+}
+
+// InCooldown reports whether a workload last restarted at restartedAt, zero
+// for never, was restarted less than cooldown ago, and if so returns a reason
+// for skipping it.
+func InCooldown(restartedAt time.Time, cooldown time.Duration, now time.Time) (bool, string) {
+	if cooldown <= 0 || restartedAt.IsZero() {
+		return false, ""
+	}
+	if elapsed := now.Sub(restartedAt); elapsed < cooldown {
+		return true, fmt.Sprintf("restarted %s ago, within the %s cooldown", elapsed.Round(time.Second), cooldown)
+	}
+	return false, ""
+}
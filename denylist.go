@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// AnnotationDeny puts a workload or a whole namespace on the deny-list when
+// "true". Unlike restarter.io/enabled=false on a namespace, it cannot be
+// overridden by the workloads in it.
+const AnnotationDeny = "restarter.io/deny"
+
+// DenyList holds the namespaces and workloads that are never restarted,
+// evicted, paused, resumed or deleted, whatever the rules match. Workloads
+// and namespaces annotated restarter.io/deny=true are denied as well; a nil
+// DenyList denies only those.
+type DenyList struct {
+	// Namespaces are namespace name patterns, e.g. "kube-*".
+	Namespaces []string `yaml:"namespaces"`
+	// Workloads are NAMESPACE/KIND/NAME patterns, e.g. "*/StatefulSet/etcd-*".
+	// Kinds are matched case-insensitively.
+	Workloads []string `yaml:"workloads"`
+}
+
+// Validate checks the patterns.
+func (d *DenyList) Validate() error {
+	for _, pattern := range d.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range d.Workloads {
+		if strings.Count(pattern, "/") != 2 {
+			return fmt.Errorf("invalid workload pattern %q: want NAMESPACE/KIND/NAME", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid workload pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// merge returns the deny-list of both d and other.
+func (d *DenyList) merge(other *DenyList) *DenyList {
+	if d == nil {
+		return other
+	}
+	if other == nil {
+		return d
+	}
+	return &DenyList{
+		Namespaces: append(append([]string{}, d.Namespaces...), other.Namespaces...),
+		Workloads:  append(append([]string{}, d.Workloads...), other.Workloads...),
+	}
+}
+
+// Denies reports whether the workload is denied, and why. A nil workload
+// stands for an orphaned pod of the namespace, which only the namespace
+// denies.
+func (d *DenyList) Denies(namespace string, workload *Workload, nsAnnotations map[string]string) (bool, string) {
+	if workload != nil && deniedAnnotation(workload.Annotations) {
+		return true, fmt.Sprintf("workload annotated %s=true", AnnotationDeny)
+	}
+	if deniedAnnotation(nsAnnotations) {
+		return true, fmt.Sprintf("namespace annotated %s=true", AnnotationDeny)
+	}
+	if d == nil {
+		return false, ""
+	}
+	for _, pattern := range d.Namespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true, fmt.Sprintf("namespace matches deny-list pattern %q", pattern)
+		}
+	}
+	if workload == nil {
+		return false, ""
+	}
+	key := workload.Namespace + "/" + strings.ToLower(workload.Kind) + "/" + workload.Name
+	for _, pattern := range d.Workloads {
+		parts := strings.SplitN(pattern, "/", 3)
+		if matched, _ := path.Match(parts[0]+"/"+strings.ToLower(parts[1])+"/"+parts[2], key); matched {
+			return true, fmt.Sprintf("workload matches deny-list pattern %q", pattern)
+		}
+	}
+	return false, ""
+}
+
+func deniedAnnotation(annotations map[string]string) bool {
+	value, ok := annotations[AnnotationDeny]
+	if !ok {
+		return false
+	}
+	denied, err := strconv.ParseBool(value)
+	if err != nil {
+		warnf("Ignoring invalid %s annotation value %q\n", AnnotationDeny, value)
+		return false
+	}
+	return denied
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DrainWatcher restarts the workloads owning the matched pods of a node as
+// soon as the node is cordoned, so replacements start on other nodes before
+// a drain evicts the old pods. Databases and other workloads with slow
+// failover then hand over in a controlled rollout rather than by eviction.
+type DrainWatcher struct {
+	Client  kubernetes.Interface
+	Rules   []Rule
+	Options RunOptions
+	// NodeSelector is a label selector limiting the watched nodes.
+	NodeSelector string
+	// RunContext derives the context of the restarts for a single node.
+	RunContext func(ctx context.Context) (context.Context, context.CancelFunc)
+	// Output receives the results of each cordoned node.
+	Output io.Writer
+	Format string
+
+	queue   workqueue.RateLimitingInterface
+	writeMu sync.Mutex
+}
+
+// Run watches the nodes and processes cordoned ones with Options.Concurrency
+// workers until ctx is cancelled. Restarts in flight when ctx is cancelled
+// are allowed to finish; the rest of the node's workloads, and restarts held
+// for a maintenance window, are skipped. Nodes that are already cordoned
+// when Run starts are left alone.
+func (d *DrainWatcher) Run(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(d.Client, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = d.NodeSelector
+	}))
+	nodeInformer := factory.Core().V1().Nodes().Informer()
+	d.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	d.Options.Stop = ctx.Done()
+	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, obj interface{}) {
+			old, ok1 := oldObj.(*v1.Node)
+			node, ok2 := obj.(*v1.Node)
+			if ok1 && ok2 && !old.Spec.Unschedulable && node.Spec.Unschedulable {
+				withFields("node", node.Name).infof("Node %s was cordoned\n", node.Name)
+				d.queue.Add(node.Name)
+			}
+		},
+	})
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	infof("Waiting for the node cache to sync\n")
+	markSynced := cacheSync.syncing("nodes")
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			d.queue.ShutDown()
+			return fmt.Errorf("error syncing the %v cache", informerType)
+		}
+	}
+	markSynced()
+	infof("Watching nodes for cordons\n")
+
+	workers := d.Options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for d.processNext() {
+			}
+		}()
+	}
+	<-ctx.Done()
+	infof("Shutting down, waiting for in-flight restarts\n")
+	d.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+// processNext handles one cordoned node and reports whether the queue is
+// still open. Failed restarts are not retried: by then the drain has evicted
+// the pods anyway.
+func (d *DrainWatcher) processNext() bool {
+	item, shutdown := d.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer d.queue.Done(item)
+	d.queue.Forget(item)
+
+	node := item.(string)
+	runCtx, cancel := d.RunContext(context.Background())
+	defer cancel()
+	// A fresh runner per node: a workload with pods on several cordoned
+	// nodes is restarted for each of them, and --max-restarts applies per
+	// node.
+	runner := NewRunner(d.Client, d.Options)
+	for i := range d.Rules {
+		rule := nodeRule(&d.Rules[i], node)
+		results, err := runner.ProcessRule(runCtx, &rule)
+		if err != nil {
+			withFields("rule", rule.Name, "node", node).errorf("Error processing rule %s for node %s: %v\n", rule.Name, node, err)
+		}
+		d.write(results)
+	}
+	return true
+}
+
+// nodeRule narrows a rule to the pods scheduled on the node.
+func nodeRule(rule *Rule, node string) Rule {
+	narrowed := *rule
+	nodeField := "spec.nodeName=" + node
+	if narrowed.FieldSelector == "" {
+		narrowed.FieldSelector = nodeField
+	} else {
+		narrowed.FieldSelector += "," + nodeField
+	}
+	return narrowed
+}
+
+// write emits the results of a rule. Writes are serialized across workers.
+func (d *DrainWatcher) write(results []Result) {
+	if len(results) == 0 {
+		return
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if err := WriteResults(d.Output, d.Format, results); err != nil {
+		errorf("Error writing results: %v\n", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Reasons of the Events recorded on restarted workloads.
+const (
+	EventReasonRestart       = "AutomatedRestart"
+	EventReasonRestartFailed = "AutomatedRestartFailed"
+)
+
+// eventComponent is the source and reporting controller of the Events.
+const eventComponent = "restarter"
+
+// recordRestartEvent attaches an Event to a workload the run restarted, or
+// failed to, saying why, so kubectl describe shows that the restarter did it.
+// The Event's source names the host the restarter runs on, and its time says
+// when. Errors, typically missing RBAC, are logged and otherwise ignored.
+func recordRestartEvent(ctx context.Context, workload *Workload, result Result, source string, client kubernetes.Interface, log logFields) {
+	eventType, reason := v1.EventTypeNormal, EventReasonRestart
+	message := fmt.Sprintf("Restarted by %s for %s", eventComponent, restartCause(result, source))
+	if result.Status == StatusFailed {
+		eventType, reason = v1.EventTypeWarning, EventReasonRestartFailed
+		message = fmt.Sprintf("Restart by %s for %s failed: %s", eventComponent, restartCause(result, source), result.Error)
+	}
+	reference := v1.ObjectReference{APIVersion: "apps/v1", Kind: workload.Kind, Namespace: workload.Namespace, Name: workload.Name}
+	if accessor, err := meta.Accessor(workload.Object); err == nil {
+		reference.UID, reference.ResourceVersion = accessor.GetUID(), accessor.GetResourceVersion()
+	}
+	host, _ := os.Hostname()
+	now := metav1.NewTime(time.Now())
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", workload.Name, now.UnixNano()),
+			Namespace: workload.Namespace,
+		},
+		InvolvedObject:      reference,
+		Reason:              reason,
+		Message:             message,
+		Type:                eventType,
+		Source:              v1.EventSource{Component: eventComponent, Host: host},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: "restarter.io/" + eventComponent,
+		ReportingInstance:   host,
+		Action:              "Restart",
+	}
+
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	if _, err := client.CoreV1().Events(workload.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		log.warnf("Could not record an Event on %s: %v\n", workload, err)
+	}
+}
+
+// restartCause describes what selected a workload, e.g. "pod web-1 (rule
+// nightly, trigger CrashLoopBackOff)".
+func restartCause(result Result, source string) string {
+	var details []string
+	if result.Rule != "" {
+		details = append(details, "rule "+result.Rule)
+	}
+	if result.Trigger != "" {
+		details = append(details, "trigger "+result.Trigger)
+	}
+	if len(details) == 0 {
+		return source
+	}
+	return source + " (" + strings.Join(details, ", ") + ")"
+}
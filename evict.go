@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// evictionRetryInterval is how long to wait before retrying an eviction that
+// a PodDisruptionBudget refused.
+var evictionRetryInterval = 5 * time.Second
+
+// EvictWorkload restarts a workload by evicting its pods one at a time. After
+// each eviction it waits for the workload to become ready again before moving
+// on, and it retries evictions refused by a PodDisruptionBudget until timeout
+// elapses. It returns the number of pods evicted.
+func EvictWorkload(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface) (int, error) {
+	pods, err := WorkloadPods(ctx, workload, client)
+	if err != nil {
+		return 0, err
+	}
+
+	evicted := 0
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if err := evictWithRetry(ctx, pod, timeout, client); err != nil {
+			return evicted, err
+		}
+		evicted++
+		if err := waitForPodGone(ctx, pod, timeout, client); err != nil {
+			return evicted, err
+		}
+		if _, err := WaitForRollout(ctx, workload, timeout, client, nil); err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, nil
+}
+
+// WorkloadPods lists the pods selected by the workload that it controls,
+// directly or, for Deployments, through one of its ReplicaSets.
+func WorkloadPods(ctx context.Context, workload *Workload, client kubernetes.Interface) ([]v1.Pod, error) {
+	var selector *metav1.LabelSelector
+	switch obj := workload.Object.(type) {
+	case *appsv1.Deployment:
+		selector = obj.Spec.Selector
+	case *appsv1.StatefulSet:
+		selector = obj.Spec.Selector
+	case *appsv1.DaemonSet:
+		selector = obj.Spec.Selector
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector on %s: %v", workload, err)
+	}
+
+	list, err := apiClient(client).ListPods(ctx, workload.Namespace, metav1.ListOptions{LabelSelector: labelSelector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var pods []v1.Pod
+	for _, pod := range list.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil {
+			continue
+		}
+		if owner.Kind == workload.Kind && owner.Name == workload.Name {
+			pods = append(pods, pod)
+			continue
+		}
+		if workload.Kind == KindDeployment && owner.Kind == "ReplicaSet" {
+			if deployment, err := ResolveDeployment(ctx, &pod, client); err == nil && deployment.Name == workload.Name {
+				pods = append(pods, pod)
+			}
+		}
+	}
+	return pods, nil
+}
+
+// evictWithRetry evicts the pod, retrying while a PodDisruptionBudget refuses
+// the eviction.
+func evictWithRetry(ctx context.Context, pod *v1.Pod, timeout time.Duration, client kubernetes.Interface) error {
+	var lastErr error
+	err := wait.PollImmediateWithContext(ctx, evictionRetryInterval, timeout, func(ctx context.Context) (bool, error) {
+		lastErr = evictPod(ctx, pod, client)
+		switch {
+		case lastErr == nil, apierrors.IsNotFound(lastErr):
+			return true, nil
+		case apierrors.IsTooManyRequests(lastErr):
+			podLog(ctx, pod).with("action", "evict").infof("Eviction of pod %s/%s blocked by a PodDisruptionBudget, retrying\n", pod.Namespace, pod.Name)
+			return false, nil
+		}
+		return false, fmt.Errorf("error evicting pod: %v", lastErr)
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("pod %s/%s could not be evicted within %s: %v", pod.Namespace, pod.Name, timeout, lastErr)
+	}
+	return err
+}
+
+// evictPod submits an Eviction for the pod. Unlike DeletePod it returns the
+// API error unwrapped so callers can tell a PodDisruptionBudget refusal apart.
+func evictPod(ctx context.Context, pod *v1.Pod, client kubernetes.Interface) error {
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	podLog(ctx, pod).with("action", "evict").infof("Evicting pod: %s\n", pod.Name)
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	return client.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction)
+}
+
+// waitForPodGone waits until the evicted pod no longer exists under its UID.
+func waitForPodGone(ctx context.Context, pod *v1.Pod, timeout time.Duration, client kubernetes.Interface) error {
+	err := wait.PollImmediateWithContext(ctx, time.Second, timeout, func(ctx context.Context) (bool, error) {
+		ctx, cancel := withRequestTimeout(ctx)
+		defer cancel()
+		current, err := client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return current.UID != pod.UID, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("pod %s/%s was not removed within %s", pod.Namespace, pod.Name, timeout)
+	}
+	return err
+}
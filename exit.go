@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/testpractive123/assessment-devops.git/pkg/restarter"
+)
+
+// Process exit codes.
+const (
+	// ExitOK means every matched workload was handled successfully.
+	ExitOK = 0
+	// ExitConfigError means invalid flags, config file or kubeconfig.
+	ExitConfigError = 1
+	// ExitPartialFailure means at least one API call or restart failed.
+	ExitPartialFailure = 2
+	// ExitNoMatch means the run finished without matching any pod.
+	ExitNoMatch = 3
+	// ExitForbidden means at least one API call was denied for lack of a
+	// permission.
+	ExitForbidden = 4
+	// ExitInterrupted means the run was stopped by SIGINT or SIGTERM.
+	ExitInterrupted = 130
+)
+
+// ExitCode derives the exit code of a run from its results. Missing
+// permissions take precedence over other failures, and failures over an empty
+// match.
+func ExitCode(results []Result, failed bool) int {
+	forbidden := false
+	for _, r := range results {
+		if r.Status == StatusFailed {
+			failed = true
+			forbidden = forbidden || r.ErrorKind == restarter.ErrorKindForbidden
+		}
+	}
+	switch {
+	case forbidden:
+		return ExitForbidden
+	case failed:
+		return ExitPartialFailure
+	case len(results) == 0:
+		return ExitNoMatch
+	}
+	return ExitOK
+}
+
+// ExitError carries a process exit code out of a command. Err, when set, is
+// printed before exiting.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// configError returns an ExitError with ExitConfigError.
+func configError(format string, args ...interface{}) error {
+	return &ExitError{Code: ExitConfigError, Err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// EnvPrefix prefixes the environment variables that mirror command-line flags.
+const EnvPrefix = "RESTARTER_"
+
+// envNameOverrides holds the flags whose environment variable does not follow
+// the upper-snake-case flag name.
+var envNameOverrides = map[string]string{
+	"namespace":   "NAMESPACES",
+	"match-regex": "MATCH",
+}
+
+// EnvName returns the environment variable for a flag, e.g. dry-run becomes
+// RESTARTER_DRY_RUN.
+func EnvName(flagName string) string {
+	if name, ok := envNameOverrides[flagName]; ok {
+		return EnvPrefix + name
+	}
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag that was not given on the command line from its
+// environment variable, so explicit flags take precedence.
+func applyEnv(fs *pflag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || err != nil {
+			return
+		}
+		value, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, EnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// ParseDuration extends time.ParseDuration with a leading day component, so
+// both "30d" and "1d12h" are accepted.
+func ParseDuration(value string) (time.Duration, error) {
+	if i := strings.Index(value, "d"); i > 0 {
+		days, err := strconv.Atoi(value[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		total := time.Duration(days) * 24 * time.Hour
+		if rest := value[i+1:]; rest != "" {
+			d, err := time.ParseDuration(rest)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			total += d
+		}
+		return total, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// durationFlag is a pflag.Value for durations that may use days.
+type durationFlag time.Duration
+
+func (d *durationFlag) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationFlag) Set(value string) error {
+	parsed, err := ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = durationFlag(parsed)
+	return nil
+}
+
+func (d *durationFlag) Type() string {
+	return "duration"
+}
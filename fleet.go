@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fleet is the cluster inventory passed with --fleet.
+type Fleet struct {
+	Clusters []FleetCluster `yaml:"clusters"`
+}
+
+// FleetCluster is a cluster of the fleet and how its run differs from the
+// one the flags describe.
+type FleetCluster struct {
+	// Name identifies the cluster in the output and metrics; it defaults
+	// to Context.
+	Name string `yaml:"name"`
+	// Kubeconfig is the kubeconfig file of the cluster; it defaults to
+	// --kubeconfig.
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Context is the kubeconfig context; it defaults to the current one.
+	Context string `yaml:"context"`
+	// Namespaces replaces --namespace, and limits the rules that name no
+	// namespaces to them.
+	Namespaces []string `yaml:"namespaces"`
+	// ExcludeNamespaces replaces --exclude-namespaces.
+	ExcludeNamespaces []string `yaml:"excludeNamespaces"`
+	// Config is a rules file replacing --config and the selection flags.
+	Config string `yaml:"config"`
+	// Rules replace --config and the selection flags, as in a rules file.
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadFleet reads and validates a fleet file. Relative kubeconfig and config
+// paths are relative to the fleet file. Unknown fields and invalid values are
+// reported with the line they appear on.
+func LoadFleet(path string) (*Fleet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fleet: %v", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, &ConfigError{Path: path, Msg: err.Error()}
+	}
+
+	var fleet Fleet
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fleet); err != nil {
+		return nil, &ConfigError{Path: path, Msg: err.Error()}
+	}
+	if len(fleet.Clusters) == 0 {
+		return nil, &ConfigError{Path: path, Msg: "no clusters defined"}
+	}
+
+	dir := filepath.Dir(path)
+	clusterNodes := sequenceItems(mappingValue(documentNode(&root), "clusters"))
+	names := map[string]bool{}
+	for i := range fleet.Clusters {
+		cluster := &fleet.Clusters[i]
+		var clusterNode *yaml.Node
+		if i < len(clusterNodes) {
+			clusterNode = clusterNodes[i]
+		}
+		line := func(field string) int {
+			if valueNode := mappingValue(clusterNode, field); valueNode != nil {
+				return valueNode.Line
+			}
+			if clusterNode != nil {
+				return clusterNode.Line
+			}
+			return 0
+		}
+
+		if cluster.Name == "" {
+			cluster.Name = cluster.Context
+		}
+		if cluster.Name == "" {
+			return nil, &ConfigError{Path: path, Line: line("name"), Msg: fmt.Sprintf("cluster %d: name or context is required", i+1)}
+		}
+		if names[cluster.Name] {
+			return nil, &ConfigError{Path: path, Line: line("name"), Msg: fmt.Sprintf("cluster %s: defined twice", cluster.Name)}
+		}
+		names[cluster.Name] = true
+		if cluster.Config != "" && len(cluster.Rules) > 0 {
+			return nil, &ConfigError{Path: path, Line: line("rules"), Msg: fmt.Sprintf("cluster %s: config and rules are mutually exclusive", cluster.Name)}
+		}
+		if cluster.Kubeconfig != "" && !filepath.IsAbs(cluster.Kubeconfig) {
+			cluster.Kubeconfig = filepath.Join(dir, cluster.Kubeconfig)
+		}
+		if cluster.Config != "" && !filepath.IsAbs(cluster.Config) {
+			cluster.Config = filepath.Join(dir, cluster.Config)
+		}
+
+		ruleNodes := sequenceItems(mappingValue(clusterNode, "rules"))
+		for j := range cluster.Rules {
+			if field, err := cluster.Rules[j].Validate(); err != nil {
+				ruleLine := line("rules")
+				if j < len(ruleNodes) {
+					ruleLine = ruleNodes[j].Line
+					if valueNode := mappingValue(ruleNodes[j], field); valueNode != nil {
+						ruleLine = valueNode.Line
+					}
+				}
+				return nil, &ConfigError{Path: path, Line: ruleLine, Msg: fmt.Sprintf("cluster %s: rule %d: %v", cluster.Name, j+1, err)}
+			}
+		}
+	}
+	return &fleet, nil
+}
+
+// pinNamespaces limits the rules that name no namespaces to the given ones.
+func pinNamespaces(rules []Rule, namespaces []string) []Rule {
+	pinned := make([]Rule, len(rules))
+	for i, rule := range rules {
+		if len(rule.Namespaces) == 0 {
+			rule.Namespaces = namespaces
+		}
+		pinned[i] = rule
+	}
+	return pinned
+}
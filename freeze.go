@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// freezeRefresh is how long a Runner goes by the freeze ConfigMap it read
+// before reading it again.
+const freezeRefresh = time.Minute
+
+// FreezeAlways is the value of a freeze ConfigMap entry that is in effect
+// until the entry is removed.
+const FreezeAlways = "always"
+
+// FreezeConfigMap holds change freezes declared centrally in a ConfigMap, one
+// data key per freeze, so restarts can be paused without redeploying. Runners
+// read it at most once a minute. A nil FreezeConfigMap freezes nothing.
+type FreezeConfigMap struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// ParseFreezeConfigMap splits a --freeze-configmap value, NAMESPACE/NAME or
+// NAME for a ConfigMap in the namespace of the Pod the process runs in, or
+// default.
+func ParseFreezeConfigMap(value string) (*FreezeConfigMap, error) {
+	namespace, name, err := splitConfigMapName(value)
+	if err != nil {
+		return nil, err
+	}
+	return &FreezeConfigMap{Namespace: namespace, Name: name}, nil
+}
+
+// freeze is one entry of a freeze ConfigMap: always in effect, a weekly
+// window, or an absolute time range.
+type freeze struct {
+	name, spec  string
+	always      bool
+	window      *MaintenanceWindow
+	from, until time.Time
+}
+
+// parseFreeze parses the value of a freeze ConfigMap entry: "always", a
+// weekly window in the maintenance window format, e.g. "Fri 16:00-23:59 UTC",
+// or an RFC 3339 range, e.g. "2026-12-20T00:00:00Z/2027-01-04T00:00:00Z".
+func parseFreeze(name, value string) (freeze, error) {
+	value = strings.TrimSpace(value)
+	f := freeze{name: name, spec: value}
+	if value == FreezeAlways {
+		f.always = true
+		return f, nil
+	}
+	if from, until, found := strings.Cut(value, "/"); found && !strings.Contains(value, " ") {
+		var err error
+		if f.from, err = time.Parse(time.RFC3339, from); err != nil {
+			return f, fmt.Errorf("invalid freeze %s: %v", name, err)
+		}
+		if f.until, err = time.Parse(time.RFC3339, until); err != nil {
+			return f, fmt.Errorf("invalid freeze %s: %v", name, err)
+		}
+		if !f.until.After(f.from) {
+			return f, fmt.Errorf("invalid freeze %s: %q ends before it starts", name, value)
+		}
+		return f, nil
+	}
+	window, err := ParseMaintenanceWindow(value)
+	if err != nil {
+		return f, fmt.Errorf("invalid freeze %s: %v", name, err)
+	}
+	f.window = &window
+	return f, nil
+}
+
+// active reports whether the freeze is in effect at t.
+func (f freeze) active(t time.Time) bool {
+	switch {
+	case f.always:
+		return true
+	case f.window != nil:
+		return f.window.Contains(t)
+	}
+	return !t.Before(f.from) && t.Before(f.until)
+}
+
+// Frozen reads the ConfigMap and returns why restarts are frozen at now, or
+// "" when no freeze is in effect. A missing ConfigMap freezes nothing; an
+// entry that cannot be parsed is in effect, so a typo never lifts a freeze.
+func (c *FreezeConfigMap) Frozen(ctx context.Context, now time.Time) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	var configMap *v1.ConfigMap
+	err := callAPI(ctx, func(ctx context.Context) error {
+		var err error
+		configMap, err = c.Client.CoreV1().ConfigMaps(c.Namespace).Get(ctx, c.Name, metav1.GetOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting ConfigMap %s/%s: %v", c.Namespace, c.Name, err)
+	}
+	names := make([]string, 0, len(configMap.Data))
+	for name := range configMap.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := parseFreeze(name, configMap.Data[name])
+		if err != nil {
+			warnf("Treating ConfigMap %s/%s entry as in effect: %v\n", c.Namespace, c.Name, err)
+			return fmt.Sprintf("frozen by %s in ConfigMap %s/%s, which is invalid", name, c.Namespace, c.Name), nil
+		}
+		if f.active(now) {
+			return fmt.Sprintf("frozen by %s (%s) in ConfigMap %s/%s", name, f.spec, c.Namespace, c.Name), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+
+	restarterv1 "github.com/testpractive123/assessment-devops.git/api/grpc/v1"
+)
+
+// GRPCServer implements the Restarter gRPC service on the restart engine.
+// Every call is a run of its own, like a request to the HTTP API.
+type GRPCServer struct {
+	restarterv1.UnimplementedRestarterServer
+
+	Client  kubernetes.Interface
+	Options RunOptions
+	// RunContext derives the context of a single call from BaseContext.
+	RunContext func(ctx context.Context) (context.Context, context.CancelFunc)
+	// BaseContext outlives single calls, so a restart in progress finishes
+	// even if the client cancels its stream.
+	BaseContext context.Context
+	// Output receives the results of every restart.
+	Output io.Writer
+	Format string
+
+	writeMu sync.Mutex
+}
+
+var progressPhases = map[string]restarterv1.RestartEvent_Phase{
+	PhaseRestarting: restarterv1.RestartEvent_PHASE_RESTARTING,
+	PhaseRollout:    restarterv1.RestartEvent_PHASE_ROLLOUT,
+	PhaseDone:       restarterv1.RestartEvent_PHASE_DONE,
+}
+
+// ListCandidates reports what a restart of the selection would do.
+func (s *GRPCServer) ListCandidates(ctx context.Context, req *restarterv1.ListCandidatesRequest) (*restarterv1.ListCandidatesResponse, error) {
+	rule := selectionRule(req.GetSelection())
+	if err := validateRequestRule(rule, s.Options.Scope); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	options := s.Options
+	options.DryRun = true
+	runCtx, cancel := s.RunContext(ctx)
+	defer cancel()
+	results, err := NewRunner(s.Client, options).ProcessRule(runCtx, rule)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	response := &restarterv1.ListCandidatesResponse{}
+	for i := range results {
+		response.Results = append(response.Results, resultMessage(&results[i]))
+	}
+	return response, nil
+}
+
+// Restart restarts the workloads behind the selected pods and streams their
+// progress. When some namespaces or pods could not be listed, the stream ends
+// with an Unavailable status after the events of the rest.
+func (s *GRPCServer) Restart(req *restarterv1.RestartRequest, stream restarterv1.Restarter_RestartServer) error {
+	rule := selectionRule(req.GetSelection())
+	if err := validateRequestRule(rule, s.Options.Scope); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	options := s.Options
+	options.DryRun = options.DryRun || req.GetDryRun()
+
+	// Workers report concurrently, and a stream may only be sent to from one
+	// goroutine at a time. Once the client is gone, events are dropped.
+	var mu sync.Mutex
+	var sendErr error
+	options.Progress = func(event ProgressEvent) {
+		message := &restarterv1.RestartEvent{
+			Phase:     progressPhases[event.Phase],
+			Kind:      event.Kind,
+			Namespace: event.Namespace,
+			Workload:  event.Workload,
+			Message:   event.Message,
+		}
+		if event.Result != nil {
+			message.Result = resultMessage(event.Result)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if sendErr == nil {
+			if sendErr = stream.Send(message); sendErr != nil {
+				warnf("Client of a gRPC restart went away, continuing without it: %v\n", sendErr)
+			}
+		}
+	}
+
+	ctx, cancel := s.RunContext(s.BaseContext)
+	defer cancel()
+	results, err := NewRunner(s.Client, options).ProcessRule(ctx, rule)
+	if !options.DryRun {
+		s.writeMu.Lock()
+		if err := WriteResults(s.Output, s.Format, results); err != nil {
+			errorf("Error writing results: %v\n", err)
+		}
+		s.writeMu.Unlock()
+	}
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+// selectionRule converts a Selection into a rule, to be validated.
+func selectionRule(selection *restarterv1.Selection) *Rule {
+	return &Rule{
+		Name:              "grpc",
+		Namespaces:        selection.GetNamespaces(),
+		NamespaceSelector: selection.GetNamespaceSelector(),
+		PodSelector:       selection.GetPodSelector(),
+		FieldSelector:     selection.GetFieldSelector(),
+		Match:             selection.GetMatch(),
+		OnlyUnhealthy:     selection.GetOnlyUnhealthy(),
+		OOMKills:          selection.GetOomKills(),
+		RestartCount:      selection.GetRestartCount(),
+		OlderThan:         selection.GetOlderThan(),
+	}
+}
+
+func resultMessage(result *Result) *restarterv1.Result {
+	return &restarterv1.Result{
+		Rule:      result.Rule,
+		Namespace: result.Namespace,
+		Pod:       result.Pod,
+		Kind:      result.Kind,
+		Workload:  result.Workload,
+		Status:    result.Status,
+		Trigger:   result.Trigger,
+		Rollout:   result.Rollout,
+		Reason:    result.Reason,
+		Error:     result.Error,
+	}
+}
+
+// grpcTokenInterceptors reject calls without "authorization: Bearer <token>"
+// metadata. An empty token lets every call through.
+func grpcTokenInterceptors(token string) []grpc.ServerOption {
+	if token == "" {
+		return nil
+	}
+	want := []byte("Bearer " + token)
+	authorize := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), want) != 1 {
+			return status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+)
+
+// cacheSync tracks the informer caches readyz waits for. Modes register their
+// caches before waiting for them to sync.
+var cacheSync = &cacheHealth{caches: map[string]bool{}}
+
+// cacheHealth records which informer caches have synced.
+type cacheHealth struct {
+	mu     sync.Mutex
+	caches map[string]bool
+}
+
+// syncing registers a cache that has not synced yet and returns the function
+// that marks it synced.
+func (h *cacheHealth) syncing(name string) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.caches[name] = false
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.caches[name] = true
+	}
+}
+
+// unsynced returns the registered caches that have not synced yet.
+func (h *cacheHealth) unsynced() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var names []string
+	for name, synced := range h.caches {
+		if !synced {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkAPIServer fails when the API server does not answer.
+func checkAPIServer(ctx context.Context, client kubernetes.Interface) error {
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	if err := client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return fmt.Errorf("API server unreachable: %v", err)
+	}
+	return nil
+}
+
+// healthOptions configures the liveness and readiness endpoints of the
+// long-running modes.
+type healthOptions struct {
+	listen string
+}
+
+func (h *healthOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&h.listen, "health-listen", "", "address to serve the /healthz liveness and /readyz readiness probes on, e.g. :8081 (empty disables them)")
+}
+
+// bindAddress returns the listen address in the form of controller-runtime's
+// HealthProbeBindAddress, where "0" disables the endpoints.
+func (h *healthOptions) bindAddress() string {
+	if h.listen == "" {
+		return "0"
+	}
+	return h.listen
+}
+
+// start serves the probes in the background until ctx is cancelled. healthz
+// succeeds as long as the process serves it; readyz fails while an informer
+// cache is syncing or the API server does not answer. Replicas waiting for
+// the leader election Lease have no caches and are ready when the API server
+// answers.
+func (h *healthOptions) start(ctx context.Context, client kubernetes.Interface) error {
+	if h.listen == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", h.listen)
+	if err != nil {
+		return configError("invalid --health-listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		var problems []string
+		if unsynced := cacheSync.unsynced(); len(unsynced) > 0 {
+			problems = append(problems, "caches not synced: "+strings.Join(unsynced, ", "))
+		}
+		if err := checkAPIServer(r.Context(), client); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if len(problems) > 0 {
+			debugf("Not ready: %s\n", strings.Join(problems, "; "))
+			http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	infof("Serving health probes at %s/healthz and /readyz\n", listener.Addr())
+	go func() {
+		if err := serveListener(ctx, listener, mux, nil); err != nil {
+			errorf("Error serving health probes: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Helm 3 annotates every resource of a release with its name, and charts
+// following the Helm best practices label resources and their pods with the
+// chart and the release (instance).
+const (
+	AnnotationHelmReleaseName = "meta.helm.sh/release-name"
+	LabelHelmChart            = "helm.sh/chart"
+	LabelManagedBy            = "app.kubernetes.io/managed-by"
+	LabelInstance             = "app.kubernetes.io/instance"
+)
+
+// HelmRelease returns the Helm release the workload belongs to, or "" when it
+// was not installed by Helm.
+func HelmRelease(workload *Workload) string {
+	if release := workload.Annotations[AnnotationHelmReleaseName]; release != "" {
+		return release
+	}
+	obj, ok := workload.Object.(metav1.Object)
+	if !ok {
+		return ""
+	}
+	labels := obj.GetLabels()
+	if labels[LabelHelmChart] != "" || strings.EqualFold(labels[LabelManagedBy], "Helm") {
+		return labels[LabelInstance]
+	}
+	return ""
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// History keeps the last restart of every workload in a ConfigMap, one data
+// key per workload, so cooldowns and the history command survive process
+// restarts and cover restarts that leave no restartedAt annotation, such as
+// evictions. A nil History records and returns nothing.
+type History struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// HistoryEntry is the last restart of a workload.
+type HistoryEntry struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Time      time.Time `json:"time"`
+	// Action is restart or evict.
+	Action  string `json:"action"`
+	Rule    string `json:"rule,omitempty"`
+	Trigger string `json:"trigger,omitempty"`
+	// Source says what selected the workload, e.g. "pod web-5d8f".
+	Source string `json:"source,omitempty"`
+}
+
+// ParseHistoryConfigMap splits a --history-configmap value, NAMESPACE/NAME or
+// NAME for a ConfigMap in the namespace of the Pod the process runs in, or
+// default.
+func ParseHistoryConfigMap(value string) (*History, error) {
+	namespace, name, err := splitConfigMapName(value)
+	if err != nil {
+		return nil, err
+	}
+	return &History{Namespace: namespace, Name: name}, nil
+}
+
+// splitConfigMapName splits NAMESPACE/NAME, or NAME for a ConfigMap in the
+// namespace of the Pod the process runs in, or default.
+func splitConfigMapName(value string) (string, string, error) {
+	namespace, name, found := strings.Cut(value, "/")
+	if !found {
+		namespace, name = ownNamespace(), value
+	}
+	if namespace == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("%q is not NAMESPACE/NAME or NAME", value)
+	}
+	return namespace, name, nil
+}
+
+// historyKey is the ConfigMap data key of a workload. Kubernetes names
+// cannot contain "_", so the key cannot be ambiguous.
+func historyKey(kind, namespace, name string) string {
+	return strings.ToLower(kind) + "_" + namespace + "_" + name
+}
+
+// Record stores the restart of a workload, replacing its previous entry. The
+// ConfigMap is created on first use. Errors are logged; the restart has
+// already happened.
+func (h *History) Record(ctx context.Context, entry HistoryEntry) {
+	if h == nil {
+		return
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		errorf("Error encoding the history entry: %v\n", err)
+		return
+	}
+	key := historyKey(entry.Kind, entry.Namespace, entry.Name)
+	patch, err := json.Marshal(map[string]interface{}{"data": map[string]string{key: string(value)}})
+	if err != nil {
+		errorf("Error encoding the history patch: %v\n", err)
+		return
+	}
+
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	configMaps := h.Client.CoreV1().ConfigMaps(h.Namespace)
+	_, err = configMaps.Patch(ctx, h.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: h.Name, Namespace: h.Namespace, Labels: map[string]string{"app.kubernetes.io/managed-by": "restarter"}},
+			Data:       map[string]string{key: string(value)},
+		}, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = configMaps.Patch(ctx, h.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		}
+	}
+	if err != nil {
+		warnf("Error recording the restart of %s/%s in ConfigMap %s/%s: %v\n", entry.Namespace, entry.Name, h.Namespace, h.Name, err)
+	}
+}
+
+// LastRestart returns when the history last saw the workload restarted.
+// A missing ConfigMap or entry is no restart; other errors are logged.
+func (h *History) LastRestart(ctx context.Context, workload *Workload) (time.Time, bool) {
+	if h == nil {
+		return time.Time{}, false
+	}
+	configMap, err := h.get(ctx)
+	if err != nil {
+		warnf("Error reading the restart history: %v\n", err)
+		return time.Time{}, false
+	}
+	if configMap == nil {
+		return time.Time{}, false
+	}
+	value, ok := configMap.Data[historyKey(workload.Kind, workload.Namespace, workload.Name)]
+	if !ok {
+		return time.Time{}, false
+	}
+	var entry HistoryEntry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		return time.Time{}, false
+	}
+	return entry.Time, true
+}
+
+// Entries returns every entry, most recent first.
+func (h *History) Entries(ctx context.Context) ([]HistoryEntry, error) {
+	configMap, err := h.get(ctx)
+	if err != nil || configMap == nil {
+		return nil, err
+	}
+	entries := make([]HistoryEntry, 0, len(configMap.Data))
+	for key, value := range configMap.Data {
+		var entry HistoryEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			warnf("Skipping history entry %s: %v\n", key, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	return entries, nil
+}
+
+// get reads the ConfigMap, returning nil when it does not exist yet.
+func (h *History) get(ctx context.Context) (*v1.ConfigMap, error) {
+	var configMap *v1.ConfigMap
+	err := callAPI(ctx, func(ctx context.Context) error {
+		var err error
+		configMap, err = h.Client.CoreV1().ConfigMaps(h.Namespace).Get(ctx, h.Name, metav1.GetOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting ConfigMap %s/%s: %v", h.Namespace, h.Name, err)
+	}
+	return configMap, nil
+}
+
+// WriteHistory renders history entries in the requested format; text is a
+// table like the table format.
+func WriteHistory(w io.Writer, format string, entries []HistoryEntry) error {
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Entries []HistoryEntry `json:"entries"`
+		}{entries})
+	case OutputYAML:
+		data, err := yaml.Marshal(struct {
+			Entries []HistoryEntry `json:"entries"`
+		}{entries})
+		if err != nil {
+			return fmt.Errorf("error encoding the history: %v", err)
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tWORKLOAD\tRESTARTED\tACTION\tRULE\tSOURCE")
+	for _, e := range entries {
+		source := e.Source
+		if e.Trigger != "" {
+			source += " (" + e.Trigger + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s/%s\t%s\t%s\t%s\t%s\n", e.Namespace, e.Kind, e.Name, e.Time.Local().Format(time.RFC3339), e.Action, e.Rule, source)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Hook phases.
+const (
+	HookPreRestart  = "preRestart"
+	HookPostRestart = "postRestart"
+)
+
+// What a failing hook does.
+const (
+	// HookFail fails the result: a failing preRestart hook aborts the
+	// restart, a failing postRestart hook reports the restart as failed.
+	HookFail = "fail"
+	// HookIgnore logs a warning and carries on.
+	HookIgnore = "ignore"
+)
+
+// DefaultHookTimeout bounds a hook without a timeout of its own.
+const DefaultHookTimeout = 30 * time.Second
+
+// hookOutputLimit caps how much of a failed hook's output its error quotes.
+const hookOutputLimit = 512
+
+// Hook is a command run before or after a workload is restarted, e.g. to
+// flush a cache or warm up connections.
+type Hook struct {
+	// Command is the command and its arguments. It runs where the restarter
+	// runs, with the workload in RESTARTER_* environment variables.
+	Command []string `yaml:"command"`
+	// Exec runs Command in every running pod of the workload instead,
+	// through the pods' exec subresource.
+	Exec bool `yaml:"exec"`
+	// Container is the container Exec runs Command in, by default the
+	// pod's first.
+	Container string `yaml:"container"`
+	// Timeout bounds the hook, DefaultHookTimeout by default.
+	Timeout *time.Duration `yaml:"timeout"`
+	// OnFailure is HookFail or HookIgnore. preRestart hooks default to
+	// HookFail, postRestart hooks to HookIgnore.
+	OnFailure string `yaml:"onFailure"`
+}
+
+// validate checks the hook and fills in the default OnFailure of phase.
+func (h *Hook) validate(phase string) error {
+	if len(h.Command) == 0 {
+		return fmt.Errorf("hooks need a command")
+	}
+	if h.Container != "" && !h.Exec {
+		return fmt.Errorf("container is only used by exec hooks")
+	}
+	if h.Timeout != nil && *h.Timeout <= 0 {
+		return fmt.Errorf("hook timeout must be positive")
+	}
+	switch h.OnFailure {
+	case "":
+		h.OnFailure = HookFail
+		if phase == HookPostRestart {
+			h.OnFailure = HookIgnore
+		}
+	case HookFail, HookIgnore:
+	default:
+		return fmt.Errorf("unknown onFailure %q (want %s or %s)", h.OnFailure, HookFail, HookIgnore)
+	}
+	return nil
+}
+
+// ShellHook returns a hook running command with sh -c, as the
+// --pre-restart-hook and --post-restart-hook flags give it.
+func ShellHook(command string) Hook {
+	return Hook{Command: []string{"sh", "-c", command}}
+}
+
+func (h *Hook) String() string {
+	return strings.Join(h.Command, " ")
+}
+
+// hasExecHooks reports whether any hook of the rule runs in pods.
+func (r *Rule) hasExecHooks() bool {
+	for _, hooks := range [][]Hook{r.PreRestart, r.PostRestart} {
+		for _, hook := range hooks {
+			if hook.Exec {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runHooks runs the hooks of phase for the workload in order. It stops at
+// the first failing HookFail hook and returns its error; failing HookIgnore
+// hooks are only logged.
+func (r *Runner) runHooks(ctx context.Context, phase string, hooks []Hook, rule *Rule, workload *Workload, result Result, log logFields) error {
+	for i := range hooks {
+		hook := &hooks[i]
+		log.infof("Running %s hook of %s: %s\n", phase, workload, hook)
+		err := r.runHook(ctx, phase, hook, rule, workload, result)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("%s hook %q failed: %w", phase, hook.String(), err)
+		if hook.OnFailure == HookIgnore {
+			log.warnf("%v\n", err)
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// runHook runs a single hook within its timeout.
+func (r *Runner) runHook(ctx context.Context, phase string, hook *Hook, rule *Rule, workload *Workload, result Result) error {
+	timeout := DefaultHookTimeout
+	if hook.Timeout != nil {
+		timeout = *hook.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var err error
+	if hook.Exec {
+		err = execHook(ctx, hook, workload, r.Options.RestConfig, r.Client)
+	} else {
+		err = commandHook(ctx, hook, hookEnv(phase, rule, workload, result))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// hookEnv returns the environment variables describing the restart to
+// local hooks.
+func hookEnv(phase string, rule *Rule, workload *Workload, result Result) []string {
+	return []string{
+		"RESTARTER_HOOK=" + phase,
+		"RESTARTER_RULE=" + rule.Name,
+		"RESTARTER_KIND=" + workload.Kind,
+		"RESTARTER_NAMESPACE=" + workload.Namespace,
+		"RESTARTER_WORKLOAD=" + workload.Name,
+		"RESTARTER_POD=" + result.Pod,
+		"RESTARTER_TRIGGER=" + result.Trigger,
+		"RESTARTER_STATUS=" + result.Status,
+	}
+}
+
+// commandHook runs a hook where the restarter runs.
+func commandHook(ctx context.Context, hook *Hook, env []string) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		return hookError(err, output.String())
+	}
+	return nil
+}
+
+// execHook runs a hook in every running pod of the workload, one pod after
+// the other.
+func execHook(ctx context.Context, hook *Hook, workload *Workload, config *rest.Config, client kubernetes.Interface) error {
+	if config == nil {
+		return fmt.Errorf("exec hooks need the client configuration of the cluster")
+	}
+	pods, err := WorkloadPods(ctx, workload, client)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if err := execInPod(ctx, pod, hook.Container, hook.Command, config, client); err != nil {
+			return fmt.Errorf("pod %s: %w", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// execInPod runs command in a container of the pod, the first for "".
+func execInPod(ctx context.Context, pod *v1.Pod, container string, command []string, config *rest.Config, client kubernetes.Interface) error {
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+	request := client.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{Container: container, Command: command, Stdout: true, Stderr: true}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", request.URL())
+	if err != nil {
+		return fmt.Errorf("error creating the exec stream: %v", err)
+	}
+	var output bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &output, Stderr: &output}); err != nil {
+		return hookError(err, output.String())
+	}
+	return nil
+}
+
+// hookError adds the tail of a failed hook's output to its error.
+func hookError(err error, output string) error {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return err
+	}
+	if len(output) > hookOutputLimit {
+		output = "..." + output[len(output)-hookOutputLimit:]
+	}
+	return fmt.Errorf("%w: %s", err, output)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// shutdownTimeout bounds how long a server waits for requests in progress
+// when it is stopped.
+const shutdownTimeout = 30 * time.Second
+
+// serveHTTP serves handler on listen until ctx is cancelled, then stops
+// taking requests and waits up to shutdownTimeout for those in progress. With
+// a tlsConfig it serves HTTPS.
+func serveHTTP(ctx context.Context, listen string, handler http.Handler, tlsConfig *tls.Config) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return configError("invalid --listen: %v", err)
+	}
+	infof("Listening on %s\n", listener.Addr())
+	return serveListener(ctx, listener, handler, tlsConfig)
+}
+
+// serveListener is serveHTTP on an open listener.
+func serveListener(ctx context.Context, listener net.Listener, handler http.Handler, tlsConfig *tls.Config) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second, TLSConfig: tlsConfig}
+	served := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			served <- server.ServeTLS(listener, "", "")
+			return
+		}
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	infof("Shutting down, waiting for requests in progress\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		warnf("Requests still in progress after %s are abandoned: %v\n", shutdownTimeout, err)
+	}
+	return nil
+}
+
+// readToken reads a bearer token from path; an empty path means none.
+func readToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", configError("invalid --token-file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", configError("invalid --token-file: %s is empty", path)
+	}
+	return token, nil
+}
+
+// requireToken rejects requests without an "Authorization: Bearer <token>"
+// header. An empty token lets every request through.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
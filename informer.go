@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// PodWatcher reacts to pods as they are added or change, using shared
+// informers instead of periodic list-and-restart scans.
+type PodWatcher struct {
+	Client  kubernetes.Interface
+	Rules   []Rule
+	Options RunOptions
+	// Resync is how often every cached pod is re-evaluated. It also bounds
+	// how long a restarted workload is remembered: each resync starts a new
+	// run, so a workload is acted on at most once per period.
+	Resync time.Duration
+	// Output receives one result document per processed pod.
+	Output io.Writer
+	Format string
+	// CacheWorkloads also caches the workloads that own the pods, so
+	// resolving them does not hit the API server.
+	CacheWorkloads bool
+
+	pods       corelisters.PodLister
+	namespaces corelisters.NamespaceLister
+	// namespace is the only watched namespace in namespace scope, where
+	// there is no namespace cache; empty means all namespaces.
+	namespace string
+	// events indexes pod Events by pod UID; nil unless a rule has event
+	// triggers.
+	events cache.Indexer
+	queue  workqueue.RateLimitingInterface
+
+	mu     sync.Mutex
+	runner *Runner
+}
+
+// ValidateWatchRules rejects rules that would restart every matching pod as it
+// appears. Reacting to pod changes only makes sense with a trigger: without
+// one, the pods created by a restart would match again straight away.
+func ValidateWatchRules(rules []Rule) error {
+	for i := range rules {
+		if rules[i].Action != ActionReport && !rules[i].triggers.Active() {
+			return fmt.Errorf("rule %s has no trigger (onlyUnhealthy, oomKills, restartCount, events, imageDrift or olderThan)", rules[i].Name)
+		}
+	}
+	return nil
+}
+
+// Run starts the informers and processes pod events with Options.Concurrency
+// workers until ctx is cancelled. Restarts in flight when ctx is cancelled
+// are allowed to finish; restarts held for a maintenance window are skipped.
+// In namespace scope, only the pods of the single namespace the rules target
+// are watched.
+func (w *PodWatcher) Run(ctx context.Context) error {
+	var factoryOptions []informers.SharedInformerOption
+	if w.Options.Scope == ScopeNamespace {
+		namespace, err := singleNamespace(w.Rules)
+		if err != nil {
+			return err
+		}
+		w.namespace = namespace
+		factoryOptions = append(factoryOptions, informers.WithNamespace(namespace))
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(w.Client, w.Resync, factoryOptions...)
+	podInformer := factory.Core().V1().Pods()
+	w.pods = podInformer.Lister()
+	if w.namespace == "" {
+		w.namespaces = factory.Core().V1().Namespaces().Lister()
+	}
+	if w.CacheWorkloads {
+		w.Options.Cache = NewWorkloadCache(factory, w.namespace == "")
+	}
+	w.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	w.Options.Stop = ctx.Done()
+	w.runner = NewRunner(w.Client, w.Options)
+
+	enqueue := func(obj interface{}) {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			w.queue.Add(key)
+		}
+	}
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	})
+	if w.needsEvents() {
+		w.addEventInformer(factory)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	infof("Waiting for the informer caches to sync\n")
+	markSynced := cacheSync.syncing("pods")
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			w.queue.ShutDown()
+			return fmt.Errorf("error syncing the %v cache", informerType)
+		}
+	}
+	markSynced()
+	infof("Watching pods, resyncing every %s\n", w.Resync)
+
+	workers := w.Options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for w.processNext(ctx) {
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(w.Resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			infof("Shutting down, waiting for in-flight restarts\n")
+			w.queue.ShutDown()
+			wg.Wait()
+			return nil
+		case <-ticker.C:
+			w.mu.Lock()
+			w.runner = NewRunner(w.Client, w.Options)
+			w.mu.Unlock()
+		}
+	}
+}
+
+// needsEvents reports whether any rule has event triggers.
+func (w *PodWatcher) needsEvents() bool {
+	for i := range w.Rules {
+		if w.Rules[i].triggers.NeedsEvents() {
+			return true
+		}
+	}
+	return false
+}
+
+// eventPodIndex indexes Events by the UID of the pod they involve.
+const eventPodIndex = "involvedPod"
+
+// addEventInformer watches pod Events and queues the pod each one involves,
+// so event triggers fire when the Event is recorded rather than at the next
+// pod change.
+func (w *PodWatcher) addEventInformer(factory informers.SharedInformerFactory) {
+	informer := factory.InformerFor(&v1.Event{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredEventInformer(client, w.namespace, resync, cache.Indexers{
+			eventPodIndex: func(obj interface{}) ([]string, error) {
+				return []string{string(obj.(*v1.Event).InvolvedObject.UID)}, nil
+			},
+		}, func(options *metav1.ListOptions) {
+			options.FieldSelector = "involvedObject.kind=Pod"
+		})
+	})
+	w.events = informer.GetIndexer()
+	enqueue := func(obj interface{}) {
+		if event, ok := obj.(*v1.Event); ok {
+			w.queue.Add(event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	})
+}
+
+// podEvents returns the cached Events involving the pod.
+func (w *PodWatcher) podEvents(pod *v1.Pod) []v1.Event {
+	if w.events == nil {
+		return nil
+	}
+	objs, err := w.events.ByIndex(eventPodIndex, string(pod.UID))
+	if err != nil {
+		return nil
+	}
+	events := make([]v1.Event, 0, len(objs))
+	for _, obj := range objs {
+		events = append(events, *obj.(*v1.Event))
+	}
+	return events
+}
+
+// processNext handles one queued pod and reports whether the queue is still
+// open. ctx only bounds evaluating the pod: a restart, once started, is not
+// abandoned when ctx is cancelled.
+func (w *PodWatcher) processNext(ctx context.Context) bool {
+	item, shutdown := w.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.queue.Done(item)
+	w.queue.Forget(item)
+
+	key := item.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return true
+	}
+	pod, err := w.pods.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		errorf("Error reading pod %s from the cache: %v\n", key, err)
+		return true
+	}
+	ns, err := w.podNamespace(namespace)
+	if err != nil {
+		debugf("Skipping pod %s: namespace not in the cache: %v\n", key, err)
+		return true
+	}
+
+	w.mu.Lock()
+	runner := w.runner
+	w.mu.Unlock()
+	metricPodsScanned.WithLabelValues("").Inc()
+	events := w.podEvents(pod)
+	for i := range w.Rules {
+		rule := &w.Rules[i]
+		if !ruleSelectsNamespace(rule, ns, w.Options.ExcludeNamespaces) || !ruleSelectsPodFields(rule, pod) {
+			continue
+		}
+		fired, trigger := selectPod(ctx, rule, pod, runner.observe(ctx, rule, pod, events))
+		if !fired {
+			continue
+		}
+		// Work on a copy: objects from the cache are shared and read-only.
+		result := runner.processPod(context.Background(), rule, pod.DeepCopy(), trigger, ns.Annotations)
+		w.write(result)
+	}
+	return true
+}
+
+// podNamespace returns the cached namespace, or one without labels and
+// annotations in namespace scope.
+func (w *PodWatcher) podNamespace(name string) (*v1.Namespace, error) {
+	if w.namespaces == nil {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+	}
+	return w.namespaces.Get(name)
+}
+
+// write emits a single result. Writes are serialized across workers.
+func (w *PodWatcher) write(result Result) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := WriteResults(w.Output, w.Format, []Result{result}); err != nil {
+		errorf("Error writing results: %v\n", err)
+	}
+}
+
+// ruleSelectsNamespace applies the namespace part of a rule to a cached
+// namespace, the way TargetNamespaces does for listed ones.
+func ruleSelectsNamespace(rule *Rule, ns *v1.Namespace, exclude []string) bool {
+	if namespaceExcluded(rule, ns.Name, exclude) {
+		return false
+	}
+	if len(rule.Namespaces) > 0 {
+		for _, name := range rule.Namespaces {
+			if name == ns.Name {
+				return true
+			}
+		}
+		return false
+	}
+	selector, err := labels.Parse(rule.NamespaceSelector)
+	return err == nil && selector.Matches(labels.Set(ns.Labels))
+}
+
+// ruleSelectsPodFields applies the rule's pod label and field selectors on
+// the client side, since the informer caches every pod.
+func ruleSelectsPodFields(rule *Rule, pod *v1.Pod) bool {
+	labelSelector, err := labels.Parse(rule.podSelector())
+	if err != nil || !labelSelector.Matches(labels.Set(pod.Labels)) {
+		return false
+	}
+	fieldSelector, err := fields.ParseSelector(rule.FieldSelector)
+	return err == nil && fieldSelector.Matches(podFields(pod))
+}
+
+// podFields returns the pod fields the API server supports in field
+// selectors.
+func podFields(pod *v1.Pod) fields.Set {
+	return fields.Set{
+		"metadata.name":            pod.Name,
+		"metadata.namespace":       pod.Namespace,
+		"spec.nodeName":            pod.Spec.NodeName,
+		"spec.restartPolicy":       string(pod.Spec.RestartPolicy),
+		"spec.schedulerName":       pod.Spec.SchedulerName,
+		"spec.serviceAccountName":  pod.Spec.ServiceAccountName,
+		"status.phase":             string(pod.Status.Phase),
+		"status.podIP":             pod.Status.PodIP,
+		"status.nominatedNodeName": pod.Status.NominatedNodeName,
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Default client-side rate limits of API requests, well above client-go's
+// 5 queries per second and burst of 10, which throttle scans of large
+// clusters.
+const (
+	DefaultQPS   = 50
+	DefaultBurst = 100
+)
+
+// Encodings of Kubernetes API requests and responses.
+const (
+	ContentTypeProtobuf = "protobuf"
+	ContentTypeJSON     = "json"
+)
+
+// ValidateContentType rejects unknown --kube-api-content-type values.
+func ValidateContentType(contentType string) error {
+	switch contentType {
+	case ContentTypeProtobuf, ContentTypeJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown content type %q (want %s or %s)", contentType, ContentTypeProtobuf, ContentTypeJSON)
+}
+
+// BuildConfig loads the client configuration. An explicit path wins; otherwise
+// the standard client-go loading rules apply ($KUBECONFIG, then ~/.kube/config).
+// An empty contextName selects the kubeconfig's current context.
+//
+// When neither a path, a context nor $KUBECONFIG is given and the process runs
+// inside a Pod, the in-cluster service account configuration is used instead.
+func BuildConfig(kubeconfigPath string, contextName string) (*rest.Config, error) {
+	if kubeconfigPath == "" && contextName == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			infof("Using in-cluster configuration\n")
+			return config, nil
+		}
+		if err != rest.ErrNotInCluster {
+			return nil, fmt.Errorf("error loading in-cluster config: %v", err)
+		}
+	}
+
+	loadingRules := newLoadingRules(kubeconfigPath)
+	if kubeconfigPath != "" {
+		infof("Using kubeconfig: %s\n", kubeconfigPath)
+	} else {
+		infof("Using kubeconfig: %s\n", strings.Join(loadingRules.GetLoadingPrecedence(), string(os.PathListSeparator)))
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	if contextName != "" {
+		infof("Using context: %s\n", contextName)
+	}
+	return clientConfig.ClientConfig()
+}
+
+// DefaultNamespace returns the namespace of the kubeconfig context, or of the
+// service account when running inside a Pod; "default" when neither sets one.
+func DefaultNamespace(kubeconfigPath string, contextName string) (string, error) {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(newLoadingRules(kubeconfigPath), overrides)
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return "", fmt.Errorf("error reading the namespace of the context: %v", err)
+	}
+	return namespace, nil
+}
+
+// ListContexts returns the context names defined in the kubeconfig along with
+// the name of the current context.
+func ListContexts(kubeconfigPath string) ([]string, string, error) {
+	rawConfig, err := newLoadingRules(kubeconfigPath).Load()
+	if err != nil {
+		return nil, "", fmt.Errorf("error loading kubeconfig: %v", err)
+	}
+
+	contexts := make([]string, 0, len(rawConfig.Contexts))
+	for name := range rawConfig.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+	return contexts, rawConfig.CurrentContext, nil
+}
+
+// PrintContexts prints the kubeconfig contexts, marking the current one with '*'.
+func PrintContexts(kubeconfigPath string) error {
+	contexts, current, err := ListContexts(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range contexts {
+		marker := " "
+		if name == current {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, name)
+	}
+	return nil
+}
+
+func newLoadingRules(kubeconfigPath string) *clientcmd.ClientConfigLoadingRules {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfigPath
+	return loadingRules
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the Pod the process runs
+// in, when it runs in a cluster.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElectionOptions configures Lease-based leader election for the
+// long-running modes, so only one of several replicas acts at a time.
+type leaderElectionOptions struct {
+	enabled       bool
+	namespace     string
+	id            string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+func (l *leaderElectionOptions) addFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&l.enabled, "leader-elect", false, "use a coordination.k8s.io Lease so only one replica acts at a time")
+	flags.StringVar(&l.namespace, "leader-election-namespace", "", "namespace of the leader election Lease (defaults to the Pod's namespace, then default)")
+	flags.StringVar(&l.id, "leader-election-id", "restarter", "name of the leader election Lease")
+	flags.DurationVar(&l.leaseDuration, "leader-election-lease-duration", 15*time.Second, "how long a leader holds the Lease without renewing it")
+	flags.DurationVar(&l.renewDeadline, "leader-election-renew-deadline", 10*time.Second, "how long the leader keeps trying to renew the Lease before giving up")
+	flags.DurationVar(&l.retryPeriod, "leader-election-retry-period", 2*time.Second, "how often candidates try to acquire or renew the Lease")
+}
+
+// leaseNamespace returns the namespace for the Lease.
+func (l *leaderElectionOptions) leaseNamespace() string {
+	if l.namespace != "" {
+		return l.namespace
+	}
+	return ownNamespace()
+}
+
+// ownNamespace returns the namespace of the Pod the process runs in, or
+// default outside a cluster.
+func ownNamespace() string {
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return metav1.NamespaceDefault
+}
+
+// run calls fn directly when leader election is disabled. Otherwise it waits
+// until this process holds the Lease and calls fn with a context that is
+// cancelled if the Lease is lost. Losing the Lease before ctx is done is an
+// error, so the process exits and a restart rejoins the election.
+func (l *leaderElectionOptions) run(ctx context.Context, client kubernetes.Interface, fn func(ctx context.Context) error) error {
+	if !l.enabled {
+		return fn(ctx)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error getting the hostname: %v", err)
+	}
+	identity := hostname + "_" + string(uuid.NewUUID())
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: l.leaseNamespace(), Name: l.id},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	// The elector runs the callback on its own goroutine; hand its context
+	// over so fn runs here and its error can be returned.
+	leading := make(chan context.Context, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   l.leaseDuration,
+		RenewDeadline:   l.renewDeadline,
+		RetryPeriod:     l.retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            l.id,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				infof("Acquired Lease %s/%s as %s\n", lock.LeaseMeta.Namespace, l.id, identity)
+				leading <- ctx
+			},
+			OnStoppedLeading: func() {
+				infof("Released Lease %s/%s\n", lock.LeaseMeta.Namespace, l.id)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					infof("Waiting for Lease %s/%s, currently held by %s\n", lock.LeaseMeta.Namespace, l.id, leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return configError("invalid leader election settings: %v", err)
+	}
+
+	electionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	elected := make(chan struct{})
+	go func() {
+		defer close(elected)
+		elector.Run(electionCtx)
+	}()
+
+	select {
+	case <-elected:
+		// The elector only gives up early when ctx ends, or when the Lease
+		// was lost right after it was acquired.
+		if ctx.Err() == nil {
+			return fmt.Errorf("lost Lease %s/%s", lock.LeaseMeta.Namespace, l.id)
+		}
+		return nil
+	case leaderCtx := <-leading:
+		err := fn(leaderCtx)
+		lost := leaderCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		<-elected
+		if err != nil {
+			return err
+		}
+		if lost {
+			return fmt.Errorf("lost Lease %s/%s", lock.LeaseMeta.Namespace, l.id)
+		}
+		return nil
+	}
+}
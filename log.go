@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	v1 "k8s.io/api/core/v1"
+)
+
+// LogLevel orders log messages by severity.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = map[string]LogLevel{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+// ParseLogLevel converts a --log-level value.
+func ParseLogLevel(name string) (LogLevel, error) {
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		return LevelInfo, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// Log formats.
+const (
+	// LogFormatText writes plain messages, one per line.
+	LogFormatText = "text"
+	// LogFormatJSON writes one JSON object per message, with the level, a
+	// timestamp and structured fields such as namespace, pod, deployment and
+	// action, for Loki, Elasticsearch and the like.
+	LogFormatJSON = "json"
+)
+
+// ValidateLogFormat rejects unknown --log-format values.
+func ValidateLogFormat(format string) error {
+	switch format {
+	case LogFormatText, LogFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q (want %s or %s)", format, LogFormatText, LogFormatJSON)
+}
+
+var (
+	// logOutput receives progress messages. It moves to stderr when stdout is
+	// reserved for machine-readable output.
+	logOutput io.Writer = os.Stdout
+	// logLevel is the lowest level that is written.
+	logLevel = LevelInfo
+	// jsonLog writes the messages in LogFormatJSON; nil means LogFormatText.
+	jsonLog *zap.Logger
+)
+
+var zapLevels = map[LogLevel]zapcore.Level{
+	LevelDebug: zapcore.DebugLevel,
+	LevelInfo:  zapcore.InfoLevel,
+	LevelWarn:  zapcore.WarnLevel,
+	LevelError: zapcore.ErrorLevel,
+}
+
+// setLogFormat switches between LogFormatText and LogFormatJSON. Either
+// writes to logOutput, wherever it points at the time.
+func setLogFormat(format string) {
+	if format != LogFormatJSON {
+		jsonLog = nil
+		return
+	}
+	config := zap.NewProductionEncoderConfig()
+	config.TimeKey, config.EncodeTime = "ts", zapcore.ISO8601TimeEncoder
+	jsonLog = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(config), zapcore.Lock(zapcore.AddSync(logWriter{})), zapcore.DebugLevel))
+}
+
+// logWriter forwards to the current logOutput.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) { return logOutput.Write(p) }
+
+// logAt writes a message with the given fields, alternating keys and values.
+// Text logs leave the fields out: their messages already say what they are
+// about.
+func logAt(level LogLevel, fields []interface{}, format string, args ...interface{}) {
+	if level < logLevel {
+		return
+	}
+	if jsonLog == nil {
+		fmt.Fprint(logOutput, fieldsPrefix(fields)+fmt.Sprintf(format, args...))
+		return
+	}
+	entry := jsonLog.Check(zapLevels[level], strings.TrimSpace(fmt.Sprintf(format, args...)))
+	if entry == nil {
+		return
+	}
+	zapFields := make([]zap.Field, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		zapFields = append(zapFields, zap.Any(fmt.Sprint(fields[i]), fields[i+1]))
+	}
+	entry.Write(zapFields...)
+}
+
+func debugf(format string, args ...interface{}) { logAt(LevelDebug, nil, format, args...) }
+func infof(format string, args ...interface{})  { logAt(LevelInfo, nil, format, args...) }
+func warnf(format string, args ...interface{})  { logAt(LevelWarn, nil, format, args...) }
+func errorf(format string, args ...interface{}) { logAt(LevelError, nil, format, args...) }
+
+// fieldsPrefix returns the text-format prefix of messages with a cluster
+// field, so the messages of parallel runs against several clusters can be
+// told apart.
+func fieldsPrefix(fields []interface{}) string {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "cluster" {
+			return fmt.Sprintf("[%v] ", fields[i+1])
+		}
+	}
+	return ""
+}
+
+// logFields are structured fields, alternating keys and values, attached to
+// the messages logged through them.
+type logFields []interface{}
+
+// withFields starts a set of log fields.
+func withFields(keysAndValues ...interface{}) logFields {
+	return logFields(keysAndValues)
+}
+
+// with returns the fields extended by more keys and values.
+func (f logFields) with(keysAndValues ...interface{}) logFields {
+	return append(append(logFields{}, f...), keysAndValues...)
+}
+
+func (f logFields) debugf(format string, args ...interface{}) { logAt(LevelDebug, f, format, args...) }
+func (f logFields) infof(format string, args ...interface{})  { logAt(LevelInfo, f, format, args...) }
+func (f logFields) warnf(format string, args ...interface{})  { logAt(LevelWarn, f, format, args...) }
+func (f logFields) errorf(format string, args ...interface{}) { logAt(LevelError, f, format, args...) }
+
+// workloadLog returns the log fields of an action on a workload, keyed by its
+// lowercased kind, e.g. namespace=shop deployment=web action=restart, after
+// those of ctx.
+func workloadLog(ctx context.Context, workload *Workload, action string) logFields {
+	return logFor(ctx).with("namespace", workload.Namespace, strings.ToLower(workload.Kind), workload.Name, "action", action)
+}
+
+// podLog returns the log fields of a pod, after those of ctx.
+func podLog(ctx context.Context, pod *v1.Pod) logFields {
+	return logFor(ctx).with("namespace", pod.Namespace, "pod", pod.Name)
+}
+
+// debugRoundTripper logs every API request at debug level.
+type debugRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (rt debugRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		debugf("API %s %s failed after %s: %v\n", req.Method, req.URL, time.Since(start).Round(time.Millisecond), err)
+		return resp, err
+	}
+	debugf("API %s %s %d in %s\n", req.Method, req.URL, resp.StatusCode, time.Since(start).Round(time.Millisecond))
+	return resp, nil
+}
+
+// logSink adapts the leveled functions above to logr, so libraries that log
+// through logr (controller-runtime) share the restarter's output and level.
+// logr verbosity 0 maps to info and anything above to debug.
+type logSink struct {
+	name   string
+	values []interface{}
+}
+
+func (s logSink) Init(logr.RuntimeInfo) {}
+
+func (s logSink) Enabled(level int) bool {
+	if level > 0 {
+		return logLevel <= LevelDebug
+	}
+	return logLevel <= LevelInfo
+}
+
+func (s logSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	if level > 0 {
+		s.log(LevelDebug, msg, keysAndValues)
+		return
+	}
+	s.log(LevelInfo, msg, keysAndValues)
+}
+
+func (s logSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	if jsonLog == nil {
+		errorf("%s: %v\n", s.format(msg, keysAndValues), err)
+		return
+	}
+	s.log(LevelError, msg, append(append([]interface{}{}, keysAndValues...), "error", err.Error()))
+}
+
+// log writes the values inline in text logs and as fields in JSON logs.
+func (s logSink) log(level LogLevel, msg string, keysAndValues []interface{}) {
+	if jsonLog == nil {
+		logAt(level, nil, "%s\n", s.format(msg, keysAndValues))
+		return
+	}
+	fields := append(append([]interface{}{}, s.values...), keysAndValues...)
+	if s.name != "" {
+		fields = append(fields, "logger", s.name)
+	}
+	logAt(level, fields, "%s\n", msg)
+}
+
+func (s logSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	s.values = append(append([]interface{}{}, s.values...), keysAndValues...)
+	return s
+}
+
+func (s logSink) WithName(name string) logr.LogSink {
+	if s.name != "" {
+		name = s.name + "." + name
+	}
+	s.name = name
+	return s
+}
+
+func (s logSink) format(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	if s.name != "" {
+		b.WriteString(s.name + ": ")
+	}
+	b.WriteString(msg)
+	all := append(append([]interface{}{}, s.values...), keysAndValues...)
+	for i := 0; i+1 < len(all); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", all[i], all[i+1])
+	}
+	return b.String()
+}
@@ -2,116 +2,856 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
-	v1 "k8s.io/api/apps/v1"
-	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
 )
 
 func main() {
-	userHomeDir, err := os.UserHomeDir()
+	err := newRootCommand().Execute()
+	shutdownTracing()
 	if err != nil {
-		fmt.Printf("error getting user home dir: %v\n", err)
-		os.Exit(1)
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) {
+			exitErr = &ExitError{Code: ExitConfigError, Err: err}
+		}
+		if exitErr.Err != nil {
+			errorf("Error: %v\n", exitErr.Err)
+		}
+		os.Exit(exitErr.Code)
 	}
-	kubeConfigPath := filepath.Join(userHomeDir, ".kube", "config")
-	fmt.Printf("Using kubeconfig: %s\n", kubeConfigPath)
+}
 
-	kubeConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
-	if err != nil {
-		fmt.Printf("Error getting Kubernetes config: %v\n", err)
-		os.Exit(1)
+// globalOptions holds the flags shared by every subcommand.
+type globalOptions struct {
+	kubeconfig          string
+	context             string
+	contexts            []string
+	fleetPath           string
+	fleet               *Fleet
+	qps                 float32
+	burst               int
+	contentType         string
+	configPath          string
+	namespaces          []string
+	excludeNamespaces   []string
+	denyNamespaces      []string
+	denyWorkloads       []string
+	denyList            *DenyList
+	scope               string
+	podSelector         string
+	fieldSelector       string
+	matchRegex          []string
+	matchers            []string
+	expression          string
+	workloadExpression  string
+	release             string
+	onlyUnhealthy       bool
+	oomKills            int32
+	oomWindow           time.Duration
+	restartCount        int32
+	restartWindow       time.Duration
+	eventTriggers       []string
+	preRestartHooks     []string
+	postRestartHooks    []string
+	imageDrift          bool
+	registryConfig      string
+	registry            *DigestResolver
+	olderThan           durationFlag
+	optIn               bool
+	cooldown            time.Duration
+	recordEvents        bool
+	concurrency         int
+	scanConcurrency     int
+	clusterWideList     bool
+	preflight           bool
+	maxRestarts         int
+	namespaceLimit      string
+	namespaceLimiter    *NamespaceLimiter
+	windowSpecs         []string
+	windows             []MaintenanceWindow
+	outsideWindow       string
+	timeout             time.Duration
+	output              string
+	logLevel            string
+	logFormat           string
+	otlpEndpoint        string
+	otlpInsecure        bool
+	slackWebhookURL     string
+	slackChannel        string
+	slackTemplate       string
+	alertTemplate       string
+	restartAnnotations  []string
+	annotations         map[string]*template.Template
+	pagerDutyRoutingKey string
+	pagerDutySeverity   string
+	opsgenieAPIKey      string
+	opsgenieURL         string
+	opsgeniePriority    string
+	webhookURL          string
+	webhookSecret       string
+	webhookTimeout      time.Duration
+	webhookRetries      int
+	auditLogPath        string
+	pushgatewayURL      string
+	pushgatewayJob      string
+	pprofAddr           string
+	historyConfigMap    string
+	history             *History
+	freezeConfigMap     string
+	opaURL              string
+	opaTimeout          time.Duration
+	policy              *OPAPolicy
+	argoCDMode          string
+	argoCDServer        string
+	argoCDTokenFile     string
+	argoCD              *ArgoCD
+	freeze              *FreezeConfigMap
+	audit               *AuditLog
+	notifiers           []Notifier
+	quiet               bool
+}
+
+func newRootCommand() *cobra.Command {
+	opts := &globalOptions{}
+	cmd := &cobra.Command{
+		Use:   "restarter",
+		Short: "Rollout-restart the workloads that own matching pods",
+		Long: `restarter finds pods by name pattern or selector and performs a graceful
+rollout restart of the workloads that own them, like kubectl rollout restart.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.complete(cmd)
+		},
 	}
 
-	clientset, err := kubernetes.NewForConfig(kubeConfig)
-	if err != nil {
-		fmt.Printf("Error creating Kubernetes client: %v\n", err)
-		os.Exit(1)
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to the kubeconfig file (defaults to $KUBECONFIG, then ~/.kube/config)")
+	flags.StringVar(&opts.context, "context", "", "kubeconfig context to use (defaults to the current context)")
+	flags.StringSliceVar(&opts.contexts, "contexts", nil, "kubeconfig contexts to run list, restart, pause and resume against in parallel, one cluster each; repeatable or comma-separated (replaces --context)")
+	flags.StringVar(&opts.fleetPath, "fleet", "", "YAML inventory of the clusters to run list, restart, pause and resume against in parallel, with per-cluster kubeconfig, context, namespaces and rules")
+	flags.StringVar(&opts.configPath, "config", "", "YAML file with matching rules; replaces the pod selection flags")
+	flags.StringSliceVar(&opts.namespaces, "namespace", nil, "namespace to process; repeatable or comma-separated (defaults to all namespaces)")
+	flags.StringSliceVar(&opts.excludeNamespaces, "exclude-namespaces", DefaultExcludedNamespaces, "namespaces that are never processed; repeatable or comma-separated")
+	flags.StringSliceVar(&opts.denyNamespaces, "deny-namespaces", nil, "namespace patterns, e.g. kube-*, whose workloads are never restarted, paused or deleted, whatever the rules match; repeatable or comma-separated")
+	flags.StringSliceVar(&opts.denyWorkloads, "deny-workloads", nil, "NAMESPACE/KIND/NAME patterns, e.g. */StatefulSet/etcd-*, of workloads that are never restarted, paused or deleted; repeatable or comma-separated")
+	flags.StringVar(&opts.scope, "scope", ScopeCluster, "RBAC scope to run with: cluster, or namespace to only touch the namespaces given by --namespace (defaults to the context's namespace) and never read Namespace objects")
+	flags.StringVar(&opts.podSelector, "pod-selector", "", "label selector for pods to restart, e.g. app.kubernetes.io/component=database (replaces name matching)")
+	flags.StringVar(&opts.fieldSelector, "field-selector", "", "field selector for pods, e.g. status.phase=Running")
+	flags.StringVar(&opts.expression, "expression", "", "CEL expression over the pod that must hold for it to match, e.g. \"pod.status.containerStatuses.exists(c, c.restartCount > 5)\" (replaces name matching)")
+	flags.StringVar(&opts.release, "release", "", "only act on the workloads of this Helm release, whose pods carry the "+LabelInstance+" label")
+	flags.StringVar(&opts.workloadExpression, "workload-expression", "", "CEL expression over the workload that must hold for it to be acted on, e.g. \"workload.spec.replicas > 1\"")
+	flags.StringSliceVar(&opts.matchRegex, "match-regex", nil, "pod name regular expression; repeatable, a pod matching any pattern is selected")
+	flags.StringArrayVar(&opts.matchers, "matcher", nil, "pod matcher as TYPE:VALUE, e.g. substring:db, regex:^db-, label:tier=data or cel:EXPR; repeatable, every matcher must match")
+	flags.BoolVar(&opts.onlyUnhealthy, "only-unhealthy", false, "only act on pods in CrashLoopBackOff or ImagePullBackOff, or not Ready")
+	flags.Int32Var(&opts.oomKills, "oom-kills", 0, "act on pods with a container OOM-killed within --oom-window that restarted at least this many times (0 disables it)")
+	flags.DurationVar(&opts.oomWindow, "oom-window", DefaultOOMWindow, "how recent an OOM kill must be for --oom-kills")
+	flags.Int32Var(&opts.restartCount, "restart-count", 0, "act on pods with a container that restarted at least this many times, the last time within --restart-window (0 disables it)")
+	flags.DurationVar(&opts.restartWindow, "restart-window", DefaultRestartWindow, "how recent the last container restart must be for --restart-count (0 disables the check)")
+	flags.StringArrayVar(&opts.eventTriggers, "event-trigger", nil, "act on pods with Kubernetes Events of this reason, as REASON[:COUNT[:WINDOW]], e.g. FailedMount:3:30m; repeatable")
+	flags.StringArrayVar(&opts.preRestartHooks, "pre-restart-hook", nil, "shell command run before each restart; a failing one aborts the restart; repeatable")
+	flags.StringArrayVar(&opts.postRestartHooks, "post-restart-hook", nil, "shell command run after each successful restart; failures are logged; repeatable")
+	flags.BoolVar(&opts.imageDrift, "image-drift", false, "act on pods whose image tag now points to another digest in the registry than the one they run")
+	flags.StringVar(&opts.registryConfig, "registry-config", "", "Docker config file with registry credentials for --image-drift, used when a pod's image pull secrets have none")
+	flags.Var(&opts.olderThan, "older-than", "only act on pods running at least this long, e.g. 30d (0 disables it)")
+	flags.BoolVar(&opts.optIn, "opt-in", false, "only restart workloads (or namespaces) annotated "+AnnotationEnabled+"=true")
+	flags.DurationVar(&opts.cooldown, "cooldown", 0, "skip workloads restarted less than this long ago, e.g. 30m (0 disables it)")
+	flags.BoolVar(&opts.recordEvents, "record-events", true, "record an "+EventReasonRestart+" Event on every restarted workload, shown by kubectl describe")
+	flags.IntVar(&opts.concurrency, "concurrency", 1, "how many workloads to process in parallel")
+	flags.IntVar(&opts.scanConcurrency, "scan-concurrency", 8, "how many namespaces to list and evaluate in parallel")
+	flags.BoolVar(&opts.preflight, "preflight", true, "check with SelfSubjectAccessReviews that every permission the run needs is granted before acting")
+	flags.BoolVar(&opts.clusterWideList, "cluster-wide-list", false, "list the pods of all namespaces in a single paginated call instead of one call per namespace")
+	flags.IntVar(&opts.maxRestarts, "max-restarts", 0, "stop restarting after this many workloads in a run and only report the rest (0 disables it)")
+	flags.StringVar(&opts.namespaceLimit, "namespace-rate-limit", "", "at most this many restarts per namespace in a time window, as N/DURATION, e.g. 5/1h (disabled by default)")
+	flags.StringArrayVar(&opts.restartAnnotations, "restart-annotation", nil, "annotation written to every restarted workload, as KEY=TEMPLATE with a Go template rendered with the result and its run, e.g. \"example.com/restarted-by={{.Actor}}\"; repeatable")
+	flags.StringArrayVar(&opts.windowSpecs, "maintenance-window", nil, "weekly window in which restarts are allowed, e.g. \"Sat 02:00-04:00 UTC\"; repeatable")
+	flags.StringVar(&opts.outsideWindow, "outside-window", OutsideWindowSkip, "what to do with restarts outside the maintenance windows: skip or wait")
+	flags.DurationVar(&opts.timeout, "timeout", 0, "overall deadline for the run, e.g. 10m (0 disables it)")
+	flags.DurationVar(&requestTimeout, "request-timeout", requestTimeout, "deadline for each individual API call")
+	flags.Float32Var(&opts.qps, "kube-api-qps", DefaultQPS, "maximum sustained queries per second to the Kubernetes API server, per cluster")
+	flags.IntVar(&opts.burst, "kube-api-burst", DefaultBurst, "maximum burst of queries to the Kubernetes API server above --kube-api-qps, per cluster")
+	flags.StringVar(&opts.contentType, "kube-api-content-type", ContentTypeProtobuf, "encoding of Kubernetes API requests and responses: protobuf, or json for API servers or proxies that do not support it")
+	flags.IntVar(&apiRetries, "kube-api-retries", apiRetries, "how many times to repeat an API call failing with 429, a 5xx status, a timeout or a broken connection, with exponential backoff from 500ms (0 disables retries)")
+	flags.Int64Var(&listPageSize, "list-page-size", listPageSize, "how many namespaces, pods or events to ask the API server for per list call (0 lists everything in one call)")
+	flags.StringVarP(&opts.output, "output", "o", OutputText, "output format: text, table, summary (a row per namespace), releases (a row per Helm release), json or yaml")
+	flags.StringVar(&opts.logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	flags.StringVar(&opts.logFormat, "log-format", LogFormatText, "log format: text, or json for one object per message with fields such as namespace, pod, deployment and action")
+	flags.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "OTLP/gRPC endpoint to export traces of scans, restarts and rollout waits to, e.g. otel-collector:4317 (empty disables tracing)")
+	flags.BoolVar(&opts.otlpInsecure, "otlp-insecure", false, "export traces without TLS")
+	flags.StringVar(&opts.slackWebhookURL, "slack-webhook-url", "", "Slack incoming webhook URL to post a summary of every run that matched something to (empty disables it)")
+	flags.StringVar(&opts.slackChannel, "slack-channel", "", "Slack channel to post to instead of the webhook's default, e.g. #ops")
+	flags.StringVar(&opts.slackTemplate, "slack-template", "", "Go template of the Slack message, rendered with the run report (defaults to a summary line and the restarted and failed workloads)")
+	flags.StringVar(&opts.alertTemplate, "alert-template", "", "Go template of the PagerDuty and Opsgenie alert titles, rendered with the failed result and its run (defaults to the workload and the error)")
+	flags.StringVar(&opts.pagerDutyRoutingKey, "pagerduty-routing-key", "", "PagerDuty Events API v2 routing key to trigger an incident with for every failed restart or rollout (empty disables it)")
+	flags.StringVar(&opts.pagerDutySeverity, "pagerduty-severity", PagerDutyError, "severity of PagerDuty incidents: critical, error, warning or info")
+	flags.StringVar(&opts.opsgenieAPIKey, "opsgenie-api-key", "", "Opsgenie API key to create an alert with for every failed restart or rollout (empty disables it)")
+	flags.StringVar(&opts.opsgenieURL, "opsgenie-url", DefaultOpsgenieURL, "Opsgenie Alert API URL, e.g. https://api.eu.opsgenie.com/v2/alerts for the EU instance")
+	flags.StringVar(&opts.opsgeniePriority, "opsgenie-priority", "P3", "priority of Opsgenie alerts: P1 to P5")
+	flags.StringVar(&opts.webhookURL, "notify-webhook-url", "", "URL to post a JSON event to for every workload restarted, or failed to restart (empty disables it)")
+	flags.StringVar(&opts.webhookSecret, "notify-webhook-secret", "", "secret to sign --notify-webhook-url requests with, in the "+SignatureHeader+" header as an HMAC-SHA256")
+	flags.DurationVar(&opts.webhookTimeout, "notify-webhook-timeout", notifyTimeout, "deadline for each --notify-webhook-url request")
+	flags.IntVar(&opts.webhookRetries, "notify-webhook-retries", 3, "how often to retry a --notify-webhook-url request after a network error, 429 or 5xx response")
+	flags.StringVar(&opts.auditLogPath, "audit-log", "", "file to append a JSON line to for every restart, eviction, pause, resume and orphan deletion, with who did it and the outcome")
+	flags.StringVar(&opts.pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push the metrics of list, restart, pause and resume runs to before exiting, e.g. http://pushgateway:9091 (empty disables it)")
+	flags.StringVar(&opts.pushgatewayJob, "pushgateway-job", "restarter", "job label to push the metrics under; each run replaces the metrics of the previous one")
+	flags.StringVar(&opts.historyConfigMap, "history-configmap", "", "ConfigMap, as NAMESPACE/NAME or NAME in the Pod's namespace, to record the last restart of every workload in, for cooldowns and the history command (empty disables it)")
+	flags.StringVar(&opts.freezeConfigMap, "freeze-configmap", "", "ConfigMap, as NAMESPACE/NAME or NAME in the Pod's namespace, whose entries declare change freezes during which nothing is restarted, read at most once a minute (empty disables it)")
+	flags.StringVar(&opts.opaURL, "opa-url", "", "Open Policy Agent Data API URL of the policy that must allow every restart, e.g. http://localhost:8181/v1/data/restarter/allow (empty disables it)")
+	flags.DurationVar(&opts.opaTimeout, "opa-timeout", 5*time.Second, "deadline for each --opa-url query")
+	flags.StringVar(&opts.argoCDMode, "argocd", ArgoCDRestart, "what to do with workloads managed by Argo CD: restart, or skip to leave them to Argo CD")
+	flags.StringVar(&opts.argoCDServer, "argocd-server", "", "Argo CD API server URL, e.g. https://argocd.example.com, to sync the Application of every restarted Argo CD managed workload (empty disables it)")
+	flags.StringVar(&opts.argoCDTokenFile, "argocd-token-file", "", "file holding the bearer token for --argocd-server")
+	flags.StringVar(&opts.pprofAddr, "pprof-addr", "", "address to serve Go profiles on at /debug/pprof/, e.g. localhost:6060 (empty disables it)")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "only log errors (same as --log-level=error)")
+
+	registerCompletions(cmd, opts)
+
+	cmd.AddCommand(
+		newListCommand(opts),
+		newRestartCommand(opts),
+		newWatchCommand(opts),
+		newPauseCommand(opts),
+		newResumeCommand(opts),
+		newUndoCommand(opts),
+		newOperatorCommand(opts),
+		newAlertmanagerCommand(opts),
+		newServeCommand(opts),
+		newAdmissionCommand(opts),
+		newReloadCommand(opts),
+		newDrainCommand(opts),
+		newHistoryCommand(opts),
+		newContextsCommand(opts),
+		newVersionCommand(opts),
+	)
+	return cmd
+}
+
+// complete applies environment variables and validates the shared flags.
+func (o *globalOptions) complete(cmd *cobra.Command) error {
+	if err := applyEnv(cmd.Flags()); err != nil {
+		return configError("invalid environment: %v", err)
 	}
 
-	// List all namespaces
-	namespaces, err := ListNamespaces(clientset)
+	level, err := ParseLogLevel(o.logLevel)
 	if err != nil {
-		fmt.Printf("Error listing namespaces: %v\n", err)
-		os.Exit(1)
+		return configError("invalid --log-level: %v", err)
+	}
+	if o.quiet {
+		level = LevelError
+	}
+	logLevel = level
+	if err := ValidateLogFormat(o.logFormat); err != nil {
+		return configError("invalid --log-format: %v", err)
+	}
+	setLogFormat(o.logFormat)
+	if o.otlpEndpoint != "" {
+		if err := setupTracing(o.otlpEndpoint, o.otlpInsecure); err != nil {
+			return configError("invalid --otlp-endpoint: %v", err)
+		}
 	}
 
-	for _, namespace := range namespaces.Items {
-		fmt.Printf("Processing namespace: %s\n", namespace.Name)
-		pods, err := ListPods(namespace.Name, clientset)
+	if o.pprofAddr != "" {
+		if err := startPprof(o.pprofAddr); err != nil {
+			return configError("invalid --pprof-addr: %v", err)
+		}
+	}
+
+	if err := ValidateScope(o.scope); err != nil {
+		return configError("invalid --scope: %v", err)
+	}
+	if len(o.contexts) > 0 {
+		if o.context != "" {
+			return configError("--context and --contexts are mutually exclusive")
+		}
+		seen := map[string]bool{}
+		for _, name := range o.contexts {
+			if name == "" || seen[name] {
+				return configError("invalid --contexts: empty or repeated context %q", name)
+			}
+			seen[name] = true
+		}
+		if o.scope == ScopeNamespace && len(o.namespaces) == 0 {
+			return configError("--scope=%s with --contexts requires --namespace", ScopeNamespace)
+		}
+	}
+	o.fleet = nil
+	if o.fleetPath != "" {
+		if o.context != "" || len(o.contexts) > 0 {
+			return configError("--fleet is mutually exclusive with --context and --contexts")
+		}
+		if o.fleet, err = LoadFleet(o.fleetPath); err != nil {
+			return configError("invalid --fleet: %v", err)
+		}
+		for _, cluster := range o.fleet.Clusters {
+			if o.scope == ScopeNamespace && len(o.namespaces) == 0 && len(cluster.Namespaces) == 0 {
+				return configError("--scope=%s with --fleet requires --namespace or namespaces for cluster %s", ScopeNamespace, cluster.Name)
+			}
+		}
+	}
+	if o.scope == ScopeNamespace && len(o.namespaces) == 0 {
+		namespace, err := DefaultNamespace(o.kubeconfig, o.context)
 		if err != nil {
-			fmt.Printf("Error listing pods in namespace %s: %v\n", namespace.Name, err)
-			continue
+			return configError("--scope=%s: %v", ScopeNamespace, err)
 		}
+		infof("Using namespace: %s\n", namespace)
+		o.namespaces = []string{namespace}
+	}
 
-		for _, pod := range pods.Items {
-			if strings.Contains(pod.Name, "database") {
-				fmt.Printf("Pod with 'database' found: %s\n", pod.Name)
-				if err := RestartDeployment(pod.Namespace, pod.Name, clientset); err != nil {
-					fmt.Printf("Error restarting deployment for pod %s: %v\n", pod.Name, err)
-				}
+	if o.concurrency < 1 {
+		return configError("invalid --concurrency: must be at least 1")
+	}
+	if o.scanConcurrency < 1 {
+		return configError("invalid --scan-concurrency: must be at least 1")
+	}
+	if o.clusterWideList && o.scope == ScopeNamespace {
+		return configError("--cluster-wide-list cannot be used with --scope=%s", ScopeNamespace)
+	}
+	if o.maxRestarts < 0 {
+		return configError("invalid --max-restarts: must not be negative")
+	}
+	o.namespaceLimiter = nil
+	if o.namespaceLimit != "" {
+		if o.namespaceLimiter, err = ParseNamespaceLimiter(o.namespaceLimit); err != nil {
+			return configError("invalid --namespace-rate-limit: %v", err)
+		}
+	}
+	if o.annotations, err = ParseRestartAnnotations(o.restartAnnotations); err != nil {
+		return configError("invalid --restart-annotation: %v", err)
+	}
+	if o.qps <= 0 {
+		return configError("invalid --kube-api-qps: must be positive")
+	}
+	if o.burst < 1 {
+		return configError("invalid --kube-api-burst: must be at least 1")
+	}
+	if err := ValidateContentType(o.contentType); err != nil {
+		return configError("invalid --kube-api-content-type: %v", err)
+	}
+	if apiRetries < 0 {
+		return configError("invalid --kube-api-retries: must not be negative")
+	}
+	if listPageSize < 0 {
+		return configError("invalid --list-page-size: must not be negative")
+	}
+	o.windows = nil
+	for _, spec := range o.windowSpecs {
+		window, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			return configError("invalid --maintenance-window: %v", err)
+		}
+		o.windows = append(o.windows, window)
+	}
+	if err := ValidateOutsideWindow(o.outsideWindow); err != nil {
+		return configError("invalid --outside-window: %v", err)
+	}
+	registryConfig := DockerConfig{}
+	if o.registryConfig != "" {
+		if registryConfig, err = LoadDockerConfig(o.registryConfig); err != nil {
+			return configError("invalid --registry-config: %v", err)
+		}
+	}
+	o.registry = NewDigestResolver(registryConfig)
+	if o.auditLogPath != "" {
+		if o.audit, err = OpenAuditLog(o.auditLogPath); err != nil {
+			return configError("invalid --audit-log: %v", err)
+		}
+	}
+	if o.denyList, err = o.denyListFor(o.configPath); err != nil {
+		return err
+	}
+	o.history = nil
+	if o.historyConfigMap != "" {
+		if o.history, err = ParseHistoryConfigMap(o.historyConfigMap); err != nil {
+			return configError("invalid --history-configmap: %v", err)
+		}
+	}
+	o.policy = nil
+	if o.opaURL != "" {
+		if err := validateHTTPURL(o.opaURL); err != nil {
+			return configError("invalid --opa-url: %v", err)
+		}
+		if o.opaTimeout <= 0 {
+			return configError("invalid --opa-timeout: must be positive")
+		}
+		o.policy = &OPAPolicy{URL: o.opaURL, HTTP: &http.Client{Timeout: o.opaTimeout}}
+	}
+	o.argoCD = nil
+	if err := ValidateArgoCDMode(o.argoCDMode); err != nil {
+		return configError("invalid --argocd: %v", err)
+	}
+	if o.argoCDTokenFile != "" && o.argoCDServer == "" {
+		return configError("--argocd-token-file requires --argocd-server")
+	}
+	if o.argoCDMode != ArgoCDRestart || o.argoCDServer != "" {
+		o.argoCD = &ArgoCD{Mode: o.argoCDMode, Server: o.argoCDServer, HTTP: &http.Client{Timeout: argoCDTimeout}}
+	}
+	if o.argoCDServer != "" {
+		if err := validateHTTPURL(o.argoCDServer); err != nil {
+			return configError("invalid --argocd-server: %v", err)
+		}
+		if o.argoCDTokenFile != "" {
+			data, err := os.ReadFile(o.argoCDTokenFile)
+			if err != nil {
+				return configError("invalid --argocd-token-file: %v", err)
 			}
+			o.argoCD.Token = strings.TrimSpace(string(data))
+		}
+	}
+	o.freeze = nil
+	if o.freezeConfigMap != "" {
+		if o.freeze, err = ParseFreezeConfigMap(o.freezeConfigMap); err != nil {
+			return configError("invalid --freeze-configmap: %v", err)
+		}
+	}
+	if o.pushgatewayURL != "" {
+		if err := validateHTTPURL(o.pushgatewayURL); err != nil {
+			return configError("invalid --pushgateway-url: %v", err)
+		}
+		if o.pushgatewayJob == "" {
+			return configError("invalid --pushgateway-job: must not be empty")
 		}
 	}
+	if o.notifiers, err = o.buildNotifiers(); err != nil {
+		return err
+	}
+	if err := ValidateOutputFormat(o.output); err != nil {
+		return configError("invalid --output: %v", err)
+	}
+	if IsMachineReadable(o.output) {
+		logOutput = os.Stderr
+	}
+	return nil
 }
 
-func ListPods(namespace string, client kubernetes.Interface) (*v1.PodList, error) {
-	fmt.Printf("Listing pods in namespace %s\n", namespace)
-	pods, err := client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("error getting pods: %v", err)
+// buildNotifiers validates the notification flags and returns the notifiers
+// they enable.
+func (o *globalOptions) buildNotifiers() ([]Notifier, error) {
+	var notifiers []Notifier
+	httpClient := &http.Client{Timeout: notifyTimeout}
+	var alertTemplate *template.Template
+	if o.alertTemplate != "" {
+		var err error
+		if alertTemplate, err = ParseResultTemplate("alert", o.alertTemplate); err != nil {
+			return nil, configError("invalid --alert-template: %v", err)
+		}
+	}
+	if o.slackWebhookURL != "" {
+		if err := validateHTTPURL(o.slackWebhookURL); err != nil {
+			return nil, configError("invalid --slack-webhook-url: %v", err)
+		}
+		text := o.slackTemplate
+		if text == "" {
+			text = DefaultSlackTemplate
+		}
+		tmpl, err := ParseNotifyTemplate("slack", text)
+		if err != nil {
+			return nil, configError("invalid --slack-template: %v", err)
+		}
+		notifiers = append(notifiers, &SlackNotifier{
+			WebhookURL: o.slackWebhookURL,
+			Channel:    o.slackChannel,
+			Template:   tmpl,
+			HTTP:       httpClient,
+		})
 	}
-	return pods, nil
+	if o.pagerDutyRoutingKey != "" {
+		if err := ValidatePagerDutySeverity(o.pagerDutySeverity); err != nil {
+			return nil, configError("invalid --pagerduty-severity: %v", err)
+		}
+		notifiers = append(notifiers, &PagerDutyNotifier{
+			URL:        DefaultPagerDutyURL,
+			RoutingKey: o.pagerDutyRoutingKey,
+			Severity:   o.pagerDutySeverity,
+			Template:   alertTemplate,
+			HTTP:       httpClient,
+		})
+	}
+	if o.opsgenieAPIKey != "" {
+		if err := validateHTTPURL(o.opsgenieURL); err != nil {
+			return nil, configError("invalid --opsgenie-url: %v", err)
+		}
+		if err := ValidateOpsgeniePriority(o.opsgeniePriority); err != nil {
+			return nil, configError("invalid --opsgenie-priority: %v", err)
+		}
+		notifiers = append(notifiers, &OpsgenieNotifier{
+			URL:      o.opsgenieURL,
+			APIKey:   o.opsgenieAPIKey,
+			Priority: o.opsgeniePriority,
+			Template: alertTemplate,
+			HTTP:     httpClient,
+		})
+	}
+	if o.webhookURL != "" {
+		if err := validateHTTPURL(o.webhookURL); err != nil {
+			return nil, configError("invalid --notify-webhook-url: %v", err)
+		}
+		if o.webhookTimeout <= 0 {
+			return nil, configError("invalid --notify-webhook-timeout: must be positive")
+		}
+		if o.webhookRetries < 0 {
+			return nil, configError("invalid --notify-webhook-retries: must not be negative")
+		}
+		notifiers = append(notifiers, &WebhookNotifier{
+			URL:     o.webhookURL,
+			Secret:  o.webhookSecret,
+			Retries: o.webhookRetries,
+			HTTP:    &http.Client{Timeout: o.webhookTimeout},
+		})
+	}
+	return notifiers, nil
 }
 
-func ListNamespaces(client kubernetes.Interface) (*v1.NamespaceList, error) {
-	fmt.Println("Listing namespaces")
-	namespaces, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+// validateHTTPURL rejects anything but absolute http and https URLs.
+func validateHTTPURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return nil, fmt.Errorf("error getting namespaces: %v", err)
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", rawURL)
 	}
-	return namespaces, nil
+	return nil
 }
 
-func RestartDeployment(namespace string, podName string, client kubernetes.Interface) error {
-	// Find the deployment associated with the pod
-	deploymentClient := client.AppsV1().Deployments(namespace)
-	deployments, err := deploymentClient.List(context.Background(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app=%s", strings.Split(podName, "-")[0]),
-	})
+// rules returns the rules from --config, or a single rule built from the
+// selection flags.
+func (o *globalOptions) rules() ([]Rule, error) {
+	return o.rulesFor(o.configPath, o.namespaces)
+}
+
+// denyListFor returns the deny-list of the --deny-* flags and of the deny
+// section of the rules file at configPath, if any.
+func (o *globalOptions) denyListFor(configPath string) (*DenyList, error) {
+	var denyList *DenyList
+	if len(o.denyNamespaces) > 0 || len(o.denyWorkloads) > 0 {
+		denyList = &DenyList{Namespaces: o.denyNamespaces, Workloads: o.denyWorkloads}
+		if err := denyList.Validate(); err != nil {
+			return nil, configError("invalid --deny-namespaces or --deny-workloads: %v", err)
+		}
+	}
+	if configPath != "" {
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			return nil, configError("invalid config: %v", err)
+		}
+		denyList = denyList.merge(config.Deny)
+	}
+	return denyList, nil
+}
+
+// rulesFor builds the rules from a rules file, or from the selection flags
+// for "", with namespaces in place of --namespace.
+func (o *globalOptions) rulesFor(configPath string, namespaces []string) ([]Rule, error) {
+	if configPath != "" {
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			return nil, configError("invalid config: %v", err)
+		}
+		rules, err := scopeRules(config.Rules, o.scope, namespaces)
+		if err != nil {
+			return nil, configError("invalid config: %v", err)
+		}
+		return rules, nil
+	}
+
+	rule := Rule{
+		Name:               "flags",
+		Namespaces:         namespaces,
+		PodSelector:        o.podSelector,
+		FieldSelector:      o.fieldSelector,
+		Match:              o.matchRegex,
+		Expression:         o.expression,
+		WorkloadExpression: o.workloadExpression,
+		Release:            o.release,
+		OnlyUnhealthy:      o.onlyUnhealthy,
+		OOMKills:           o.oomKills,
+		OOMWindow:          &o.oomWindow,
+		RestartCount:       o.restartCount,
+		RestartWindow:      &o.restartWindow,
+		ImageDrift:         o.imageDrift,
+	}
+	for _, spec := range o.matchers {
+		matcher, err := ParseMatcherRule(spec)
+		if err != nil {
+			return nil, configError("invalid --matcher: %v", err)
+		}
+		rule.Matchers = append(rule.Matchers, matcher)
+	}
+	for _, command := range o.preRestartHooks {
+		rule.PreRestart = append(rule.PreRestart, ShellHook(command))
+	}
+	for _, command := range o.postRestartHooks {
+		rule.PostRestart = append(rule.PostRestart, ShellHook(command))
+	}
+	for _, spec := range o.eventTriggers {
+		event, err := ParseEventRule(spec)
+		if err != nil {
+			return nil, configError("invalid --event-trigger: %v", err)
+		}
+		rule.Events = append(rule.Events, event)
+	}
+	if o.olderThan > 0 {
+		rule.OlderThan = o.olderThan.String()
+	}
+	if field, err := rule.Validate(); err != nil {
+		return nil, configError("invalid %s: %v", ruleFieldFlags[field], err)
+	}
+	return []Rule{rule}, nil
+}
+
+// ruleFieldFlags maps Rule fields to the flags that populate them.
+var ruleFieldFlags = map[string]string{
+	"podSelector":        "--pod-selector",
+	"fieldSelector":      "--field-selector",
+	"match":              "--match-regex",
+	"expression":         "--expression",
+	"matchers":           "--matcher",
+	"workloadExpression": "--workload-expression",
+	"release":            "--release",
+	"oomKills":           "--oom-kills",
+	"restartCount":       "--restart-count",
+	"events":             "--event-trigger",
+	"preRestart":         "--pre-restart-hook",
+	"postRestart":        "--post-restart-hook",
+}
+
+// restConfig builds the client configuration from the kubeconfig flags.
+func (o *globalOptions) restConfig() (*rest.Config, error) {
+	if o.multiCluster() {
+		return nil, configError("--contexts and --fleet are only supported by list, restart, pause and resume")
+	}
+	return o.restConfigFor(o.kubeconfig, o.context, "")
+}
+
+// restConfigFor builds the client configuration of a context of a
+// kubeconfig file, the current one for "". API errors are counted under
+// cluster.
+func (o *globalOptions) restConfigFor(kubeconfig, contextName, cluster string) (*rest.Config, error) {
+	kubeConfig, err := BuildConfig(kubeconfig, contextName)
 	if err != nil {
-		return fmt.Errorf("error listing deployments: %v", err)
+		if cluster != "" {
+			return nil, configError("error getting Kubernetes config of cluster %s: %v", cluster, err)
+		}
+		return nil, configError("error getting Kubernetes config: %v", err)
 	}
+	kubeConfig.QPS, kubeConfig.Burst = o.qps, o.burst
+	kubeConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return metricsRoundTripper{next: rt, cluster: cluster}
+	})
+	if logLevel == LevelDebug {
+		kubeConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return debugRoundTripper{next: rt}
+		})
+	}
+	return kubeConfig, nil
+}
 
-	if len(deployments.Items) == 0 {
-		return fmt.Errorf("no deployments found for pod %s", podName)
+// clientset builds the Kubernetes client from the kubeconfig flags.
+func (o *globalOptions) clientset() (kubernetes.Interface, error) {
+	kubeConfig, err := o.restConfig()
+	if err != nil {
+		return nil, err
 	}
+	return o.newClientset(kubeConfig)
+}
 
-	// Assuming the pod name contains a unique identifier for the deployment
-	deploymentName := strings.Split(podName, "-")[0]
-	fmt.Printf("Restarting deployment: %s\n", deploymentName)
+// clients builds the Kubernetes client from the kubeconfig flags, and sets
+// the metadata client scans list pods with and the configuration of exec
+// hooks in options.
+func (o *globalOptions) clients(options *RunOptions) (kubernetes.Interface, error) {
+	client, err := o.runClient(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.Metadata, err = newMetadataClient(options.RestConfig); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
 
-	deployment, err := deploymentClient.Get(context.Background(), deploymentName, metav1.GetOptions{})
+// runClient builds the Kubernetes client from the kubeconfig flags, and sets
+// the configuration of exec hooks in options.
+func (o *globalOptions) runClient(options *RunOptions) (kubernetes.Interface, error) {
+	kubeConfig, err := o.restConfig()
 	if err != nil {
-		return fmt.Errorf("error getting deployment: %v", err)
+		return nil, err
 	}
+	options.RestConfig = kubeConfig
+	return o.newClientset(kubeConfig)
+}
+
+// multiCluster reports whether the run acts on several clusters, given by
+// --contexts or --fleet.
+func (o *globalOptions) multiCluster() bool {
+	return len(o.contexts) > 0 || o.fleet != nil
+}
 
-	// Trigger a rollout restart by updating an annotation
-	deployment.Spec.Template.Annotations = map[string]string{
-		"kubectl.kubernetes.io/restartedAt": time.Now().Format(time.RFC3339),
+// clusterRuns prepares the run against every cluster of --contexts, with
+// the same rules, or of --fleet, with the cluster's overrides.
+func (o *globalOptions) clusterRuns(options RunOptions) ([]clusterRun, error) {
+	if o.fleet == nil {
+		rules, err := o.rules()
+		if err != nil {
+			return nil, err
+		}
+		var runs []clusterRun
+		for _, name := range o.contexts {
+			kubeConfig, err := o.restConfigFor(o.kubeconfig, name, name)
+			if err != nil {
+				return nil, err
+			}
+			client, err := o.newClientset(kubeConfig)
+			if err != nil {
+				return nil, err
+			}
+			clusterOptions := options
+			clusterOptions.RestConfig = kubeConfig
+			if clusterOptions.Metadata, err = newMetadataClient(kubeConfig); err != nil {
+				return nil, err
+			}
+			runs = append(runs, clusterRun{Name: name, Client: client, Options: clusterOptions, Rules: rules})
+		}
+		return runs, nil
 	}
 
-	_, err = deploymentClient.Update(context.Background(), deployment, metav1.UpdateOptions{})
+	var runs []clusterRun
+	for _, cluster := range o.fleet.Clusters {
+		namespaces := o.namespaces
+		if len(cluster.Namespaces) > 0 {
+			namespaces = cluster.Namespaces
+		}
+		configPath := o.configPath
+		if cluster.Config != "" {
+			configPath = cluster.Config
+		}
+		var rules []Rule
+		var err error
+		if len(cluster.Rules) > 0 {
+			if rules, err = scopeRules(cluster.Rules, o.scope, namespaces); err != nil {
+				return nil, configError("invalid --fleet: cluster %s: %v", cluster.Name, err)
+			}
+		} else if rules, err = o.rulesFor(configPath, namespaces); err != nil {
+			return nil, err
+		}
+		if len(cluster.Namespaces) > 0 {
+			rules = pinNamespaces(rules, cluster.Namespaces)
+		}
+
+		kubeconfig := o.kubeconfig
+		if cluster.Kubeconfig != "" {
+			kubeconfig = cluster.Kubeconfig
+		}
+		kubeConfig, err := o.restConfigFor(kubeconfig, cluster.Context, cluster.Name)
+		if err != nil {
+			return nil, err
+		}
+		client, err := o.newClientset(kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		clusterOptions := options
+		clusterOptions.RestConfig = kubeConfig
+		if clusterOptions.Metadata, err = newMetadataClient(kubeConfig); err != nil {
+			return nil, err
+		}
+		if cluster.ExcludeNamespaces != nil {
+			clusterOptions.ExcludeNamespaces = cluster.ExcludeNamespaces
+		}
+		if cluster.Config != "" {
+			if clusterOptions.DenyList, err = o.denyListFor(cluster.Config); err != nil {
+				return nil, err
+			}
+		}
+		runs = append(runs, clusterRun{Name: cluster.Name, Client: client, Options: clusterOptions, Rules: rules})
+	}
+	return runs, nil
+}
+
+// newClientset creates the Kubernetes client of a configuration. Unless
+// --kube-api-content-type is json, it talks protobuf, which is smaller and
+// cheaper to decode than JSON for large pod lists; every built-in resource
+// supports it. The configuration itself is left alone, as the operator's
+// client for RestartPolicies can only use JSON.
+func (o *globalOptions) newClientset(kubeConfig *rest.Config) (kubernetes.Interface, error) {
+	if o.contentType == ContentTypeProtobuf {
+		kubeConfig = rest.CopyConfig(kubeConfig)
+		kubeConfig.ContentType = runtime.ContentTypeProtobuf
+		kubeConfig.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+	}
+	clientset, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
-		return fmt.Errorf("error updating deployment: %v", err)
+		return nil, configError("error creating Kubernetes client: %v", err)
 	}
+	return clientset, nil
+}
 
+// newMetadataClient creates the client that lists objects as
+// PartialObjectMetadata.
+func newMetadataClient(kubeConfig *rest.Config) (metadata.Interface, error) {
+	client, err := metadata.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, configError("error creating Kubernetes metadata client: %v", err)
+	}
+	return client, nil
+}
+
+// checkPermissions runs Preflight against the client, unless
+// --preflight=false.
+func (o *globalOptions) checkPermissions(ctx context.Context, client kubernetes.Interface, rules []Rule, options RunOptions, cached bool) error {
+	if !o.preflight {
+		return nil
+	}
+	if err := Preflight(ctx, client, rules, options, cached); err != nil {
+		return configError("%v", err)
+	}
 	return nil
 }
+
+// runOptions returns the RunOptions derived from the shared flags.
+func (o *globalOptions) runOptions() RunOptions {
+	return RunOptions{
+		ExcludeNamespaces:  o.excludeNamespaces,
+		Scope:              o.scope,
+		OptInOnly:          o.optIn,
+		Cooldown:           o.cooldown,
+		RecordEvents:       o.recordEvents,
+		Strategy:           StrategyRollout,
+		PDBCheck:           PDBCheckSkip,
+		Concurrency:        o.concurrency,
+		ScanConcurrency:    o.scanConcurrency,
+		ClusterWideList:    o.clusterWideList,
+		MaxRestarts:        o.maxRestarts,
+		NamespaceLimit:     o.namespaceLimiter,
+		MaintenanceWindows: o.windows,
+		OutsideWindow:      o.outsideWindow,
+		Registry:           o.registry,
+		Notifiers:          o.notifiers,
+		Audit:              o.audit,
+		History:            o.history,
+		Freeze:             o.freeze,
+		Policy:             o.policy,
+		ArgoCD:             o.argoCD,
+		DenyList:           o.denyList,
+		Annotations:        o.annotations,
+	}
+}
+
+// runContext applies --timeout to ctx.
+func (o *globalOptions) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout > 0 {
+		return context.WithTimeout(ctx, o.timeout)
+	}
+	return context.WithCancel(ctx)
+}
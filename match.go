@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/testpractive123/assessment-devops.git/pkg/restarter"
+)
+
+// DefaultNameMatch is the pod name substring used when no selector or
+// pattern is configured.
+const DefaultNameMatch = "database"
+
+// PodMatcher decides which of the listed pods are restart candidates.
+type PodMatcher struct {
+	// LabelSelector is evaluated server-side by the Pods().List call; it is
+	// kept here so the matcher knows whether name matching is still needed.
+	LabelSelector string
+	// Patterns are pod name regular expressions combined with OR semantics.
+	Patterns []*regexp.Regexp
+	// Expression, when set, is a CEL expression over the pod that must hold
+	// as well.
+	Expression *restarter.Expression
+	// Matchers must all match as well, e.g. the built-in or custom matchers
+	// of a rule's matchers.
+	Matchers []restarter.Matcher
+}
+
+// CompilePatterns compiles pod name regular expressions.
+func CompilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// Match reports whether the pod should be restarted. Name patterns, the
+// expression and the matchers are applied on top of the label selector; with
+// none of them configured the pod name must contain DefaultNameMatch.
+func (m *PodMatcher) Match(pod *v1.Pod) bool {
+	if len(m.Patterns) > 0 && !(restarter.RegexMatcher{Patterns: m.Patterns}).Match(pod) {
+		return false
+	}
+	for _, matcher := range m.Matchers {
+		if !matcher.Match(pod) {
+			return false
+		}
+	}
+	if m.Expression != nil {
+		matched, err := m.Expression.Eval(restarter.ExpressionPod, pod)
+		if err != nil {
+			debugf("Pod %s/%s does not match: %v\n", pod.Namespace, pod.Name, err)
+		}
+		return matched
+	}
+	if len(m.Patterns) > 0 || len(m.Matchers) > 0 || m.LabelSelector != "" {
+		return true
+	}
+	return (restarter.SubstringMatcher{Substring: DefaultNameMatch}).Match(pod)
+}
+
+// ParseMatcherRule parses a matcher written as TYPE:VALUE, e.g.
+// "regex:^db-" or "label:tier=data".
+func ParseMatcherRule(spec string) (MatcherRule, error) {
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok || kind == "" {
+		return MatcherRule{}, fmt.Errorf("invalid matcher %q (want TYPE:VALUE)", spec)
+	}
+	return MatcherRule{Type: kind, Value: value}, nil
+}
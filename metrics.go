@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/spf13/pflag"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The restarter's metrics live in controller-runtime's registry, so the
+// operator's manager serves them along with its own, and the other modes serve
+// the same registry with the Go runtime and client-go metrics. The cluster
+// label holds the kubeconfig context in runs against several clusters and is
+// empty otherwise.
+var (
+	metricPodsScanned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "restarter_pods_scanned_total",
+		Help: "Pods evaluated against the rules, by cluster.",
+	}, []string{"cluster"})
+	metricRestartsAttempted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "restarter_restarts_attempted_total",
+		Help: "Workload restarts started, by cluster and kind.",
+	}, []string{"cluster", "kind"})
+	metricRestartsSucceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "restarter_restarts_succeeded_total",
+		Help: "Workload restarts that succeeded, including their rollout when waited for, by cluster and kind.",
+	}, []string{"cluster", "kind"})
+	metricRestartsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "restarter_restarts_failed_total",
+		Help: "Workload restarts that failed, including their rollout when waited for, by cluster and kind.",
+	}, []string{"cluster", "kind"})
+	metricAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "restarter_api_errors_total",
+		Help: "Kubernetes API requests that failed, by cluster and HTTP status code, or \"error\" when no response arrived.",
+	}, []string{"cluster", "code"})
+	metricRestartDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "restarter_restart_duration_seconds",
+		Help:    "Time taken to restart a workload, without waiting for its rollout; with --strategy=evict, to evict all its pods.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"cluster", "kind"})
+	metricRolloutDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "restarter_rollout_duration_seconds",
+		Help:    "Time from a restart until its rollout finished, failed or timed out, by cluster, kind and rollout status.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"cluster", "kind", "rollout"})
+	metricPolicyDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "restarter_policy_denials_total",
+		Help: "Matched workloads and orphaned pods left alone because they are on the deny-list, by cluster and namespace.",
+	}, []string{"cluster", "namespace"})
+	metricRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "restarter_rate_limited_total",
+		Help: "Workload restarts skipped because their namespace used up --namespace-rate-limit, by cluster and namespace.",
+	}, []string{"cluster", "namespace"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		metricPodsScanned,
+		metricRestartsAttempted,
+		metricRestartsSucceeded,
+		metricRestartsFailed,
+		metricAPIErrors,
+		metricRestartDuration,
+		metricRolloutDuration,
+		metricPolicyDenials,
+		metricRateLimited,
+	)
+}
+
+// observeRestart records the outcome of a restart attempt.
+func observeRestart(result Result) {
+	metricRestartsAttempted.WithLabelValues(result.Cluster, result.Kind).Inc()
+	switch result.Status {
+	case StatusRestarted:
+		metricRestartsSucceeded.WithLabelValues(result.Cluster, result.Kind).Inc()
+	case StatusFailed:
+		metricRestartsFailed.WithLabelValues(result.Cluster, result.Kind).Inc()
+	}
+}
+
+// pushMetrics pushes the metrics of a one-shot run to a Pushgateway, along
+// with when the run started, how long it took and whether it succeeded. The
+// push replaces the metrics of the job's previous run.
+func pushMetrics(url, job string, report RunReport, succeeded bool) error {
+	runMetrics := prometheus.NewRegistry()
+	lastRun := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "restarter_last_run_timestamp_seconds",
+		Help: "When the last run started, in seconds since the epoch.",
+	})
+	lastRunDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "restarter_last_run_duration_seconds",
+		Help: "How long the last run took.",
+	})
+	lastRunSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "restarter_last_run_success",
+		Help: "1 when the last run listed everything and no restart failed, 0 otherwise.",
+	})
+	runMetrics.MustRegister(lastRun, lastRunDuration, lastRunSuccess)
+	lastRun.Set(float64(report.Started.UnixNano()) / 1e9)
+	lastRunDuration.Set(report.Duration.Seconds())
+	if succeeded {
+		lastRunSuccess.Set(1)
+	}
+
+	err := push.New(url, job).
+		Client(&http.Client{Timeout: 10 * time.Second}).
+		Gatherer(prometheus.Gatherers{ctrlmetrics.Registry, runMetrics}).
+		Push()
+	if err != nil {
+		return fmt.Errorf("error pushing metrics to %s: %v", url, err)
+	}
+	debugf("Pushed metrics to %s\n", url)
+	return nil
+}
+
+// metricsRoundTripper counts failed API requests to a cluster.
+type metricsRoundTripper struct {
+	next    http.RoundTripper
+	cluster string
+}
+
+func (rt metricsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		metricAPIErrors.WithLabelValues(rt.cluster, "error").Inc()
+	} else if resp.StatusCode >= http.StatusBadRequest {
+		metricAPIErrors.WithLabelValues(rt.cluster, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// metricsOptions configures the Prometheus endpoint of the long-running
+// modes.
+type metricsOptions struct {
+	listen string
+}
+
+func (m *metricsOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&m.listen, "metrics-listen", "", "address to serve Prometheus metrics on at /metrics, e.g. :9090 (empty disables it)")
+}
+
+// bindAddress returns the listen address in the form of controller-runtime's
+// MetricsBindAddress, where "0" disables the endpoint.
+func (m *metricsOptions) bindAddress() string {
+	if m.listen == "" {
+		return "0"
+	}
+	return m.listen
+}
+
+// start serves the metrics in the background until ctx is cancelled. Every
+// replica serves them, whether or not it holds the leader election Lease.
+func (m *metricsOptions) start(ctx context.Context) error {
+	if m.listen == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", m.listen)
+	if err != nil {
+		return configError("invalid --metrics-listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+	infof("Serving metrics at %s/metrics\n", listener.Addr())
+	go func() {
+		if err := serveListener(ctx, listener, mux, nil); err != nil {
+			errorf("Error serving metrics: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds each notification request, so a slow receiver cannot
+// hold up the next run.
+const notifyTimeout = 10 * time.Second
+
+// Notifier is told about the outcome of every run that matched something.
+type Notifier interface {
+	// Name identifies the notifier in log messages, e.g. "Slack".
+	Name() string
+	Notify(ctx context.Context, report RunReport) error
+}
+
+// RunReport summarizes a finished run for notifiers and their templates.
+type RunReport struct {
+	// Summary counts the results by status, e.g. "2 restarted, 1 skipped".
+	Summary string
+	// Operation is OperationRestart, OperationPause or OperationResume.
+	Operation string
+	DryRun    bool
+	Started   time.Time
+	Duration  time.Duration
+	// Results holds every result; Changed those whose workload was (or, in
+	// a dry run, would have been) restarted, deleted, paused or resumed, or
+	// that were only reported; Skipped and Failed the rest.
+	Results []Result
+	Changed []Result
+	Skipped []Result
+	Failed  []Result
+	// ListFailed is set when some namespaces or pods could not be listed.
+	ListFailed bool
+	// Namespaces is how many namespaces were scanned, and Pods how many pods
+	// were evaluated against the rules.
+	Namespaces int
+	Pods       int
+	// Cluster is the kubeconfig context of the run in runs against several
+	// clusters.
+	Cluster string
+	// RunID identifies the run; Actor is the Kubernetes identity it acted
+	// as.
+	RunID string
+	Actor string
+}
+
+// newRunReport builds the report of a run that started at started.
+func newRunReport(options RunOptions, started time.Time, results []Result, listFailed bool) RunReport {
+	report := RunReport{
+		Summary:    Summarize(results),
+		Operation:  options.Operation,
+		DryRun:     options.DryRun,
+		Started:    started,
+		Duration:   time.Since(started),
+		Results:    results,
+		ListFailed: listFailed,
+		RunID:      options.RunID,
+		Actor:      options.Actor,
+	}
+	if report.Operation == "" {
+		report.Operation = OperationRestart
+	}
+	for _, result := range results {
+		switch result.Status {
+		case StatusSkipped:
+			report.Skipped = append(report.Skipped, result)
+		case StatusFailed:
+			report.Failed = append(report.Failed, result)
+		default:
+			report.Changed = append(report.Changed, result)
+		}
+	}
+	return report
+}
+
+// notify hands the report to every notifier. Runs that matched nothing are
+// not reported unless listing failed. Notification errors are logged and do
+// not fail the run.
+func notify(notifiers []Notifier, report RunReport) {
+	if len(notifiers) == 0 || (len(report.Results) == 0 && !report.ListFailed) {
+		return
+	}
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(context.Background(), report); err != nil {
+			warnf("Error sending the %s notification: %v\n", notifier.Name(), err)
+		} else {
+			debugf("Sent the %s notification\n", notifier.Name())
+		}
+	}
+}
+
+// postJSON posts body encoded as JSON to url with the given extra headers
+// and fails unless the receiver answers with a 2xx status.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error encoding the message: %v", err)
+	}
+	_, err = post(ctx, client, url, header, data)
+	return err
+}
+
+// post posts a JSON document and returns the status code of the response,
+// zero when none arrived. Statuses other than 2xx are errors.
+func post(ctx context.Context, client *http.Client, url string, header http.Header, data []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("error creating the request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error posting the message: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("error posting the message: %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	restarterv1alpha1 "github.com/testpractive123/assessment-devops.git/api/v1alpha1"
+)
+
+// DefaultPolicyInterval is how often a RestartPolicy without an interval is
+// evaluated.
+const DefaultPolicyInterval = 5 * time.Minute
+
+// maxStatusWorkloads caps the workloads listed in a policy status so a broad
+// policy cannot grow the object past the API server's size limit.
+const maxStatusWorkloads = 100
+
+// RestartPolicyReconciler evaluates each RestartPolicy on its interval with the
+// same engine as the CLI and records the outcome in its status.
+type RestartPolicyReconciler struct {
+	client.Client
+	Clientset kubernetes.Interface
+	// Options are the operator-wide defaults; policies override the dry-run,
+	// strategy and max-restarts settings.
+	Options RunOptions
+}
+
+// +kubebuilder:rbac:groups=restarter.io,resources=restartpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=restarter.io,resources=restartpolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+// +kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups="",resources=events,verbs=list;watch;create
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments/scale;statefulsets/scale,verbs=get;update
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=list
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;create;update
+
+// Reconcile evaluates the policy when its interval has elapsed or its spec
+// changed, and requeues it for the next interval, or sooner when it failed
+// and has a backoff.
+func (r *RestartPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var policy restarterv1alpha1.RestartPolicy
+	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if policy.Spec.Suspend {
+		debugf("Policy %s is suspended\n", policy.Name)
+		if meta.IsStatusConditionTrue(policy.Status.Conditions, restarterv1alpha1.ConditionSuspended) {
+			return ctrl.Result{}, nil
+		}
+		status := *policy.Status.DeepCopy()
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               restarterv1alpha1.ConditionSuspended,
+			Status:             metav1.ConditionTrue,
+			Reason:             restarterv1alpha1.ReasonSuspended,
+			Message:            "spec.suspend is set; the policy is not evaluated",
+			ObservedGeneration: policy.Generation,
+		})
+		return ctrl.Result{}, r.patchStatus(ctx, &policy, status)
+	}
+
+	interval := DefaultPolicyInterval
+	if policy.Spec.Interval != nil && policy.Spec.Interval.Duration > 0 {
+		interval = policy.Spec.Interval.Duration
+	}
+	now := time.Now()
+	if last := policy.Status.LastRunTime; last != nil && policy.Status.ObservedGeneration == policy.Generation {
+		if wait := last.Add(policyRequeue(&policy.Spec, policy.Status.ConsecutiveFailures, interval)).Sub(now); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	// Conditions are carried over so their transition times stay accurate.
+	status := restarterv1alpha1.RestartPolicyStatus{
+		ObservedGeneration: policy.Generation,
+		LastRunTime:        &metav1.Time{Time: now},
+		TotalRestarted:     policy.Status.TotalRestarted,
+		Conditions:         append([]metav1.Condition(nil), policy.Status.Conditions...),
+	}
+	setPolicyCondition(&status, restarterv1alpha1.ConditionSuspended, metav1.ConditionFalse, restarterv1alpha1.ReasonActive, "the policy is evaluated every "+interval.String())
+	rule, err := PolicyRule(&policy)
+	if err != nil {
+		// An invalid spec is only retried once it changes.
+		errorf("Invalid policy %s: %v\n", policy.Name, err)
+		status.Error = err.Error()
+		setPolicyCondition(&status, restarterv1alpha1.ConditionReady, metav1.ConditionFalse, restarterv1alpha1.ReasonInvalidSpec, err.Error())
+		return ctrl.Result{}, r.patchStatus(ctx, &policy, status)
+	}
+
+	options := r.Options
+	options.DryRun = options.DryRun || policy.Spec.DryRun
+	if policy.Spec.Strategy != "" {
+		options.Strategy = policy.Spec.Strategy
+	}
+	if policy.Spec.MaxRestarts > 0 {
+		options.MaxRestarts = int(policy.Spec.MaxRestarts)
+	}
+	results, err := NewRunner(r.Clientset, options).ProcessRule(ctx, &rule)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	recordResults(&status, results)
+	status.TotalRestarted += int64(status.Restarted)
+	if runFailed(results, err != nil) {
+		status.ConsecutiveFailures = policy.Status.ConsecutiveFailures + 1
+	}
+	switch {
+	case err != nil:
+		setPolicyCondition(&status, restarterv1alpha1.ConditionReady, metav1.ConditionFalse, restarterv1alpha1.ReasonListFailed, err.Error())
+	case status.Failed > 0:
+		setPolicyCondition(&status, restarterv1alpha1.ConditionReady, metav1.ConditionFalse, restarterv1alpha1.ReasonRestartsFailed,
+			fmt.Sprintf("%d of %d matched pods failed; see status.workloads", status.Failed, status.Matched))
+	default:
+		setPolicyCondition(&status, restarterv1alpha1.ConditionReady, metav1.ConditionTrue, restarterv1alpha1.ReasonEvaluated,
+			fmt.Sprintf("%d pods matched, %d workloads restarted", status.Matched, status.Restarted))
+	}
+
+	if err := r.patchStatus(ctx, &policy, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	requeue := policyRequeue(&policy.Spec, status.ConsecutiveFailures, interval)
+	if requeue < interval {
+		infof("Policy %s failed %d time(s) in a row, retrying in %s\n", policy.Name, status.ConsecutiveFailures, requeue)
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// policyRequeue returns how long after an evaluation the policy is evaluated
+// again: its interval, or its backoff delay after failures if that is
+// shorter.
+func policyRequeue(spec *restarterv1alpha1.RestartPolicySpec, failures int32, interval time.Duration) time.Duration {
+	if failures == 0 || spec.Backoff == nil {
+		return interval
+	}
+	backoff := policyBackoff(spec.Backoff)
+	if backoff.validate() != nil {
+		return interval
+	}
+	if delay := backoff.Delay(int(failures)); delay < interval {
+		return delay
+	}
+	return interval
+}
+
+func policyBackoff(backoff *restarterv1alpha1.Backoff) *Backoff {
+	if backoff == nil {
+		return nil
+	}
+	converted := &Backoff{Factor: backoff.Factor}
+	if backoff.Initial != nil {
+		converted.Initial = backoff.Initial.Duration
+	}
+	if backoff.Max != nil {
+		converted.Max = backoff.Max.Duration
+	}
+	return converted
+}
+
+// patchStatus replaces the policy status with a merge patch, so a concurrent
+// spec update cannot make a finished evaluation run again.
+func (r *RestartPolicyReconciler) patchStatus(ctx context.Context, policy *restarterv1alpha1.RestartPolicy, status restarterv1alpha1.RestartPolicyStatus) error {
+	patch := client.MergeFrom(policy.DeepCopy())
+	policy.Status = status
+	if err := r.Status().Patch(ctx, policy, patch); err != nil {
+		return fmt.Errorf("error updating status of policy %s: %v", policy.Name, err)
+	}
+	return nil
+}
+
+// setPolicyCondition sets a condition observed at the status's generation.
+func setPolicyCondition(status *restarterv1alpha1.RestartPolicyStatus, conditionType string, value metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             value,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: status.ObservedGeneration,
+	})
+}
+
+// SetupWithManager registers the reconciler. Status updates do not change the
+// generation, so they do not trigger another evaluation.
+func (r *RestartPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&restarterv1alpha1.RestartPolicy{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
+
+// recordResults summarizes the results of an evaluation into status.
+func recordResults(status *restarterv1alpha1.RestartPolicyStatus, results []Result) {
+	for _, result := range results {
+		status.Matched++
+		switch result.Status {
+		case StatusRestarted, StatusDeleted:
+			status.Restarted++
+		case StatusFailed:
+			status.Failed++
+		}
+		if result.Workload == "" || result.Status == StatusMatched || len(status.Workloads) >= maxStatusWorkloads {
+			continue
+		}
+		reason := result.Reason
+		if result.Error != "" {
+			reason = result.Error
+		}
+		status.Workloads = append(status.Workloads, restarterv1alpha1.WorkloadStatus{
+			Kind:      result.Kind,
+			Namespace: result.Namespace,
+			Name:      result.Workload,
+			Status:    result.Status,
+			Reason:    reason,
+		})
+	}
+}
+
+// PolicyRule converts a RestartPolicy into a validated Rule. Unlike the
+// selection flags, a policy must say which pods it selects.
+func PolicyRule(policy *restarterv1alpha1.RestartPolicy) (Rule, error) {
+	spec := policy.Spec
+	namespaceSelector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid namespaceSelector: %v", err)
+	}
+	podSelector, err := metav1.LabelSelectorAsSelector(spec.PodSelector)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid podSelector: %v", err)
+	}
+	if podSelector.Empty() && len(spec.Match) == 0 {
+		return Rule{}, fmt.Errorf("one of podSelector or match is required")
+	}
+
+	rule := Rule{
+		Name:               policy.Name,
+		Namespaces:         spec.Namespaces,
+		NamespaceSelector:  namespaceSelector.String(),
+		ExcludeNamespaces:  spec.ExcludeNamespaces,
+		PodSelector:        podSelector.String(),
+		FieldSelector:      spec.FieldSelector,
+		Match:              spec.Match,
+		OnlyUnhealthy:      spec.Triggers.OnlyUnhealthy,
+		OOMKills:           spec.Triggers.OOMKills,
+		OOMWindow:          durationPointer(spec.Triggers.OOMWindow),
+		RestartCount:       spec.Triggers.RestartCount,
+		RestartWindow:      durationPointer(spec.Triggers.RestartWindow),
+		ImageDrift:         spec.Triggers.ImageDrift,
+		OlderThan:          spec.Triggers.OlderThan,
+		Action:             spec.Action,
+		Cooldown:           durationPointer(spec.Cooldown),
+		MaintenanceWindows: spec.MaintenanceWindows,
+		Backoff:            policyBackoff(spec.Backoff),
+	}
+	for _, event := range spec.Triggers.Events {
+		rule.Events = append(rule.Events, EventRule{
+			Reason:  event.Reason,
+			Message: event.Message,
+			Count:   event.Count,
+			Window:  durationPointer(event.Window),
+		})
+	}
+	if field, err := rule.Validate(); err != nil {
+		return Rule{}, fmt.Errorf("invalid %s: %v", field, err)
+	}
+	return rule, nil
+}
+
+func durationPointer(d *metav1.Duration) *time.Duration {
+	if d == nil {
+		return nil
+	}
+	return &d.Duration
+}
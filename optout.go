@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AnnotationEnabled opts a workload or a whole namespace in ("true") or out
+// ("false") of automated restarts.
+const AnnotationEnabled = "restarter.io/enabled"
+
+// RestartAllowed applies the opt-in/opt-out annotations. The workload's
+// annotation wins over its namespace's; without either, workloads are
+// enabled unless optInOnly is set. When a restart is not allowed, the
+// returned string explains why.
+func RestartAllowed(workloadAnnotations, namespaceAnnotations map[string]string, optInOnly bool) (bool, string) {
+	if enabled, ok := parseEnabled(workloadAnnotations); ok {
+		if !enabled {
+			return false, fmt.Sprintf("workload annotated %s=false", AnnotationEnabled)
+		}
+		return true, ""
+	}
+	if enabled, ok := parseEnabled(namespaceAnnotations); ok {
+		if !enabled {
+			return false, fmt.Sprintf("namespace annotated %s=false", AnnotationEnabled)
+		}
+		return true, ""
+	}
+	if optInOnly {
+		return false, fmt.Sprintf("opt-in mode and no %s=true annotation", AnnotationEnabled)
+	}
+	return true, ""
+}
+
+func parseEnabled(annotations map[string]string) (bool, bool) {
+	value, ok := annotations[AnnotationEnabled]
+	if !ok {
+		return false, false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		warnf("Ignoring invalid %s annotation value %q\n", AnnotationEnabled, value)
+		return false, false
+	}
+	return enabled, true
+}
+
+// namespaceAnnotations returns the annotations of a namespace. Lookup errors
+// (typically missing RBAC) are logged and treated as no annotations. In
+// namespace scope the namespace is not read at all.
+func namespaceAnnotations(ctx context.Context, name string, scope string, client kubernetes.Interface) map[string]string {
+	if scope == ScopeNamespace {
+		return nil
+	}
+	var namespace *v1.Namespace
+	err := callAPI(ctx, func(ctx context.Context) error {
+		var err error
+		namespace, err = client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		warnf("Could not read annotations of namespace %s: %v\n", name, err)
+		return nil
+	}
+	return namespace.Annotations
+}
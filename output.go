@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/testpractive123/assessment-devops.git/pkg/restarter"
+)
+
+// Output formats accepted by --output.
+const (
+	OutputText  = "text"
+	OutputTable = "table"
+	// OutputSummary rolls the results up into a row per namespace.
+	OutputSummary = "summary"
+	// OutputReleases rolls the results up into a row per Helm release.
+	OutputReleases = "releases"
+	OutputJSON     = "json"
+	OutputYAML     = "yaml"
+)
+
+// Result statuses.
+const (
+	StatusMatched   = "matched"
+	StatusDryRun    = "dry-run"
+	StatusSkipped   = "skipped"
+	StatusRestarted = "restarted"
+	StatusDeleted   = "deleted"
+	StatusPaused    = "paused"
+	StatusResumed   = "resumed"
+	StatusFailed    = "failed"
+)
+
+// Result records what happened to one matched pod.
+type Result struct {
+	// Cluster is the kubeconfig context of the result in runs against
+	// several clusters.
+	Cluster   string `json:"cluster,omitempty"`
+	Rule      string `json:"rule"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Kind      string `json:"kind,omitempty"`
+	Workload  string `json:"workload,omitempty"`
+	// Release is the Helm release of the workload, if any.
+	Release string `json:"release,omitempty"`
+	Status  string `json:"status"`
+	// Trigger describes the pod condition that selected it, if any.
+	Trigger string `json:"trigger,omitempty"`
+	// Rollout is the outcome of waiting for the rollout, when requested.
+	Rollout string `json:"rollout,omitempty"`
+	// Reason explains why a pod's workload was skipped.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// ErrorKind classifies Error when its kind is known: no-owner,
+	// conflict or forbidden.
+	ErrorKind string `json:"errorKind,omitempty"`
+}
+
+// fail marks the result failed with err.
+func (r *Result) fail(err error) {
+	r.Status, r.Error, r.ErrorKind = StatusFailed, err.Error(), restarter.ErrorKind(err)
+}
+
+// Report is the document written for the json and yaml formats.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// ValidateOutputFormat rejects unknown --output values.
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OutputText, OutputTable, OutputSummary, OutputReleases, OutputJSON, OutputYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (want %s, %s, %s, %s, %s or %s)", format, OutputText, OutputTable, OutputSummary, OutputReleases, OutputJSON, OutputYAML)
+}
+
+// IsMachineReadable reports whether the format needs stdout to itself.
+func IsMachineReadable(format string) bool {
+	return format == OutputJSON || format == OutputYAML
+}
+
+// resultStatuses lists the result statuses in the order summaries use.
+var resultStatuses = []string{StatusRestarted, StatusDeleted, StatusPaused, StatusResumed, StatusDryRun, StatusMatched, StatusSkipped, StatusFailed}
+
+// Summarize counts the results by status, e.g. "2 restarted, 1 skipped".
+func Summarize(results []Result) string {
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	var parts []string
+	for _, status := range resultStatuses {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	if len(parts) == 0 {
+		return "nothing matched"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// logSummary logs the outcome of a run in one place: what was scanned, the
+// counts by status, and why each workload was skipped or failed. Pods skipped
+// because their workload was already handled for another pod are only
+// counted.
+func logSummary(report RunReport) {
+	clusterLog(report.Cluster).with("namespaces", report.Namespaces, "pods", report.Pods, "matched", len(report.Results)).
+		infof("Summary: scanned %s and %s, matched %s: %s\n",
+			plural(report.Namespaces, "namespace"), plural(report.Pods, "pod"), plural(len(report.Results), "pod"), report.Summary)
+	for _, result := range report.Skipped {
+		if !strings.HasPrefix(result.Reason, "already handled for ") {
+			resultLog(result).infof("  Skipped %s: %s\n", resultTarget(result), result.Reason)
+		}
+	}
+	for _, result := range report.Failed {
+		resultLog(result).warnf("  Failed %s: %s\n", resultTarget(result), result.Error)
+	}
+	if report.ListFailed {
+		clusterLog(report.Cluster).warnf("  Some namespaces or pods could not be listed\n")
+	}
+}
+
+// resultTarget names the workload of a result, or its pod when it has none,
+// e.g. "deployment shop/web".
+func resultTarget(result Result) string {
+	if result.Workload == "" {
+		return fmt.Sprintf("pod %s/%s", result.Namespace, result.Pod)
+	}
+	return fmt.Sprintf("%s %s/%s", strings.ToLower(result.Kind), result.Namespace, result.Workload)
+}
+
+// resultLog returns the log fields of a result.
+func resultLog(result Result) logFields {
+	log := clusterLog(result.Cluster).with("rule", result.Rule, "namespace", result.Namespace, "pod", result.Pod, "status", result.Status)
+	if result.Workload != "" {
+		log = log.with(strings.ToLower(result.Kind), result.Workload)
+	}
+	return log
+}
+
+// clusterLog returns the log fields of a cluster, none for "".
+func clusterLog(cluster string) logFields {
+	if cluster == "" {
+		return nil
+	}
+	return withFields("cluster", cluster)
+}
+
+// plural formats a count with its noun, e.g. "1 pod" or "3 pods".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// WriteResults renders the results in the requested format. The text format
+// has already been written as progress messages and produces nothing here.
+func WriteResults(w io.Writer, format string, results []Result) error {
+	report := Report{Results: results}
+	if report.Results == nil {
+		report.Results = []Result{}
+	}
+
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case OutputYAML:
+		data, err := yaml.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error encoding results: %v", err)
+		}
+		_, err = w.Write(data)
+		return err
+	case OutputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		clusters := hasClusters(results)
+		if clusters {
+			fmt.Fprint(tw, "CLUSTER\t")
+		}
+		fmt.Fprintln(tw, "RULE\tNAMESPACE\tPOD\tWORKLOAD\tSTATUS\tROLLOUT\tDETAIL")
+		for _, r := range results {
+			workload := "-"
+			if r.Workload != "" {
+				workload = r.Kind + "/" + r.Workload
+			}
+			detail := r.Error
+			if detail == "" {
+				detail = r.Reason
+			}
+			rollout := r.Rollout
+			if rollout == "" {
+				rollout = "-"
+			}
+			if clusters {
+				fmt.Fprint(tw, r.Cluster+"\t")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Rule, r.Namespace, r.Pod, workload, r.Status, rollout, detail)
+		}
+		return tw.Flush()
+	case OutputSummary:
+		return writeSummary(w, results, []string{"NAMESPACE"}, func(result Result) string {
+			return result.Namespace
+		})
+	case OutputReleases:
+		return writeSummary(w, results, []string{"NAMESPACE", "RELEASE"}, func(result Result) string {
+			release := result.Release
+			if release == "" {
+				release = "-"
+			}
+			return result.Namespace + "\t" + release
+		})
+	}
+	return nil
+}
+
+// hasClusters reports whether the results come from runs against several
+// clusters, which the table and summary formats show in a CLUSTER column.
+func hasClusters(results []Result) bool {
+	for _, result := range results {
+		if result.Cluster != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSummary writes a row per group, or per cluster and group, with the
+// number of pods matched and a column per result status that occurred, then
+// the totals. key returns the tab-separated group of a result, such as its
+// namespace, and columns names its parts.
+func writeSummary(w io.Writer, results []Result, columns []string, key func(Result) string) error {
+	clusters := hasClusters(results)
+	counts := map[string]map[string]int{}
+	total := map[string]int{}
+	for _, result := range results {
+		group := key(result)
+		if clusters {
+			group = result.Cluster + "\t" + group
+		}
+		if counts[group] == nil {
+			counts[group] = map[string]int{}
+		}
+		counts[group][result.Status]++
+		total[result.Status]++
+	}
+	var statuses []string
+	for _, status := range resultStatuses {
+		if total[status] > 0 {
+			statuses = append(statuses, status)
+		}
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	row := func(name string, counts map[string]int) {
+		matched := 0
+		for _, count := range counts {
+			matched += count
+		}
+		fmt.Fprintf(tw, "%s\t%d", name, matched)
+		for _, status := range statuses {
+			fmt.Fprintf(tw, "\t%d", counts[status])
+		}
+		fmt.Fprintln(tw)
+	}
+	if clusters {
+		fmt.Fprint(tw, "CLUSTER\t")
+	}
+	fmt.Fprint(tw, strings.Join(columns, "\t")+"\tMATCHED")
+	for _, status := range statuses {
+		fmt.Fprint(tw, "\t"+strings.ToUpper(status))
+	}
+	fmt.Fprintln(tw)
+	for _, key := range keys {
+		row(key, counts[key])
+	}
+	padding := len(columns) - 1
+	if clusters {
+		padding++
+	}
+	row("TOTAL"+strings.Repeat("\t", padding), total)
+	return tw.Flush()
+}
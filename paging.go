@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Endpoints of the paging services.
+const (
+	DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	DefaultOpsgenieURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+// PagerDuty severities, https://developer.pagerduty.com/docs/events-api-v2/trigger-events/.
+const (
+	PagerDutyCritical = "critical"
+	PagerDutyError    = "error"
+	PagerDutyWarning  = "warning"
+	PagerDutyInfo     = "info"
+)
+
+// ValidatePagerDutySeverity rejects unknown --pagerduty-severity values.
+func ValidatePagerDutySeverity(severity string) error {
+	switch severity {
+	case PagerDutyCritical, PagerDutyError, PagerDutyWarning, PagerDutyInfo:
+		return nil
+	}
+	return fmt.Errorf("unknown severity %q (want %s, %s, %s or %s)", severity, PagerDutyCritical, PagerDutyError, PagerDutyWarning, PagerDutyInfo)
+}
+
+// ValidateOpsgeniePriority rejects unknown --opsgenie-priority values.
+func ValidateOpsgeniePriority(priority string) error {
+	switch priority {
+	case "P1", "P2", "P3", "P4", "P5":
+		return nil
+	}
+	return fmt.Errorf("unknown priority %q (want P1, P2, P3, P4 or P5)", priority)
+}
+
+// restartFailures returns the failed restarts of a report: failed patches or
+// evictions, and, with --wait, rollouts that did not converge. Pods whose
+// workload could not be resolved are left out, as no restart was attempted.
+func restartFailures(report RunReport) []Result {
+	var failures []Result
+	for _, result := range report.Failed {
+		if result.Workload != "" {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// alertKey identifies the alert of a workload, so the paging service folds
+// repeated failures of a workload into one incident.
+func alertKey(result Result) string {
+	if result.Cluster != "" {
+		return fmt.Sprintf("restarter/%s/%s/%s/%s", result.Cluster, result.Namespace, strings.ToLower(result.Kind), result.Workload)
+	}
+	return fmt.Sprintf("restarter/%s/%s/%s", result.Namespace, strings.ToLower(result.Kind), result.Workload)
+}
+
+// alertSummary is the one-line title of the alert of a failed restart.
+func alertSummary(result Result) string {
+	what := "Restart"
+	if result.Rollout != "" && result.Rollout != RolloutComplete {
+		what = "Rollout"
+	}
+	return fmt.Sprintf("%s of %s %s/%s failed: %s", what, strings.ToLower(result.Kind), result.Namespace, result.Workload, result.Error)
+}
+
+// alertTitle is the title of the alert of a failed restart: tmpl rendered
+// with the result, or alertSummary without a template or when rendering fails.
+func alertTitle(tmpl *template.Template, report RunReport, result Result) string {
+	if tmpl == nil {
+		return alertSummary(result)
+	}
+	title, err := renderResultTemplate(tmpl, result, report.RunID, report.Actor)
+	if err != nil {
+		warnf("%v\n", err)
+		return alertSummary(result)
+	}
+	return title
+}
+
+// alertDetails are the fields attached to the alert of a failed restart.
+func alertDetails(result Result) map[string]string {
+	details := map[string]string{
+		"rule":      result.Rule,
+		"namespace": result.Namespace,
+		"kind":      result.Kind,
+		"workload":  result.Workload,
+		"pod":       result.Pod,
+		"error":     result.Error,
+	}
+	for key, value := range map[string]string{"trigger": result.Trigger, "rollout": result.Rollout} {
+		if value != "" {
+			details[key] = value
+		}
+	}
+	return details
+}
+
+// truncate shortens s to at most max bytes.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
+
+// PagerDutyNotifier triggers a PagerDuty incident through the Events API v2
+// for every failed restart.
+type PagerDutyNotifier struct {
+	URL        string
+	RoutingKey string
+	Severity   string
+	// Template, when set, renders the incident summaries.
+	Template *template.Template
+	HTTP     *http.Client
+}
+
+// Name implements Notifier.
+func (p *PagerDutyNotifier) Name() string {
+	return "PagerDuty"
+}
+
+// Notify implements Notifier.
+func (p *PagerDutyNotifier) Notify(ctx context.Context, report RunReport) error {
+	source, _ := os.Hostname()
+	for _, result := range restartFailures(report) {
+		event := map[string]interface{}{
+			"routing_key":  p.RoutingKey,
+			"event_action": "trigger",
+			"dedup_key":    alertKey(result),
+			"payload": map[string]interface{}{
+				"summary":        truncate(alertTitle(p.Template, report, result), 1024),
+				"source":         source,
+				"severity":       p.Severity,
+				"component":      result.Namespace + "/" + result.Workload,
+				"group":          result.Namespace,
+				"class":          "restart-failed",
+				"custom_details": alertDetails(result),
+			},
+		}
+		if err := postJSON(ctx, p.HTTP, p.URL, nil, event); err != nil {
+			return fmt.Errorf("error paging for %s/%s: %v", result.Namespace, result.Workload, err)
+		}
+	}
+	return nil
+}
+
+// OpsgenieNotifier creates an Opsgenie alert for every failed restart.
+type OpsgenieNotifier struct {
+	URL      string
+	APIKey   string
+	Priority string
+	// Template, when set, renders the alert messages.
+	Template *template.Template
+	HTTP     *http.Client
+}
+
+// Name implements Notifier.
+func (o *OpsgenieNotifier) Name() string {
+	return "Opsgenie"
+}
+
+// Notify implements Notifier.
+func (o *OpsgenieNotifier) Notify(ctx context.Context, report RunReport) error {
+	source, _ := os.Hostname()
+	header := http.Header{"Authorization": {"GenieKey " + o.APIKey}}
+	for _, result := range restartFailures(report) {
+		alert := map[string]interface{}{
+			"message":     truncate(alertTitle(o.Template, report, result), 130),
+			"alias":       alertKey(result),
+			"description": result.Error,
+			"priority":    o.Priority,
+			"source":      source,
+			"entity":      result.Namespace + "/" + result.Workload,
+			"tags":        []string{"restarter", "namespace:" + result.Namespace},
+			"details":     alertDetails(result),
+		}
+		if err := postJSON(ctx, o.HTTP, o.URL, header, alert); err != nil {
+			return fmt.Errorf("error alerting for %s/%s: %v", result.Namespace, result.Workload, err)
+		}
+	}
+	return nil
+}
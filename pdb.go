@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodDisruptionBudget check modes.
+const (
+	// PDBCheckSkip skips restarts that would violate a PodDisruptionBudget.
+	PDBCheckSkip = "skip"
+	// PDBCheckWarn logs a warning and restarts anyway.
+	PDBCheckWarn = "warn"
+	// PDBCheckOff does not look at PodDisruptionBudgets.
+	PDBCheckOff = "off"
+)
+
+// ValidatePDBCheck rejects unknown --pdb-check values.
+func ValidatePDBCheck(mode string) error {
+	switch mode {
+	case PDBCheckSkip, PDBCheckWarn, PDBCheckOff:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q (want %s, %s or %s)", mode, PDBCheckSkip, PDBCheckWarn, PDBCheckOff)
+}
+
+// CheckDisruptionBudgets reports whether a restart of the workload taking
+// down unavailable pods at once would take down more than a
+// PodDisruptionBudget covering them currently allows, and if so which budget
+// blocks it.
+func CheckDisruptionBudgets(ctx context.Context, workload *Workload, unavailable int32, client kubernetes.Interface) (bool, string, error) {
+	if unavailable == 0 {
+		return false, "", nil
+	}
+
+	var budgets *policyv1.PodDisruptionBudgetList
+	err := callAPI(ctx, func(ctx context.Context) error {
+		var err error
+		budgets, err = client.PolicyV1().PodDisruptionBudgets(workload.Namespace).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("error listing PodDisruptionBudgets: %v", err)
+	}
+
+	podLabels := labels.Set(workload.PodTemplate().Labels)
+	for _, budget := range budgets.Items {
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(podLabels) {
+			continue
+		}
+		if budget.Status.DisruptionsAllowed < unavailable {
+			return true, fmt.Sprintf("PodDisruptionBudget %s allows %d disruptions, the restart takes down %d pods at once (%d/%d healthy)",
+				budget.Name, budget.Status.DisruptionsAllowed, unavailable, budget.Status.CurrentHealthy, budget.Status.DesiredHealthy), nil
+		}
+	}
+	return false, "", nil
+}
+
+// rolloutUnavailable returns how many pods a rollout of the workload takes
+// down at once, following each controller's update strategy.
+func rolloutUnavailable(workload *Workload) int32 {
+	switch obj := workload.Object.(type) {
+	case *appsv1.Deployment:
+		replicas := int32(1)
+		if obj.Spec.Replicas != nil {
+			replicas = *obj.Spec.Replicas
+		}
+		if obj.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+			return replicas
+		}
+		maxUnavailable := intstr.FromString("25%")
+		if update := obj.Spec.Strategy.RollingUpdate; update != nil && update.MaxUnavailable != nil {
+			maxUnavailable = *update.MaxUnavailable
+		}
+		value, err := intstr.GetScaledValueFromIntOrPercent(&maxUnavailable, int(replicas), false)
+		if err != nil {
+			return 1
+		}
+		return int32(value)
+	case *appsv1.StatefulSet:
+		if obj.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+			return 0
+		}
+		return 1
+	case *appsv1.DaemonSet:
+		if obj.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+			return 0
+		}
+		maxUnavailable := intstr.FromInt(1)
+		if update := obj.Spec.UpdateStrategy.RollingUpdate; update != nil && update.MaxUnavailable != nil {
+			maxUnavailable = *update.MaxUnavailable
+		}
+		value, err := intstr.GetScaledValueFromIntOrPercent(&maxUnavailable, int(obj.Status.DesiredNumberScheduled), true)
+		if err != nil {
+			return 1
+		}
+		return int32(value)
+	}
+	return 1
+}
+
+// workloadReplicas returns how many pods the workload runs when all is well.
+func workloadReplicas(workload *Workload) int32 {
+	switch obj := workload.Object.(type) {
+	case *appsv1.Deployment:
+		if obj.Spec.Replicas != nil {
+			return *obj.Spec.Replicas
+		}
+	case *appsv1.StatefulSet:
+		if obj.Spec.Replicas != nil {
+			return *obj.Spec.Replicas
+		}
+	case *appsv1.DaemonSet:
+		return obj.Status.DesiredNumberScheduled
+	}
+	return 1
+}
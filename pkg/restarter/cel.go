@@ -0,0 +1,65 @@
+package restarter
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Variables CEL expressions see the object they are evaluated against as.
+const (
+	ExpressionPod      = "pod"
+	ExpressionWorkload = "workload"
+)
+
+// Expression is a compiled CEL expression over a Kubernetes object, e.g.
+// pod.status.containerStatuses.exists(c, c.restartCount > 5). The object is
+// seen as its JSON form, so fields have their JSON names.
+type Expression struct {
+	source  string
+	program cel.Program
+}
+
+// CompileExpression compiles source with the object bound to variable. It
+// must evaluate to a bool.
+func CompileExpression(source, variable string) (*Expression, error) {
+	env, err := cel.NewEnv(cel.Variable(variable, cel.DynType))
+	if err != nil {
+		return nil, fmt.Errorf("error creating the CEL environment: %v", err)
+	}
+	ast, issues := env.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, issues.Err())
+	}
+	if output := ast.OutputType(); !output.IsAssignableType(cel.BoolType) {
+		return nil, fmt.Errorf("invalid expression %q: evaluates to %s, not bool", source, output)
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	return &Expression{source: source, program: program}, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against obj, bound to variable. Errors, such
+// as a missing field, are returned and mean the expression does not hold.
+func (e *Expression) Eval(variable string, obj runtime.Object) (bool, error) {
+	value, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return false, fmt.Errorf("error converting %T: %v", obj, err)
+	}
+	out, _, err := e.program.Eval(map[string]interface{}{variable: value})
+	if err != nil {
+		return false, fmt.Errorf("error evaluating %q: %v", e.source, err)
+	}
+	result, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("%q evaluated to %v, not a bool", e.source, out.Value())
+	}
+	return result, nil
+}
@@ -0,0 +1,137 @@
+// Package restarter is the restart engine of the restarter CLI, for Go
+// programs that embed it instead of shelling out to the binary. It lists pods
+// and namespaces a page at a time and rollout-restarts Deployments,
+// StatefulSets and DaemonSets the way kubectl rollout restart does, repeating
+// API calls that fail with transient errors:
+//
+//	client := restarter.New(clientset, restarter.DefaultOptions())
+//	pods, err := client.ListPods(ctx, "shop", metav1.ListOptions{LabelSelector: "app=web"})
+//	...
+//	result, err := client.RestartDeployment(ctx, "shop", "web")
+package restarter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+)
+
+// Options tune how a Client talks to the API server.
+type Options struct {
+	// RequestTimeout bounds every individual API call; 0 disables it.
+	RequestTimeout time.Duration
+	// Retries is how many times a call that failed with a transient error
+	// is repeated, and RetryDelay the delay before the first repetition; it
+	// doubles after each one.
+	Retries    int
+	RetryDelay time.Duration
+	// PageSize is how many objects each List call asks for; 0 lists
+	// everything in one call.
+	PageSize int64
+	// Debugf, when set, is given debug messages, such as retried calls.
+	Debugf func(format string, args ...interface{})
+}
+
+// DefaultOptions returns the options the restarter CLI uses by default.
+func DefaultOptions() Options {
+	return Options{RequestTimeout: 30 * time.Second, Retries: 4, RetryDelay: 500 * time.Millisecond, PageSize: 500}
+}
+
+// Client lists and restarts workloads through a Kubernetes client.
+type Client struct {
+	Kubernetes kubernetes.Interface
+	// Metadata, when set, is the metadata client EachPodMetadata lists pods
+	// with. It must talk to the same cluster as Kubernetes.
+	Metadata metadata.Interface
+	Options  Options
+}
+
+// New returns a Client for the Kubernetes client.
+func New(client kubernetes.Interface, options Options) *Client {
+	return &Client{Kubernetes: client, Options: options}
+}
+
+func (o Options) debugf(format string, args ...interface{}) {
+	if o.Debugf != nil {
+		o.Debugf(format, args...)
+	}
+}
+
+// WithRequestTimeout derives the context for a single API call.
+func (o Options) WithRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.RequestTimeout)
+}
+
+// Call makes an API call, each attempt with its own request timeout.
+// Attempts that fail with a transient error are repeated with exponential
+// backoff, so an overloaded API server or a dropped connection does not fail
+// a whole namespace; other errors, such as 403 Forbidden or 404 Not Found,
+// are returned straight away. 403 Forbidden errors are marked ErrForbidden.
+func (o Options) Call(ctx context.Context, call func(context.Context) error) error {
+	delay := o.RetryDelay
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := o.WithRequestTimeout(ctx)
+		err := call(callCtx)
+		cancel()
+		if err == nil || attempt >= o.Retries || ctx.Err() != nil || !TransientError(err) {
+			return Forbidden(err)
+		}
+		o.debugf("Retrying the API call in %s: %v\n", delay, err)
+		select {
+		case <-ctx.Done():
+			return Forbidden(err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// TransientError reports whether an API call that failed with err may
+// succeed when repeated: 429 Too Many Requests, 5xx responses, timeouts and
+// broken connections.
+func TransientError(err error) bool {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		code := status.Status().Code
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+	if errors.Is(err, context.DeadlineExceeded) || utilnet.IsConnectionReset(err) || utilnet.IsConnectionRefused(err) || utilnet.IsProbableEOF(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// ListPages calls list for every page of a listing, following continue
+// tokens. Each page is a separate Call; list returns the continue token of
+// its page.
+func (o Options) ListPages(ctx context.Context, listOptions metav1.ListOptions, list func(context.Context, metav1.ListOptions) (string, error)) error {
+	listOptions.Limit = o.PageSize
+	for {
+		var next string
+		err := o.Call(ctx, func(ctx context.Context) error {
+			var err error
+			next, err = list(ctx, listOptions)
+			return err
+		})
+		if apierrors.IsResourceExpired(err) && listOptions.Continue != "" {
+			return fmt.Errorf("the list expired while paging through it, retry or raise the page size: %w", err)
+		}
+		if err != nil || next == "" {
+			return err
+		}
+		listOptions.Continue = next
+	}
+}
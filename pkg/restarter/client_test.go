@@ -0,0 +1,277 @@
+package restarter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var podsGroupResource = schema.GroupResource{Resource: "pods"}
+
+// testOptions retries quickly, so tests do not wait for backoff.
+func testOptions() Options {
+	return Options{Retries: 3, RetryDelay: time.Millisecond}
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name string
+		// errs are returned by the successive attempts; attempts past the
+		// end succeed.
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "success", wantCalls: 1},
+		{name: "too many requests then success", errs: []error{apierrors.NewTooManyRequests("slow down", 1)}, wantCalls: 2},
+		{name: "server errors then success", errs: []error{apierrors.NewInternalError(errors.New("boom")), apierrors.NewServiceUnavailable("down")}, wantCalls: 3},
+		{name: "broken connection then success", errs: []error{io.ErrUnexpectedEOF}, wantCalls: 2},
+		{name: "timeout then success", errs: []error{context.DeadlineExceeded}, wantCalls: 2},
+		{
+			name:      "retries exhausted",
+			errs:      []error{apierrors.NewInternalError(errors.New("1")), apierrors.NewInternalError(errors.New("2")), apierrors.NewInternalError(errors.New("3")), apierrors.NewInternalError(errors.New("4"))},
+			wantCalls: 4,
+			wantErr:   true,
+		},
+		{name: "not found is not retried", errs: []error{apierrors.NewNotFound(podsGroupResource, "web")}, wantCalls: 1, wantErr: true},
+		{name: "forbidden is not retried", errs: []error{apierrors.NewForbidden(podsGroupResource, "web", errors.New("denied"))}, wantCalls: 1, wantErr: true},
+		{name: "other errors are not retried", errs: []error{errors.New("invalid")}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := testOptions().Call(context.Background(), func(ctx context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("Call made %d attempts, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Call() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCallBackoff(t *testing.T) {
+	options := Options{Retries: 3, RetryDelay: 10 * time.Millisecond}
+	var attempts []time.Time
+	err := options.Call(context.Background(), func(ctx context.Context) error {
+		attempts = append(attempts, time.Now())
+		return apierrors.NewTooManyRequests("slow down", 1)
+	})
+	if err == nil {
+		t.Fatal("Call() succeeded, want the last error")
+	}
+	if len(attempts) != 4 {
+		t.Fatalf("Call made %d attempts, want 4", len(attempts))
+	}
+	// The delay doubles after each repetition: 10ms, 20ms, 40ms.
+	for i, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+		if got := attempts[i+1].Sub(attempts[i]); got < want {
+			t.Errorf("delay before attempt %d = %s, want at least %s", i+2, got, want)
+		}
+	}
+}
+
+func TestCallStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	options := Options{Retries: 5, RetryDelay: time.Hour}
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- options.Call(ctx, func(ctx context.Context) error {
+			calls++
+			return apierrors.NewServiceUnavailable("down")
+		})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !apierrors.IsServiceUnavailable(err) {
+			t.Errorf("Call() error = %v, want the last API error", err)
+		}
+		if calls != 1 {
+			t.Errorf("Call made %d attempts, want 1", calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Call kept waiting after its context was cancelled")
+	}
+}
+
+func TestCallRequestTimeout(t *testing.T) {
+	options := Options{RequestTimeout: time.Minute}
+	err := options.Call(context.Background(), func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return errors.New("no deadline")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Call() error = %v", err)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize int64
+		// pages maps the continue token a page is asked with to the token
+		// of the next page.
+		pages     map[string]string
+		wantCalls []string
+	}{
+		{name: "single page", pageSize: 500, pages: map[string]string{"": ""}, wantCalls: []string{""}},
+		{name: "three pages", pageSize: 2, pages: map[string]string{"": "a", "a": "b", "b": ""}, wantCalls: []string{"", "a", "b"}},
+		{name: "unpaged", pages: map[string]string{"": ""}, wantCalls: []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := testOptions()
+			options.PageSize = tt.pageSize
+			var calls []string
+			err := options.ListPages(context.Background(), metav1.ListOptions{LabelSelector: "app=web"}, func(ctx context.Context, listOptions metav1.ListOptions) (string, error) {
+				calls = append(calls, listOptions.Continue)
+				if listOptions.Limit != tt.pageSize {
+					t.Errorf("page asked with limit %d, want %d", listOptions.Limit, tt.pageSize)
+				}
+				if listOptions.LabelSelector != "app=web" {
+					t.Errorf("page asked with label selector %q, want app=web", listOptions.LabelSelector)
+				}
+				return tt.pages[listOptions.Continue], nil
+			})
+			if err != nil {
+				t.Fatalf("ListPages() error = %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("ListPages asked for pages %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestListPagesRetriesPages(t *testing.T) {
+	var calls []string
+	failed := false
+	err := testOptions().ListPages(context.Background(), metav1.ListOptions{}, func(ctx context.Context, listOptions metav1.ListOptions) (string, error) {
+		calls = append(calls, listOptions.Continue)
+		if listOptions.Continue == "a" && !failed {
+			failed = true
+			return "", apierrors.NewTooManyRequests("slow down", 1)
+		}
+		if listOptions.Continue == "" {
+			return "a", nil
+		}
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	if want := []string{"", "a", "a"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("ListPages asked for pages %q, want %q", calls, want)
+	}
+}
+
+func TestListPagesExpired(t *testing.T) {
+	expired := apierrors.NewResourceExpired("continue token expired")
+	err := testOptions().ListPages(context.Background(), metav1.ListOptions{}, func(ctx context.Context, listOptions metav1.ListOptions) (string, error) {
+		if listOptions.Continue == "" {
+			return "a", nil
+		}
+		return "", expired
+	})
+	if err == nil {
+		t.Fatal("ListPages() succeeded, want an error")
+	}
+	if !apierrors.IsResourceExpired(err) {
+		t.Errorf("ListPages() error = %v, want it to wrap the expired error", err)
+	}
+}
+
+func TestListPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "shop"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "shop"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-1", Namespace: "data"}},
+	)
+	client := New(clientset, testOptions())
+	pods, err := client.ListPods(context.Background(), "shop", metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListPods() error = %v", err)
+	}
+	var names []string
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	if want := []string{"web-1", "web-2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListPods() = %q, want %q", names, want)
+	}
+}
+
+func TestEachPodFollowsContinueTokens(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	// The fake clientset does not page, so the pages are served by hand:
+	// the first call returns a continue token, the second the last page.
+	calls := 0
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		list := &v1.PodList{Items: []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("web-%d", calls), Namespace: "shop"}}}}
+		if calls == 1 {
+			list.Continue = "next"
+		}
+		return true, list, nil
+	})
+	client := New(clientset, testOptions())
+	var names []string
+	err := client.EachPod(context.Background(), "shop", metav1.ListOptions{}, func(pod *v1.Pod) {
+		names = append(names, pod.Name)
+	})
+	if err != nil {
+		t.Fatalf("EachPod() error = %v", err)
+	}
+	if want := []string{"web-1", "web-2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("EachPod() visited %q, want %q", names, want)
+	}
+}
+
+func TestTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "too many requests", err: apierrors.NewTooManyRequests("slow down", 1), want: true},
+		{name: "internal error", err: apierrors.NewInternalError(errors.New("boom")), want: true},
+		{name: "service unavailable", err: apierrors.NewServiceUnavailable("down"), want: true},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: true},
+		{name: "wrapped deadline exceeded", err: fmt.Errorf("listing: %w", context.DeadlineExceeded), want: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: true},
+		{name: "not found", err: apierrors.NewNotFound(podsGroupResource, "web")},
+		{name: "forbidden", err: apierrors.NewForbidden(podsGroupResource, "web", errors.New("denied"))},
+		{name: "conflict", err: apierrors.NewConflict(podsGroupResource, "web", errors.New("changed"))},
+		{name: "cancelled", err: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TransientError(tt.err); got != tt.want {
+				t.Errorf("TransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package restarter
+
+import (
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Kinds of errors callers can branch on with errors.Is. The errors this
+// package returns wrap them when they apply, and still wrap the API error, so
+// its status stays reachable with errors.As.
+var (
+	// ErrNoOwnerFound means a pod has no live workload controlling it: it
+	// never had a controller, or the controller no longer exists.
+	ErrNoOwnerFound = errors.New("no owning workload found")
+	// ErrRestartConflict means the API server rejected a restart with 409
+	// Conflict, e.g. because the workload changed at the same time.
+	ErrRestartConflict = errors.New("restart conflict")
+	// ErrForbidden means the API server denied a call with 403 Forbidden:
+	// the client lacks a permission.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Error kinds as ErrorKind names them.
+const (
+	ErrorKindNoOwner   = "no-owner"
+	ErrorKindConflict  = "conflict"
+	ErrorKindForbidden = "forbidden"
+)
+
+// kindError is an error of one of the kinds above. It reads as err.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// WithKind returns err marked as of kind, such as ErrNoOwnerFound, so
+// errors.Is(err, kind) holds. It reads as err.
+func WithKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// Forbidden marks err with ErrForbidden when it is a 403 Forbidden API error.
+func Forbidden(err error) error {
+	if apierrors.IsForbidden(err) {
+		return WithKind(ErrForbidden, err)
+	}
+	return err
+}
+
+// ErrorKind names the kind of err for reports: ErrorKindNoOwner,
+// ErrorKindConflict, ErrorKindForbidden, or "" for other errors.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrNoOwnerFound):
+		return ErrorKindNoOwner
+	case errors.Is(err, ErrRestartConflict):
+		return ErrorKindConflict
+	case errors.Is(err, ErrForbidden):
+		return ErrorKindForbidden
+	}
+	return ""
+}
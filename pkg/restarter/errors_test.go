@@ -0,0 +1,145 @@
+package restarter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestErrorKind(t *testing.T) {
+	forbidden := apierrors.NewForbidden(podsGroupResource, "web", errors.New("denied"))
+	tests := []struct {
+		name string
+		err  error
+		// is is the kind errors.Is must find, nil for none.
+		is   error
+		want string
+	}{
+		{name: "nil", err: nil, want: ""},
+		{name: "plain error", err: errors.New("boom"), want: ""},
+		{name: "no owner", err: WithKind(ErrNoOwnerFound, errors.New("pod web has no controller")), is: ErrNoOwnerFound, want: ErrorKindNoOwner},
+		{name: "conflict", err: WithKind(ErrRestartConflict, errors.New("changed")), is: ErrRestartConflict, want: ErrorKindConflict},
+		{name: "forbidden API error", err: Forbidden(forbidden), is: ErrForbidden, want: ErrorKindForbidden},
+		{name: "wrapped kind", err: fmt.Errorf("restarting web: %w", WithKind(ErrRestartConflict, errors.New("changed"))), is: ErrRestartConflict, want: ErrorKindConflict},
+		{name: "unmarked forbidden API error", err: forbidden, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorKind(tt.err); got != tt.want {
+				t.Errorf("ErrorKind(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+			for _, kind := range []error{ErrNoOwnerFound, ErrRestartConflict, ErrForbidden} {
+				if got, want := errors.Is(tt.err, kind), kind == tt.is; got != want {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, kind, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithKind(t *testing.T) {
+	if err := WithKind(ErrForbidden, nil); err != nil {
+		t.Errorf("WithKind(kind, nil) = %v, want nil", err)
+	}
+	inner := apierrors.NewConflict(podsGroupResource, "web", errors.New("changed"))
+	err := WithKind(ErrRestartConflict, inner)
+	if err.Error() != inner.Error() {
+		t.Errorf("WithKind() reads %q, want %q", err.Error(), inner.Error())
+	}
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || !apierrors.IsConflict(err) {
+		t.Errorf("WithKind() hides the API status of %v", inner)
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	notFound := apierrors.NewNotFound(podsGroupResource, "web")
+	if err := Forbidden(notFound); err != notFound {
+		t.Errorf("Forbidden(%v) = %v, want it unchanged", notFound, err)
+	}
+	if err := Forbidden(nil); err != nil {
+		t.Errorf("Forbidden(nil) = %v, want nil", err)
+	}
+}
+
+// TestClientErrors checks the kinds of the errors Client methods return for
+// API errors.
+func TestClientErrors(t *testing.T) {
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	tests := []struct {
+		name     string
+		verb     string
+		resource string
+		apiErr   error
+		call     func(*Client) error
+		is       error
+		want     string
+	}{
+		{
+			name: "listing pods forbidden", verb: "list", resource: "pods",
+			apiErr: apierrors.NewForbidden(podsGroupResource, "", errors.New("denied")),
+			call: func(c *Client) error {
+				_, err := c.ListPods(context.Background(), "shop", metav1.ListOptions{})
+				return err
+			},
+			is: ErrForbidden, want: ErrorKindForbidden,
+		},
+		{
+			name: "restart conflict", verb: "patch", resource: "deployments",
+			apiErr: apierrors.NewConflict(deployments, "web", errors.New("changed")),
+			call: func(c *Client) error {
+				_, err := c.RestartDeployment(context.Background(), "shop", "web")
+				return err
+			},
+			is: ErrRestartConflict, want: ErrorKindConflict,
+		},
+		{
+			name: "restart forbidden", verb: "patch", resource: "deployments",
+			apiErr: apierrors.NewForbidden(deployments, "web", errors.New("denied")),
+			call: func(c *Client) error {
+				_, err := c.RestartDeployment(context.Background(), "shop", "web")
+				return err
+			},
+			is: ErrForbidden, want: ErrorKindForbidden,
+		},
+		{
+			name: "restart of a missing workload", verb: "patch", resource: "deployments",
+			apiErr: apierrors.NewNotFound(deployments, "web"),
+			call: func(c *Client) error {
+				_, err := c.RestartDeployment(context.Background(), "shop", "web")
+				return err
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor(tt.verb, tt.resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tt.apiErr
+			})
+			err := tt.call(New(clientset, testOptions()))
+			if err == nil {
+				t.Fatal("call succeeded, want an error")
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("errors.Is(%v, %v) = false, want true", err, tt.is)
+			}
+			if got := ErrorKind(err); got != tt.want {
+				t.Errorf("ErrorKind(%v) = %q, want %q", err, got, tt.want)
+			}
+			var status apierrors.APIStatus
+			if !errors.As(err, &status) {
+				t.Errorf("error %v does not wrap the API error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package restarter
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podsResource is the resource the metadata client lists pods as.
+var podsResource = v1.SchemeGroupVersion.WithResource("pods")
+
+// EachPod calls fn for every pod of the namespace, a page at a time, so
+// only one page of pods is held in memory. metav1.NamespaceAll lists the
+// pods of every namespace.
+func (c *Client) EachPod(ctx context.Context, namespace string, listOptions metav1.ListOptions, fn func(*v1.Pod)) error {
+	c.Options.debugf("Listing pods in namespace %s\n", namespace)
+	err := c.Options.ListPages(ctx, listOptions, func(ctx context.Context, listOptions metav1.ListOptions) (string, error) {
+		pods, err := c.Kubernetes.CoreV1().Pods(namespace).List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		for i := range pods.Items {
+			// A copy, so a pod fn keeps does not keep its whole page.
+			pod := pods.Items[i]
+			fn(&pod)
+		}
+		return pods.Continue, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error getting pods: %w", err)
+	}
+	return nil
+}
+
+// EachPodMetadata is EachPod for callers that only look at pod metadata. The
+// pods are listed with the Metadata client as PartialObjectMetadata, without
+// the spec and status that make up most of their size, and fn gets pods with
+// only ObjectMeta set.
+func (c *Client) EachPodMetadata(ctx context.Context, namespace string, listOptions metav1.ListOptions, fn func(*v1.Pod)) error {
+	if c.Metadata == nil {
+		return fmt.Errorf("error getting pods: no metadata client")
+	}
+	c.Options.debugf("Listing pod metadata in namespace %s\n", namespace)
+	pods := c.Metadata.Resource(podsResource).Namespace(namespace)
+	err := c.Options.ListPages(ctx, listOptions, func(ctx context.Context, listOptions metav1.ListOptions) (string, error) {
+		list, err := pods.List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		for i := range list.Items {
+			fn(&v1.Pod{ObjectMeta: list.Items[i].ObjectMeta})
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error getting pods: %w", err)
+	}
+	return nil
+}
+
+// ListPods lists the pods of the namespace.
+func (c *Client) ListPods(ctx context.Context, namespace string, listOptions metav1.ListOptions) (*v1.PodList, error) {
+	pods := &v1.PodList{}
+	err := c.EachPod(ctx, namespace, listOptions, func(pod *v1.Pod) {
+		pods.Items = append(pods.Items, *pod)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods, nil
+}
+
+// ListPodEvents lists the Events involving pods in the namespace, keyed by
+// pod UID.
+func (c *Client) ListPodEvents(ctx context.Context, namespace string) (map[types.UID][]v1.Event, error) {
+	c.Options.debugf("Listing pod events in namespace %s\n", namespace)
+	byPod := map[types.UID][]v1.Event{}
+	err := c.Options.ListPages(ctx, metav1.ListOptions{FieldSelector: "involvedObject.kind=Pod"}, func(ctx context.Context, listOptions metav1.ListOptions) (string, error) {
+		events, err := c.Kubernetes.CoreV1().Events(namespace).List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		for _, event := range events.Items {
+			byPod[event.InvolvedObject.UID] = append(byPod[event.InvolvedObject.UID], event)
+		}
+		return events.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting events: %w", err)
+	}
+	return byPod, nil
+}
+
+// ListNamespaces lists the namespaces the list options select.
+func (c *Client) ListNamespaces(ctx context.Context, listOptions metav1.ListOptions) (*v1.NamespaceList, error) {
+	c.Options.debugf("Listing namespaces\n")
+	namespaces := &v1.NamespaceList{}
+	err := c.Options.ListPages(ctx, listOptions, func(ctx context.Context, listOptions metav1.ListOptions) (string, error) {
+		page, err := c.Kubernetes.CoreV1().Namespaces().List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		namespaces.Items = append(namespaces.Items, page.Items...)
+		return page.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting namespaces: %w", err)
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,156 @@
+package restarter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Matcher decides whether a pod is a restart candidate.
+type Matcher interface {
+	Match(pod *v1.Pod) bool
+}
+
+// MatcherFunc adapts a function to a Matcher.
+type MatcherFunc func(pod *v1.Pod) bool
+
+// Match calls f(pod).
+func (f MatcherFunc) Match(pod *v1.Pod) bool {
+	return f(pod)
+}
+
+// SubstringMatcher matches pods whose name contains Substring.
+type SubstringMatcher struct {
+	Substring string
+}
+
+func (m SubstringMatcher) Match(pod *v1.Pod) bool {
+	return strings.Contains(pod.Name, m.Substring)
+}
+
+// RegexMatcher matches pods whose name matches any of Patterns.
+type RegexMatcher struct {
+	Patterns []*regexp.Regexp
+}
+
+func (m RegexMatcher) Match(pod *v1.Pod) bool {
+	for _, re := range m.Patterns {
+		if re.MatchString(pod.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelMatcher matches pods whose labels Selector selects. Listing pods with
+// the selector is cheaper; LabelMatcher is for pods already listed.
+type LabelMatcher struct {
+	Selector labels.Selector
+}
+
+func (m LabelMatcher) Match(pod *v1.Pod) bool {
+	return m.Selector.Matches(labels.Set(pod.Labels))
+}
+
+// CELMatcher matches pods for which Expression, over the pod bound to
+// ExpressionPod, holds. Evaluation errors mean no match.
+type CELMatcher struct {
+	Expression *Expression
+}
+
+func (m CELMatcher) Match(pod *v1.Pod) bool {
+	matched, _ := m.Expression.Eval(ExpressionPod, pod)
+	return matched
+}
+
+// AllOf matches pods every one of matchers matches.
+func AllOf(matchers ...Matcher) Matcher {
+	return MatcherFunc(func(pod *v1.Pod) bool {
+		for _, m := range matchers {
+			if !m.Match(pod) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// MatcherFactory builds a Matcher from its textual argument, e.g. the
+// regular expression of a regex matcher.
+type MatcherFactory func(arg string) (Matcher, error)
+
+// Built-in matcher types, as NewMatcher names them.
+const (
+	MatcherSubstring = "substring"
+	MatcherRegex     = "regex"
+	MatcherLabel     = "label"
+	MatcherCEL       = "cel"
+)
+
+var (
+	matchersMu sync.RWMutex
+	matchers   = map[string]MatcherFactory{
+		MatcherSubstring: func(arg string) (Matcher, error) {
+			return SubstringMatcher{Substring: arg}, nil
+		},
+		MatcherRegex: func(arg string) (Matcher, error) {
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", arg, err)
+			}
+			return RegexMatcher{Patterns: []*regexp.Regexp{re}}, nil
+		},
+		MatcherLabel: func(arg string) (Matcher, error) {
+			selector, err := labels.Parse(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid label selector %q: %v", arg, err)
+			}
+			return LabelMatcher{Selector: selector}, nil
+		},
+		MatcherCEL: func(arg string) (Matcher, error) {
+			expression, err := CompileExpression(arg, ExpressionPod)
+			if err != nil {
+				return nil, err
+			}
+			return CELMatcher{Expression: expression}, nil
+		},
+	}
+)
+
+// RegisterMatcher makes a custom matcher type available to NewMatcher, and
+// so to the matchers of rules, under name. Registering a name again replaces
+// its factory, built-in types included. Programs embedding restarter register
+// their matchers before loading rules.
+func RegisterMatcher(name string, factory MatcherFactory) {
+	matchersMu.Lock()
+	defer matchersMu.Unlock()
+	matchers[name] = factory
+}
+
+// NewMatcher builds a matcher of the registered type name from arg.
+func NewMatcher(name, arg string) (Matcher, error) {
+	matchersMu.RLock()
+	factory, ok := matchers[name]
+	matchersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown matcher type %q (want one of %s)", name, strings.Join(MatcherTypes(), ", "))
+	}
+	return factory(arg)
+}
+
+// MatcherTypes lists the registered matcher types, sorted.
+func MatcherTypes() []string {
+	matchersMu.RLock()
+	defer matchersMu.RUnlock()
+	names := make([]string, 0, len(matchers))
+	for name := range matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,100 @@
+package restarter
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewMatcher(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-5d8f-x2k4q", Namespace: "shop", Labels: map[string]string{"app": "web", "tier": "frontend"}},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	tests := []struct {
+		typ, arg string
+		want     bool
+		wantErr  bool
+	}{
+		{typ: MatcherSubstring, arg: "5d8f", want: true},
+		{typ: MatcherSubstring, arg: "db", want: false},
+		{typ: MatcherRegex, arg: "^web-", want: true},
+		{typ: MatcherRegex, arg: "^db-", want: false},
+		{typ: MatcherRegex, arg: "(", wantErr: true},
+		{typ: MatcherLabel, arg: "app=web,tier in (frontend)", want: true},
+		{typ: MatcherLabel, arg: "app!=web", want: false},
+		{typ: MatcherLabel, arg: "app in (", wantErr: true},
+		{typ: MatcherCEL, arg: `pod.status.phase == "Running"`, want: true},
+		{typ: MatcherCEL, arg: `pod.metadata.namespace == "data"`, want: false},
+		{typ: MatcherCEL, arg: "pod.", wantErr: true},
+		{typ: "unknown", arg: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+":"+tt.arg, func(t *testing.T) {
+			matcher, err := NewMatcher(tt.typ, tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMatcher(%q, %q) error = %v, want error %v", tt.typ, tt.arg, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := matcher.Match(pod); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllOf(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}}
+	yes := MatcherFunc(func(*v1.Pod) bool { return true })
+	no := MatcherFunc(func(*v1.Pod) bool { return false })
+	tests := []struct {
+		name     string
+		matchers []Matcher
+		want     bool
+	}{
+		{name: "none", want: true},
+		{name: "all match", matchers: []Matcher{yes, yes}, want: true},
+		{name: "one does not match", matchers: []Matcher{yes, no}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllOf(tt.matchers...).Match(pod); got != tt.want {
+				t.Errorf("AllOf().Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterMatcher(t *testing.T) {
+	const name = "test-prefix"
+	RegisterMatcher(name, func(arg string) (Matcher, error) {
+		return MatcherFunc(func(pod *v1.Pod) bool { return strings.HasPrefix(pod.Name, arg) }), nil
+	})
+	t.Cleanup(func() {
+		matchersMu.Lock()
+		delete(matchers, name)
+		matchersMu.Unlock()
+	})
+
+	found := false
+	for _, typ := range MatcherTypes() {
+		found = found || typ == name
+	}
+	if !found {
+		t.Errorf("MatcherTypes() = %q, want it to list %s", MatcherTypes(), name)
+	}
+	matcher, err := NewMatcher(name, "web-")
+	if err != nil {
+		t.Fatalf("NewMatcher() error = %v", err)
+	}
+	if !matcher.Match(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}}) {
+		t.Error("registered matcher did not match web-1")
+	}
+	if matcher.Match(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-1"}}) {
+		t.Error("registered matcher matched db-1")
+	}
+}
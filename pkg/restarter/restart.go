@@ -0,0 +1,118 @@
+package restarter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// Workload kinds that can be restarted.
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+)
+
+// RestartedAtAnnotation is the pod template annotation kubectl rollout
+// restart sets to trigger a new rollout.
+const RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// FieldManager is the server-side apply field manager restarter sets the
+// restartedAt annotation with. It owns nothing else, so applying it cannot
+// conflict with the fields other controllers and GitOps tools own, and the
+// workload's managedFields tell which rollouts restarter started.
+const FieldManager = "restarter"
+
+// RestartResult describes a restart.
+type RestartResult struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// RestartedAt is the restartedAt annotation the restart applied, in
+	// RFC 3339.
+	RestartedAt string
+}
+
+// RestartDeployment rollout-restarts a Deployment.
+func (c *Client) RestartDeployment(ctx context.Context, namespace, name string) (*RestartResult, error) {
+	return c.Restart(ctx, KindDeployment, namespace, name)
+}
+
+// RestartStatefulSet rollout-restarts a StatefulSet.
+func (c *Client) RestartStatefulSet(ctx context.Context, namespace, name string) (*RestartResult, error) {
+	return c.Restart(ctx, KindStatefulSet, namespace, name)
+}
+
+// RestartDaemonSet rollout-restarts a DaemonSet.
+func (c *Client) RestartDaemonSet(ctx context.Context, namespace, name string) (*RestartResult, error) {
+	return c.Restart(ctx, KindDaemonSet, namespace, name)
+}
+
+// Restart rollout-restarts the workload of the kind, like kubectl rollout
+// restart, by setting its pod template's restartedAt annotation to now. A
+// restart rejected with 409 Conflict is marked ErrRestartConflict.
+func (c *Client) Restart(ctx context.Context, kind, namespace, name string) (*RestartResult, error) {
+	now := time.Now().Format(time.RFC3339)
+	if err := c.ApplyRestartedAt(ctx, kind, namespace, name, &now); err != nil {
+		if apierrors.IsConflict(err) {
+			err = WithKind(ErrRestartConflict, err)
+		}
+		return nil, fmt.Errorf("error applying %s: %w", strings.ToLower(kind), err)
+	}
+	return &RestartResult{Kind: kind, Namespace: namespace, Name: name, RestartedAt: now}, nil
+}
+
+// ApplyRestartedAt server-side applies the restartedAt pod template
+// annotation of a workload. A nil value releases the annotation, which the
+// API server then removes unless another manager also sets it; restoring the
+// value before a restart rolls it back. Applies are forced, taking the
+// annotation over from kubectl rollout restart.
+func (c *Client) ApplyRestartedAt(ctx context.Context, kind, namespace, name string, value *string) error {
+	var template *corev1apply.PodTemplateSpecApplyConfiguration
+	if value != nil {
+		template = corev1apply.PodTemplateSpec().WithAnnotations(map[string]string{RestartedAtAnnotation: *value})
+	}
+	options := metav1.ApplyOptions{FieldManager: FieldManager, Force: true}
+
+	apps := c.Kubernetes.AppsV1()
+	var apply func(context.Context) error
+	switch kind {
+	case KindDeployment:
+		deployment := appsv1apply.Deployment(name, namespace)
+		if template != nil {
+			deployment.WithSpec(appsv1apply.DeploymentSpec().WithTemplate(template))
+		}
+		apply = func(ctx context.Context) error {
+			_, err := apps.Deployments(namespace).Apply(ctx, deployment, options)
+			return err
+		}
+	case KindStatefulSet:
+		statefulSet := appsv1apply.StatefulSet(name, namespace)
+		if template != nil {
+			statefulSet.WithSpec(appsv1apply.StatefulSetSpec().WithTemplate(template))
+		}
+		apply = func(ctx context.Context) error {
+			_, err := apps.StatefulSets(namespace).Apply(ctx, statefulSet, options)
+			return err
+		}
+	case KindDaemonSet:
+		daemonSet := appsv1apply.DaemonSet(name, namespace)
+		if template != nil {
+			daemonSet.WithSpec(appsv1apply.DaemonSetSpec().WithTemplate(template))
+		}
+		apply = func(ctx context.Context) error {
+			_, err := apps.DaemonSets(namespace).Apply(ctx, daemonSet, options)
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported workload kind %s", kind)
+	}
+	// Applies are idempotent, so repeating one is safe.
+	return c.Options.Call(ctx, apply)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// OPAPolicy asks an Open Policy Agent server, through its Data API, whether a
+// restart is allowed, so security teams can govern automated restarts
+// centrally with Rego. The policy the URL points at, e.g.
+// http://localhost:8181/v1/data/restarter/allow, decides with either a bool
+// or an object {"allow": bool, "reason": string}. A nil OPAPolicy allows
+// everything.
+type OPAPolicy struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// PolicyInput is the input document of a policy decision.
+type PolicyInput struct {
+	// Action is restart or evict.
+	Action  string `json:"action"`
+	Cluster string `json:"cluster,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+	Trigger string `json:"trigger,omitempty"`
+	// Source says what selected the workload, e.g. "pod web-5d8f".
+	Source string `json:"source"`
+	DryRun bool   `json:"dryRun"`
+	// Workload is the Deployment, StatefulSet or DaemonSet as JSON.
+	Workload map[string]interface{} `json:"workload"`
+	// NamespaceAnnotations are the annotations of the workload's namespace.
+	NamespaceAnnotations map[string]string `json:"namespaceAnnotations,omitempty"`
+}
+
+// newPolicyInput builds the input document for a workload. Typed objects read
+// from the API server have no kind, so it is filled in.
+func newPolicyInput(w *Workload) (PolicyInput, error) {
+	workload, err := runtime.DefaultUnstructuredConverter.ToUnstructured(w.Object)
+	if err != nil {
+		return PolicyInput{}, fmt.Errorf("error converting %s: %v", w, err)
+	}
+	workload["apiVersion"], workload["kind"] = "apps/v1", w.Kind
+	return PolicyInput{Workload: workload}, nil
+}
+
+// Decide queries the policy and returns whether the restart is allowed and,
+// if the policy gives one, why. An undefined decision denies.
+func (p *OPAPolicy) Decide(ctx context.Context, input PolicyInput) (bool, string, error) {
+	if p == nil {
+		return true, "", nil
+	}
+	data, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return false, "", fmt.Errorf("error encoding the policy input: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, "", fmt.Errorf("error creating the policy request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := p.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("error querying the policy: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRequestBody))
+	if err != nil {
+		return false, "", fmt.Errorf("error reading the policy decision: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, "", fmt.Errorf("error querying the policy: %s: %s", resp.Status, strings.TrimSpace(truncate(string(body), 512)))
+	}
+
+	var response struct {
+		Result *json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false, "", fmt.Errorf("invalid policy decision: %v", err)
+	}
+	if response.Result == nil {
+		return false, "decision undefined", nil
+	}
+	var allowed bool
+	if err := json.Unmarshal(*response.Result, &allowed); err == nil {
+		return allowed, "", nil
+	}
+	var decision struct {
+		Allow  *bool  `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(*response.Result, &decision); err != nil || decision.Allow == nil {
+		return false, "", fmt.Errorf("invalid policy decision %s: want a bool or {\"allow\": bool}", truncate(string(*response.Result), 512))
+	}
+	return *decision.Allow, decision.Reason, nil
+}
+
+// checkPolicy asks the policy whether the workload may be restarted and
+// returns why not, or "" when it may.
+func (r *Runner) checkPolicy(ctx context.Context, rule *Rule, workload *Workload, source string, result Result, nsAnnotations map[string]string) (string, error) {
+	input, err := newPolicyInput(workload)
+	if err != nil {
+		return "", err
+	}
+	input.Action, input.Cluster, input.Rule, input.Trigger = r.action(rule), result.Cluster, rule.Name, result.Trigger
+	input.Source, input.DryRun, input.NamespaceAnnotations = source, r.Options.DryRun, nsAnnotations
+	allowed, reason, err := r.Options.Policy.Decide(ctx, input)
+	if err != nil || allowed {
+		return "", err
+	}
+	if reason == "" {
+		reason = "not allowed"
+	}
+	return "policy: " + reason, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprof serves the net/http/pprof handlers under /debug/pprof/ for as
+// long as the process runs. They are not authenticated; bind them to
+// localhost and use kubectl port-forward.
+func startPprof(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	infof("Serving pprof at %s/debug/pprof/\n", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			errorf("Error serving pprof: %v\n", err)
+		}
+	}()
+	return nil
+}
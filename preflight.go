@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// permission is an API access a run needs. An empty Namespace means all
+// namespaces, or a cluster-scoped resource.
+type permission struct {
+	Verb      string
+	Group     string
+	Resource  string
+	Namespace string
+}
+
+func (p permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	switch {
+	case p.Resource == "namespaces":
+		return p.Verb + " " + resource
+	case p.Namespace == "":
+		return p.Verb + " " + resource + " in all namespaces"
+	}
+	return p.Verb + " " + resource + " in namespace " + p.Namespace
+}
+
+// requiredPermissions returns what a run of the rules needs: reading the
+// namespaces, pods and events it scans and the workloads owning them and,
+// unless it only reports, changing them. Rules that name their namespaces
+// need access to those only. cached adds the list and watch the informers of
+// watch --cache need.
+func requiredPermissions(rules []Rule, options RunOptions, cached bool) []permission {
+	seen := map[permission]bool{}
+	var permissions []permission
+	add := func(namespace, group, resource string, verbs ...string) {
+		for _, verb := range verbs {
+			p := permission{Verb: verb, Group: group, Resource: resource, Namespace: namespace}
+			if !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
+			}
+		}
+	}
+
+	clusterScope := options.Scope != ScopeNamespace
+	acts := !options.DryRun
+	for i := range rules {
+		rule := &rules[i]
+		namespaces := rule.Namespaces
+		if clusterScope && (len(namespaces) == 0 || rule.NamespaceSelector != "" || options.ClusterWideList || cached) {
+			// Pods are listed, or cached, across the cluster.
+			namespaces = []string{""}
+		}
+		if clusterScope {
+			add("", "", "namespaces", "get")
+			if len(rule.Namespaces) == 0 || rule.NamespaceSelector != "" {
+				add("", "", "namespaces", "list")
+			}
+			if cached {
+				add("", "", "namespaces", "list", "watch")
+			}
+		}
+		for _, namespace := range namespaces {
+			add(namespace, "", "pods", "list")
+			if rule.triggers.NeedsEvents() {
+				add(namespace, "", "events", "list")
+			}
+			add(namespace, "apps", "replicasets", "get")
+			add(namespace, "apps", "deployments", "get")
+			add(namespace, "apps", "statefulsets", "get")
+			add(namespace, "apps", "daemonsets", "get")
+			if cached {
+				add(namespace, "", "pods", "watch")
+				add(namespace, "apps", "replicasets", "list", "watch")
+				add(namespace, "apps", "deployments", "list", "watch")
+				add(namespace, "apps", "statefulsets", "list", "watch")
+				add(namespace, "apps", "daemonsets", "list", "watch")
+			}
+			if !acts || rule.Action == ActionReport {
+				continue
+			}
+			strategy := options.Strategy
+			if rule.Strategy != "" {
+				strategy = rule.Strategy
+			}
+			switch {
+			case options.Operation == OperationPause || options.Operation == OperationResume:
+				add(namespace, "apps", "deployments", "patch")
+			case strategy == StrategyEvict:
+				add(namespace, "", "pods/eviction", "create")
+				add(namespace, "", "pods", "get")
+			case strategy == StrategyDelete:
+				add(namespace, "", "pods", "delete")
+				add(namespace, "", "pods", "get")
+			case strategy == StrategyScaleCycle:
+				add(namespace, "apps", "deployments/scale", "get")
+				add(namespace, "apps", "deployments/scale", "update")
+				add(namespace, "apps", "statefulsets/scale", "get")
+				add(namespace, "apps", "statefulsets/scale", "update")
+			default:
+				add(namespace, "apps", "deployments", "patch")
+				add(namespace, "apps", "statefulsets", "patch")
+				add(namespace, "apps", "daemonsets", "patch")
+			}
+			if options.RequireApproval || len(options.Annotations) > 0 {
+				add(namespace, "apps", "deployments", "patch")
+				add(namespace, "apps", "statefulsets", "patch")
+				add(namespace, "apps", "daemonsets", "patch")
+			}
+			if rule.hasExecHooks() {
+				add(namespace, "", "pods/exec", "create")
+			}
+			if options.PDBCheck != PDBCheckOff {
+				add(namespace, "policy", "poddisruptionbudgets", "list")
+			}
+			if options.RecordEvents {
+				add(namespace, "", "events", "create")
+			}
+			switch {
+			case options.EvictOrphans:
+				add(namespace, "", "pods/eviction", "create")
+			case options.DeleteOrphans:
+				add(namespace, "", "pods", "delete")
+			}
+		}
+	}
+	if options.History != nil {
+		add(options.History.Namespace, "", "configmaps", "get")
+		if acts {
+			add(options.History.Namespace, "", "configmaps", "create", "patch")
+		}
+	}
+	if options.Freeze != nil {
+		add(options.Freeze.Namespace, "", "configmaps", "get")
+	}
+	return permissions
+}
+
+// Preflight asks the API server with SelfSubjectAccessReviews whether the
+// client has every permission a run of the rules needs, so a run missing
+// some fails before acting on anything rather than halfway through. The
+// error lists every missing permission.
+func Preflight(ctx context.Context, client kubernetes.Interface, rules []Rule, options RunOptions, cached bool) error {
+	permissions := requiredPermissions(rules, options, cached)
+	debugf("Checking %d permissions\n", len(permissions))
+	missing := make([]string, len(permissions))
+	errs := make([]error, len(permissions))
+	forEach(len(permissions), options.ScanConcurrency, func(i int) {
+		p := permissions[i]
+		resource, subresource, _ := strings.Cut(p.Resource, "/")
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.Namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    resource,
+					Subresource: subresource,
+				},
+			},
+		}
+		var result *authorizationv1.SelfSubjectAccessReview
+		err := callAPI(ctx, func(ctx context.Context) error {
+			var err error
+			result, err = client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		if !result.Status.Allowed {
+			missing[i] = p.String()
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("error checking permissions: %v", err)
+		}
+	}
+
+	var denied []string
+	for _, p := range missing {
+		if p != "" {
+			denied = append(denied, p)
+		}
+	}
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return fmt.Errorf("missing permissions, grant them or pass --preflight=false:\n  %s", strings.Join(denied, "\n  "))
+	}
+	return nil
+}
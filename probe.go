@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Annotations that ask for a health check of a workload's application after
+// its rollout finished.
+const (
+	// AnnotationHealthCheck is an http://, https:// or tcp://host:port
+	// endpoint that must answer once the rollout finished.
+	AnnotationHealthCheck = "restarter.io/health-check"
+	// AnnotationHealthCheckTimeout overrides DefaultHealthCheckTimeout, e.g.
+	// "5m".
+	AnnotationHealthCheckTimeout = "restarter.io/health-check-timeout"
+)
+
+// DefaultHealthCheckTimeout is how long a health check is retried before it
+// fails.
+const DefaultHealthCheckTimeout = 2 * time.Minute
+
+// probeInterval is how often a failing health check is retried, and bounds
+// each attempt.
+const probeInterval = 5 * time.Second
+
+// ValidateProbe checks a health check endpoint: an http or https URL, or
+// tcp://host:port.
+func ValidateProbe(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("%q has no host", endpoint)
+		}
+	case "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return fmt.Errorf("%q is not tcp://host:port", endpoint)
+		}
+	default:
+		return fmt.Errorf("%q is not an http(s) or tcp URL", endpoint)
+	}
+	return nil
+}
+
+// WaitForProbe retries the endpoint until it is healthy or timeout elapses.
+// HTTP endpoints must answer GET with a 2xx status; TCP endpoints must accept
+// a connection.
+func WaitForProbe(ctx context.Context, endpoint string, timeout time.Duration) error {
+	if err := ValidateProbe(endpoint); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := probe(ctx, endpoint)
+		if err == nil {
+			return nil
+		}
+		debugf("Health check of %s failed, retrying: %v\n", endpoint, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("health check of %s did not pass within %s: %v", endpoint, timeout, err)
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// probe checks the endpoint once.
+func probe(ctx context.Context, endpoint string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeInterval)
+	defer cancel()
+	if strings.HasPrefix(endpoint, "tcp://") {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", strings.TrimPrefix(endpoint, "tcp://"))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("error creating the request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// healthCheck returns the health check endpoint and timeout a workload is
+// annotated with, or "" when it has none or its annotations are invalid.
+func healthCheck(workload *Workload) (string, time.Duration) {
+	endpoint := workload.Annotations[AnnotationHealthCheck]
+	if endpoint == "" {
+		return "", 0
+	}
+	if err := ValidateProbe(endpoint); err != nil {
+		warnf("Ignoring invalid %s annotation of %s: %v\n", AnnotationHealthCheck, workload, err)
+		return "", 0
+	}
+	timeout := DefaultHealthCheckTimeout
+	if value, ok := workload.Annotations[AnnotationHealthCheckTimeout]; ok {
+		parsed, err := ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			warnf("Ignoring invalid %s annotation value %q of %s\n", AnnotationHealthCheckTimeout, value, workload)
+		} else {
+			timeout = parsed
+		}
+	}
+	return endpoint, timeout
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NamespaceLimiter caps the restarts in each namespace with a token bucket
+// per namespace, so a noisy namespace can neither use up the whole restart
+// budget nor destabilize itself. A bucket holds Burst tokens, starts full and
+// refills at Burst tokens per Per; every restart takes one. It is shared by
+// the runs of a process, so limits hold across watch scans. A nil
+// NamespaceLimiter limits nothing.
+type NamespaceLimiter struct {
+	Burst int
+	Per   time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket is the state of one namespace's bucket as of updated.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// ParseNamespaceLimiter parses a limit given as N/DURATION, e.g. "5/1h" for at
+// most 5 restarts per namespace in any hour.
+func ParseNamespaceLimiter(spec string) (*NamespaceLimiter, error) {
+	count, per, found := strings.Cut(spec, "/")
+	if !found {
+		return nil, fmt.Errorf("%q is not N/DURATION", spec)
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst < 1 {
+		return nil, fmt.Errorf("%q is not a positive number of restarts", count)
+	}
+	duration, err := ParseDuration(per)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("%q is not a positive duration", per)
+	}
+	return &NamespaceLimiter{Burst: burst, Per: duration, buckets: map[string]*tokenBucket{}}, nil
+}
+
+func (l *NamespaceLimiter) String() string {
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// Take takes a token from the namespace's bucket and reports whether one was
+// left; if not, it also returns how long until the next one.
+func (l *NamespaceLimiter) Take(namespace string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket := l.refill(namespace, now)
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) * float64(l.Per) / float64(l.Burst))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Return puts back a token taken for a restart that did not happen.
+func (l *NamespaceLimiter) Return(namespace string, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket := l.refill(namespace, now)
+	if bucket.tokens++; bucket.tokens > float64(l.Burst) {
+		bucket.tokens = float64(l.Burst)
+	}
+}
+
+// refill returns the namespace's bucket, topped up for the time since it was
+// last used. l.mu must be held.
+func (l *NamespaceLimiter) refill(namespace string, now time.Time) *tokenBucket {
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	bucket, ok := l.buckets[namespace]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.Burst), updated: now}
+		l.buckets[namespace] = bucket
+	}
+	if elapsed := now.Sub(bucket.updated); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * float64(l.Burst) / l.Per.Seconds()
+		if bucket.tokens > float64(l.Burst) {
+			bucket.tokens = float64(l.Burst)
+		}
+		bucket.updated = now
+	}
+	return bucket
+}
+
+// namespaceLimitKey keys buckets by cluster too, since runs against several
+// clusters share the limiter.
+func namespaceLimitKey(ctx context.Context, namespace string) string {
+	return contextCluster(ctx) + "/" + namespace
+}
+
+// limitNamespace takes a restart from the namespace's bucket and returns why
+// the workload is skipped when none is left, or "".
+func (r *Runner) limitNamespace(ctx context.Context, namespace string) string {
+	allowed, wait := r.Options.NamespaceLimit.Take(namespaceLimitKey(ctx, namespace), time.Now())
+	if allowed {
+		return ""
+	}
+	metricRateLimited.WithLabelValues(contextCluster(ctx), namespace).Inc()
+	return fmt.Sprintf("namespace rate limit (%s) reached, next restart allowed in %s", r.Options.NamespaceLimit, wait.Round(time.Second))
+}
+
+// unlimitNamespace returns the token of a restart that did not happen.
+func (r *Runner) unlimitNamespace(ctx context.Context, namespace string) {
+	r.Options.NamespaceLimit.Return(namespaceLimitKey(ctx, namespace), time.Now())
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultDigestTTL is how long a DigestResolver reuses a resolved digest or
+// a pull secret.
+const DefaultDigestTTL = 5 * time.Minute
+
+// Docker Hub is addressed as docker.io in image references, and its
+// credentials are stored under index.docker.io, but it is served from
+// registry-1.docker.io.
+const (
+	dockerHub         = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+// manifestMediaTypes are accepted when resolving a tag, indexes first, so
+// multi-arch tags resolve to the digest the kubelet records.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ImageReference is a parsed container image reference.
+type ImageReference struct {
+	// Registry is the host of the registry, e.g. registry-1.docker.io.
+	Registry   string
+	Repository string
+	Tag        string
+	// Digest is set for references pinned by digest, which cannot drift.
+	Digest string
+}
+
+// ParseImageReference parses an image reference the way the container
+// runtime does: without a registry host it refers to Docker Hub, where
+// single-name repositories live under library/, and the tag defaults to
+// latest.
+func ParseImageReference(image string) (ImageReference, error) {
+	var ref ImageReference
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+	}
+	// A colon after the last slash separates the tag; one before it belongs
+	// to the registry's port.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+	if name == "" {
+		return ref, fmt.Errorf("invalid image reference %q", image)
+	}
+	ref.Registry, ref.Repository = dockerHub, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		if host := name[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry, ref.Repository = host, name[i+1:]
+		}
+	}
+	if ref.Registry == dockerHub || ref.Registry == "index.docker.io" {
+		ref.Registry = dockerHubRegistry
+		if !strings.Contains(ref.Repository, "/") {
+			ref.Repository = "library/" + ref.Repository
+		}
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// DockerConfig holds registry credentials in the format of
+// ~/.docker/config.json and of kubernetes.io/dockerconfigjson Secrets.
+type DockerConfig struct {
+	Auths map[string]DockerAuth `json:"auths"`
+}
+
+// DockerAuth is the credential of one registry.
+type DockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Auth is base64 of username:password.
+	Auth string `json:"auth"`
+}
+
+// LoadDockerConfig reads a Docker config file.
+func LoadDockerConfig(path string) (DockerConfig, error) {
+	var config DockerConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("error reading registry config: %v", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("error parsing registry config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// credential returns the username and password for a registry host.
+func (c DockerConfig) credential(registry string) (string, string, bool) {
+	for key, auth := range c.Auths {
+		host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		host = strings.SplitN(host, "/", 2)[0]
+		if host == "index.docker.io" || host == dockerHub {
+			host = dockerHubRegistry
+		}
+		if host != registry {
+			continue
+		}
+		if auth.Username != "" || auth.Password != "" {
+			return auth.Username, auth.Password, true
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			continue
+		}
+		if user, password, ok := strings.Cut(string(decoded), ":"); ok {
+			return user, password, true
+		}
+	}
+	return "", "", false
+}
+
+// pullSecretConfig extracts the credentials of an image pull Secret.
+func pullSecretConfig(secret *v1.Secret) (DockerConfig, error) {
+	var config DockerConfig
+	switch secret.Type {
+	case v1.SecretTypeDockerConfigJson:
+		err := json.Unmarshal(secret.Data[v1.DockerConfigJsonKey], &config)
+		return config, err
+	case v1.SecretTypeDockercfg:
+		err := json.Unmarshal(secret.Data[v1.DockerConfigKey], &config.Auths)
+		return config, err
+	}
+	return config, fmt.Errorf("secret %s has type %s, not an image pull secret", secret.Name, secret.Type)
+}
+
+// DigestResolver looks up the digests that image tags currently point to in
+// their registries, caching results for TTL.
+type DigestResolver struct {
+	HTTP *http.Client
+	// Config holds credentials that apply to every pod, e.g. from
+	// --registry-config; pull secrets of the pod are tried first.
+	Config DockerConfig
+	TTL    time.Duration
+
+	mu      sync.Mutex
+	digests map[string]cachedValue
+	secrets map[string]cachedValue
+}
+
+type cachedValue struct {
+	value   interface{}
+	expires time.Time
+}
+
+// NewDigestResolver returns a resolver using config for every registry.
+func NewDigestResolver(config DockerConfig) *DigestResolver {
+	return &DigestResolver{HTTP: http.DefaultClient, Config: config, TTL: DefaultDigestTTL}
+}
+
+func (d *DigestResolver) cached(cache *map[string]cachedValue, key string) (interface{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := (*cache)[key]; ok && time.Now().Before(entry.expires) {
+		return entry.value, true
+	}
+	return nil, false
+}
+
+func (d *DigestResolver) store(cache *map[string]cachedValue, key string, value interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if *cache == nil {
+		*cache = map[string]cachedValue{}
+	}
+	(*cache)[key] = cachedValue{value: value, expires: time.Now().Add(d.TTL)}
+}
+
+// PodDigests resolves the tags of the pod's container images, using its image
+// pull secrets. Images pinned by digest are left out, and images that cannot
+// be resolved are logged and left out.
+func (d *DigestResolver) PodDigests(ctx context.Context, pod *v1.Pod, client kubernetes.Interface) map[string]string {
+	var configs []DockerConfig
+	loaded := false
+	digests := map[string]string{}
+	for _, container := range pod.Spec.Containers {
+		if _, done := digests[container.Image]; done {
+			continue
+		}
+		ref, err := ParseImageReference(container.Image)
+		if err != nil || ref.Digest != "" {
+			continue
+		}
+		if !loaded {
+			configs, loaded = d.pullSecrets(ctx, pod, client), true
+		}
+		digest, err := d.Resolve(ctx, ref, configs)
+		if err != nil {
+			warnf("Could not resolve image %s of pod %s/%s: %v\n", container.Image, pod.Namespace, pod.Name, err)
+			continue
+		}
+		digests[container.Image] = digest
+	}
+	return digests
+}
+
+// pullSecrets returns the credentials of the pod's image pull secrets. Secrets
+// that cannot be read are logged and skipped.
+func (d *DigestResolver) pullSecrets(ctx context.Context, pod *v1.Pod, client kubernetes.Interface) []DockerConfig {
+	var configs []DockerConfig
+	for _, reference := range pod.Spec.ImagePullSecrets {
+		key := pod.Namespace + "/" + reference.Name
+		if value, ok := d.cached(&d.secrets, key); ok {
+			configs = append(configs, value.(DockerConfig))
+			continue
+		}
+		getCtx, cancel := withRequestTimeout(ctx)
+		secret, err := client.CoreV1().Secrets(pod.Namespace).Get(getCtx, reference.Name, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			warnf("Could not read image pull secret %s: %v\n", key, err)
+			continue
+		}
+		config, err := pullSecretConfig(secret)
+		if err != nil {
+			warnf("Ignoring image pull secret %s: %v\n", key, err)
+			continue
+		}
+		d.store(&d.secrets, key, config)
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+// Resolve returns the digest the reference's tag points to. The first of
+// configs, then Config, that has credentials for the registry is used.
+func (d *DigestResolver) Resolve(ctx context.Context, ref ImageReference, configs []DockerConfig) (string, error) {
+	key := ref.Registry + "/" + ref.Repository + ":" + ref.Tag
+	if value, ok := d.cached(&d.digests, key); ok {
+		return value.(string), nil
+	}
+	var user, password string
+	authenticated := false
+	for _, config := range append(configs, d.Config) {
+		if user, password, authenticated = config.credential(ref.Registry); authenticated {
+			break
+		}
+	}
+
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.Registry, ref.Repository, ref.Tag)
+	authorization := ""
+	response, err := d.manifestRequest(ctx, http.MethodHead, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusUnauthorized {
+		if authorization, err = d.authorize(ctx, response.Header.Get("WWW-Authenticate"), user, password, authenticated); err != nil {
+			return "", err
+		}
+		if response, err = d.manifestRequest(ctx, http.MethodHead, manifestURL, authorization); err != nil {
+			return "", err
+		}
+		response.Body.Close()
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry answered %s for %s", response.Status, manifestURL)
+	}
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		// Some registries only report the digest on GET.
+		if digest, err = d.manifestDigest(ctx, manifestURL, authorization); err != nil {
+			return "", err
+		}
+	}
+	d.store(&d.digests, key, digest)
+	return digest, nil
+}
+
+// manifestDigest fetches a manifest and returns its digest, hashing it when
+// the registry does not report one.
+func (d *DigestResolver) manifestDigest(ctx context.Context, manifestURL, authorization string) (string, error) {
+	response, err := d.manifestRequest(ctx, http.MethodGet, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry answered %s for %s", response.Status, manifestURL)
+	}
+	if digest := response.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, response.Body); err != nil {
+		return "", fmt.Errorf("error reading manifest: %v", err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (d *DigestResolver) manifestRequest(ctx context.Context, method, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	response, err := d.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying registry: %v", err)
+	}
+	return response, nil
+}
+
+// authorize answers a registry's authentication challenge and returns the
+// Authorization header to retry with: basic credentials, or a bearer token
+// from the registry's token service.
+func (d *DigestResolver) authorize(ctx context.Context, challenge, user, password string, authenticated bool) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !authenticated {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm in %q", challenge)
+	}
+	query := tokenURL.Query()
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if authenticated {
+		req.SetBasicAuth(user, password)
+	}
+	response, err := d.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error getting registry token: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token service answered %s", response.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("error decoding registry token: %v", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("token service returned no token")
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+// into its scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		name, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[name] = value[1:]
+				break
+			}
+			params[name], rest = value[1:end+1], value[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[name] = strings.TrimSpace(value)
+		}
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return scheme, params
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Reloader annotations. A workload opts in with AnnotationReload=true, which
+// covers every ConfigMap and Secret its pod template mounts or reads
+// environment variables from, or by naming ConfigMaps and Secrets in the list
+// annotations, e.g. for files a sidecar fetches. With both, both count.
+const (
+	AnnotationReload           = "restarter.io/reload"
+	AnnotationReloadConfigMaps = "restarter.io/reload-configmaps"
+	AnnotationReloadSecrets    = "restarter.io/reload-secrets"
+	// AnnotationConfigHash records on the workload the hash of the contents
+	// of its ConfigMaps and Secrets as of its last restart.
+	AnnotationConfigHash = "restarter.io/config-hash"
+)
+
+// ruleReload names the results of the Reloader.
+const ruleReload = "reload"
+
+// Reloader restarts opted-in workloads when the ConfigMaps or Secrets they
+// use change, so their pods pick up the new configuration. Since the hash of
+// the contents is recorded on the workload, changes made while the Reloader
+// was not running are caught up on when it starts.
+type Reloader struct {
+	Client  kubernetes.Interface
+	Options RunOptions
+	// Namespaces limits the watched namespaces; empty means all.
+	Namespaces []string
+	// Resync is how often every workload is checked again, which retries
+	// restarts that were skipped, e.g. outside the maintenance windows.
+	Resync time.Duration
+	// RunContext derives the context of a single restart.
+	RunContext func(ctx context.Context) (context.Context, context.CancelFunc)
+	// Output receives one result document per restart.
+	Output io.Writer
+	Format string
+
+	configMaps corelisters.ConfigMapLister
+	secrets    corelisters.SecretLister
+	workloads  map[string]cache.GenericLister
+	queue      workqueue.RateLimitingInterface
+
+	writeMu sync.Mutex
+}
+
+// Run starts the informers and processes changes with Options.Concurrency
+// workers until ctx is cancelled. Restarts in flight when ctx is cancelled
+// are allowed to finish; restarts held for a maintenance window are skipped
+// and picked up again after the next start, as their configuration hash is
+// not recorded.
+func (r *Reloader) Run(ctx context.Context) error {
+	var factoryOptions []informers.SharedInformerOption
+	if len(r.Namespaces) == 1 {
+		factoryOptions = append(factoryOptions, informers.WithNamespace(r.Namespaces[0]))
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(r.Client, r.Resync, factoryOptions...)
+	core, apps := factory.Core().V1(), factory.Apps().V1()
+	r.configMaps, r.secrets = core.ConfigMaps().Lister(), core.Secrets().Lister()
+	r.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	r.Options.Stop = ctx.Done()
+
+	r.workloads = map[string]cache.GenericLister{}
+	for kind, informer := range map[string]cache.SharedIndexInformer{
+		KindDeployment:  apps.Deployments().Informer(),
+		KindStatefulSet: apps.StatefulSets().Informer(),
+		KindDaemonSet:   apps.DaemonSets().Informer(),
+	} {
+		kind := kind
+		r.workloads[kind] = cache.NewGenericLister(informer.GetIndexer(), appsv1.Resource(strings.ToLower(kind)+"s"))
+		enqueue := func(obj interface{}) {
+			meta, err := metaObject(obj)
+			if err == nil && r.namespaceAllowed(meta.GetNamespace()) && reloadEnabled(meta.GetAnnotations()) {
+				r.queue.Add(kind + "/" + meta.GetNamespace() + "/" + meta.GetName())
+			}
+		}
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueue,
+			UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		})
+	}
+	for _, informer := range []cache.SharedIndexInformer{core.ConfigMaps().Informer(), core.Secrets().Informer()} {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    r.enqueueUsers,
+			UpdateFunc: func(_, obj interface{}) { r.enqueueUsers(obj) },
+			DeleteFunc: r.enqueueUsers,
+		})
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	infof("Waiting for the workload, ConfigMap and Secret caches to sync\n")
+	markSynced := cacheSync.syncing("reload")
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			r.queue.ShutDown()
+			return fmt.Errorf("error syncing the %v cache", informerType)
+		}
+	}
+	markSynced()
+	infof("Watching ConfigMaps and Secrets, resyncing every %s\n", r.Resync)
+
+	workers := r.Options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for r.processNext() {
+			}
+		}()
+	}
+	<-ctx.Done()
+	infof("Shutting down, waiting for in-flight restarts\n")
+	r.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+// metaObject returns the metadata of an informer object, including deleted
+// ones.
+func metaObject(obj interface{}) (metav1.Object, error) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	meta, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	return meta, nil
+}
+
+// enqueueUsers queues the opted-in workloads that use a changed ConfigMap or
+// Secret.
+func (r *Reloader) enqueueUsers(obj interface{}) {
+	meta, err := metaObject(obj)
+	if err != nil || !r.namespaceAllowed(meta.GetNamespace()) {
+		return
+	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	_, isSecret := obj.(*v1.Secret)
+	for kind, lister := range r.workloads {
+		objs, err := lister.ByNamespace(meta.GetNamespace()).List(labels.Everything())
+		if err != nil {
+			continue
+		}
+		for _, workloadObj := range objs {
+			workload := &Workload{Kind: kind, Object: workloadObj}
+			workloadMeta, err := metaObject(workloadObj)
+			if err != nil || !reloadEnabled(workloadMeta.GetAnnotations()) {
+				continue
+			}
+			configMaps, secrets := reloadReferences(workloadMeta.GetAnnotations(), workload.PodTemplate())
+			names := configMaps
+			if isSecret {
+				names = secrets
+			}
+			for _, name := range names {
+				if name == meta.GetName() {
+					r.queue.Add(kind + "/" + workloadMeta.GetNamespace() + "/" + workloadMeta.GetName())
+					break
+				}
+			}
+		}
+	}
+}
+
+// namespaceAllowed applies Namespaces and the excluded namespaces.
+func (r *Reloader) namespaceAllowed(namespace string) bool {
+	if namespaceExcluded(&Rule{}, namespace, r.Options.ExcludeNamespaces) {
+		return false
+	}
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, name := range r.Namespaces {
+		if name == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// processNext handles one queued workload and reports whether the queue is
+// still open.
+func (r *Reloader) processNext() bool {
+	item, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(item)
+
+	key := item.(string)
+	if r.reload(key) {
+		r.queue.AddRateLimited(key)
+		return true
+	}
+	r.queue.Forget(key)
+	return true
+}
+
+// reload restarts the workload behind key if its configuration changed since
+// its last restart, and reports whether it should be retried.
+func (r *Reloader) reload(key string) bool {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return false
+	}
+	kind, namespace, name := parts[0], parts[1], parts[2]
+	obj, err := r.workloads[kind].ByNamespace(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		errorf("Error reading %s from the cache: %v\n", key, err)
+		return false
+	}
+	// Work on a copy: objects from the cache are shared and read-only.
+	obj = obj.DeepCopyObject()
+	meta, err := metaObject(obj)
+	if err != nil || !reloadEnabled(meta.GetAnnotations()) || meta.GetDeletionTimestamp() != nil {
+		return false
+	}
+	workload := &Workload{Kind: kind, Namespace: namespace, Name: name, Annotations: meta.GetAnnotations(), Object: obj}
+
+	configMaps, secrets := reloadReferences(meta.GetAnnotations(), workload.PodTemplate())
+	hash := r.configHash(namespace, configMaps, secrets)
+	recorded := meta.GetAnnotations()[AnnotationConfigHash]
+	if recorded == hash {
+		return false
+	}
+	runCtx, cancel := r.RunContext(context.Background())
+	defer cancel()
+	if recorded == "" {
+		// Nothing to compare with yet: the pods run whatever configuration
+		// is current, so only remember it.
+		workloadLog(runCtx, workload, ruleReload).debugf("Recording the configuration of %s\n", workload)
+		return r.recordHash(runCtx, workload, hash)
+	}
+
+	workloadLog(runCtx, workload, ruleReload).infof("Configuration of %s changed\n", workload)
+	rule := &Rule{Name: ruleReload}
+	result := Result{Rule: rule.Name, Namespace: namespace, Status: StatusMatched, Trigger: "configuration changed"}
+	result = NewRunner(r.Client, r.Options).processWorkload(runCtx, rule, workload, "configuration change", result, namespaceAnnotations(runCtx, namespace, r.Options.Scope, r.Client))
+	r.write(result)
+	switch result.Status {
+	case StatusRestarted:
+		return r.recordHash(runCtx, workload, hash)
+	case StatusFailed:
+		return true
+	}
+	// Skipped restarts are retried at the next resync.
+	return false
+}
+
+// recordHash stores the configuration hash on the workload without changing
+// its pod template, and reports whether that should be retried.
+func (r *Reloader) recordHash(ctx context.Context, workload *Workload, hash string) bool {
+	if r.Options.DryRun {
+		return false
+	}
+	if err := annotateWorkload(ctx, workload, AnnotationConfigHash, hash, r.Client); err != nil {
+		workloadLog(ctx, workload, ruleReload).errorf("Error recording the configuration of %s: %v\n", workload, err)
+		return true
+	}
+	return false
+}
+
+// write emits a single result. Writes are serialized across workers.
+func (r *Reloader) write(result Result) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if err := WriteResults(r.Output, r.Format, []Result{result}); err != nil {
+		errorf("Error writing results: %v\n", err)
+	}
+}
+
+// reloadEnabled reports whether a workload opted in to reloads.
+func reloadEnabled(annotations map[string]string) bool {
+	if annotations[AnnotationReloadConfigMaps] != "" || annotations[AnnotationReloadSecrets] != "" {
+		return true
+	}
+	value, ok := annotations[AnnotationReload]
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		warnf("Ignoring invalid %s annotation value %q\n", AnnotationReload, value)
+	}
+	return enabled
+}
+
+// reloadReferences returns the sorted names of the ConfigMaps and Secrets a
+// workload reloads on: those its annotations list and, with
+// AnnotationReload=true, those its pod template uses. Image pull secrets are
+// not included.
+func reloadReferences(annotations map[string]string, template *v1.PodTemplateSpec) (configMaps, secrets []string) {
+	seenConfigMaps, seenSecrets := map[string]bool{}, map[string]bool{}
+	addConfigMap := func(name string) {
+		if name != "" {
+			seenConfigMaps[name] = true
+		}
+	}
+	addSecret := func(name string) {
+		if name != "" {
+			seenSecrets[name] = true
+		}
+	}
+	for _, name := range strings.Split(annotations[AnnotationReloadConfigMaps], ",") {
+		addConfigMap(strings.TrimSpace(name))
+	}
+	for _, name := range strings.Split(annotations[AnnotationReloadSecrets], ",") {
+		addSecret(strings.TrimSpace(name))
+	}
+
+	if enabled, _ := strconv.ParseBool(annotations[AnnotationReload]); enabled && template != nil {
+		spec := &template.Spec
+		for _, volume := range spec.Volumes {
+			if volume.ConfigMap != nil {
+				addConfigMap(volume.ConfigMap.Name)
+			}
+			if volume.Secret != nil {
+				addSecret(volume.Secret.SecretName)
+			}
+			if volume.Projected != nil {
+				for _, source := range volume.Projected.Sources {
+					if source.ConfigMap != nil {
+						addConfigMap(source.ConfigMap.Name)
+					}
+					if source.Secret != nil {
+						addSecret(source.Secret.Name)
+					}
+				}
+			}
+		}
+		containers := append(append([]v1.Container(nil), spec.InitContainers...), spec.Containers...)
+		for _, container := range containers {
+			for _, source := range container.EnvFrom {
+				if source.ConfigMapRef != nil {
+					addConfigMap(source.ConfigMapRef.Name)
+				}
+				if source.SecretRef != nil {
+					addSecret(source.SecretRef.Name)
+				}
+			}
+			for _, env := range container.Env {
+				if env.ValueFrom == nil {
+					continue
+				}
+				if env.ValueFrom.ConfigMapKeyRef != nil {
+					addConfigMap(env.ValueFrom.ConfigMapKeyRef.Name)
+				}
+				if env.ValueFrom.SecretKeyRef != nil {
+					addSecret(env.ValueFrom.SecretKeyRef.Name)
+				}
+			}
+		}
+	}
+	return sortedKeys(seenConfigMaps), sortedKeys(seenSecrets)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configHash hashes the contents of the ConfigMaps and Secrets. Missing ones
+// hash as empty, so creating them later counts as a change.
+func (r *Reloader) configHash(namespace string, configMaps, secrets []string) string {
+	hash := sha256.New()
+	write := func(kind, name string, data map[string][]byte) {
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(hash, "%s/%s\n", kind, name)
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s=%d:", key, len(data[key]))
+			hash.Write(data[key])
+		}
+	}
+	for _, name := range configMaps {
+		data := map[string][]byte{}
+		if configMap, err := r.configMaps.ConfigMaps(namespace).Get(name); err == nil {
+			for key, value := range configMap.Data {
+				data[key] = []byte(value)
+			}
+			for key, value := range configMap.BinaryData {
+				data[key] = value
+			}
+		}
+		write("configmap", name, data)
+	}
+	for _, name := range secrets {
+		var data map[string][]byte
+		if secret, err := r.secrets.Secrets(namespace).Get(name); err == nil {
+			data = secret.Data
+		}
+		write("secret", name, data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// annotateWorkload sets an annotation on the workload itself, which does not
+// roll its pods.
+func annotateWorkload(ctx context.Context, workload *Workload, key, value string, client kubernetes.Interface) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	apps := client.AppsV1()
+	switch workload.Kind {
+	case KindDeployment:
+		_, err = apps.Deployments(workload.Namespace).Patch(ctx, workload.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case KindStatefulSet:
+		_, err = apps.StatefulSets(workload.Namespace).Patch(ctx, workload.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case KindDaemonSet:
+		_, err = apps.DaemonSets(workload.Namespace).Patch(ctx, workload.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("unsupported workload kind %s", workload.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("error annotating %s: %v", strings.ToLower(workload.Kind), err)
+	}
+	return nil
+}
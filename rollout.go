@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// Rollout outcomes recorded on a Result.
+const (
+	RolloutComplete = "complete"
+	RolloutTimeout  = "timeout"
+	RolloutFailed   = "failed"
+	// RolloutRolledBack means the rollout failed and was rolled back.
+	RolloutRolledBack = "rolled-back"
+	// RolloutUnhealthy means the rollout finished but the workload's health
+	// check did not pass.
+	RolloutUnhealthy = "unhealthy"
+)
+
+// WaitForRollout watches the workload until its rollout finishes, fails or
+// timeout elapses, logging progress the way kubectl rollout status does. Each
+// new status message is also passed to report, if set.
+func WaitForRollout(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface, report func(message string)) (string, error) {
+	log := workloadLog(ctx, workload, "wait")
+	log.infof("Waiting for %s rollout to finish\n", workload)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lastMessage := ""
+	_, err := watchtools.UntilWithSync(ctx, workloadListWatch(ctx, workload, client), workload.Object.DeepCopyObject(), nil,
+		func(event watch.Event) (bool, error) {
+			if event.Type == watch.Deleted {
+				return false, fmt.Errorf("%s was deleted", workload)
+			}
+			message, done, err := RolloutStatus(event.Object)
+			if err != nil {
+				return false, err
+			}
+			if message != lastMessage {
+				log.infof("%s: %s\n", workload, message)
+				lastMessage = message
+				if report != nil {
+					report(message)
+				}
+			}
+			return done, nil
+		})
+
+	switch {
+	case err == nil:
+		return RolloutComplete, nil
+	case ctx.Err() == context.DeadlineExceeded:
+		return RolloutTimeout, fmt.Errorf("rollout of %s did not finish within %s", workload, timeout)
+	}
+	return RolloutFailed, fmt.Errorf("rollout of %s failed: %v", workload, err)
+}
+
+// workloadListWatch lists and watches the single object behind workload. Its
+// calls end when ctx is cancelled.
+func workloadListWatch(ctx context.Context, workload *Workload, client kubernetes.Interface) cache.ListerWatcher {
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", workload.Name).String()
+	apps := client.AppsV1()
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			ctx, cancel := withRequestTimeout(ctx)
+			defer cancel()
+			switch workload.Kind {
+			case KindDeployment:
+				return apps.Deployments(workload.Namespace).List(ctx, options)
+			case KindStatefulSet:
+				return apps.StatefulSets(workload.Namespace).List(ctx, options)
+			case KindDaemonSet:
+				return apps.DaemonSets(workload.Namespace).List(ctx, options)
+			}
+			return nil, fmt.Errorf("unsupported workload kind %s", workload.Kind)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			switch workload.Kind {
+			case KindDeployment:
+				return apps.Deployments(workload.Namespace).Watch(ctx, options)
+			case KindStatefulSet:
+				return apps.StatefulSets(workload.Namespace).Watch(ctx, options)
+			case KindDaemonSet:
+				return apps.DaemonSets(workload.Namespace).Watch(ctx, options)
+			}
+			return nil, fmt.Errorf("unsupported workload kind %s", workload.Kind)
+		},
+	}
+}
+
+// RolloutStatus reports the progress of a workload's rollout, following the
+// rules of kubectl rollout status. It returns an error once the rollout can
+// no longer succeed.
+func RolloutStatus(obj runtime.Object) (string, bool, error) {
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		return deploymentRolloutStatus(w)
+	case *appsv1.StatefulSet:
+		return statefulSetRolloutStatus(w)
+	case *appsv1.DaemonSet:
+		return daemonSetRolloutStatus(w)
+	}
+	return "", false, fmt.Errorf("unsupported object %T", obj)
+}
+
+func deploymentRolloutStatus(d *appsv1.Deployment) (string, bool, error) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return "waiting for the rollout to be observed", false, nil
+	}
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return "", false, fmt.Errorf("deployment %s exceeded its progress deadline", d.Name)
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return fmt.Sprintf("%d of %d updated replicas are available", d.Status.UpdatedReplicas, replicas), false, nil
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas), false, nil
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d of %d updated replicas are available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas), false, nil
+	}
+	return "successfully rolled out", true, nil
+}
+
+func statefulSetRolloutStatus(s *appsv1.StatefulSet) (string, bool, error) {
+	if s.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return "update strategy is not RollingUpdate, not waiting", true, nil
+	}
+	if s.Generation > s.Status.ObservedGeneration {
+		return "waiting for the rollout to be observed", false, nil
+	}
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	if s.Status.ReadyReplicas < replicas {
+		return fmt.Sprintf("%d of %d pods are ready", s.Status.ReadyReplicas, replicas), false, nil
+	}
+	if rollingUpdate := s.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil && *rollingUpdate.Partition > 0 {
+		updated := replicas - *rollingUpdate.Partition
+		if s.Status.UpdatedReplicas < updated {
+			return fmt.Sprintf("%d of %d partitioned pods are updated", s.Status.UpdatedReplicas, updated), false, nil
+		}
+		return "partitioned rollout complete", true, nil
+	}
+	if s.Status.UpdateRevision != s.Status.CurrentRevision {
+		return fmt.Sprintf("%d of %d pods are updated", s.Status.UpdatedReplicas, replicas), false, nil
+	}
+	return "successfully rolled out", true, nil
+}
+
+func daemonSetRolloutStatus(d *appsv1.DaemonSet) (string, bool, error) {
+	if d.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		return "update strategy is not RollingUpdate, not waiting", true, nil
+	}
+	if d.Generation > d.Status.ObservedGeneration {
+		return "waiting for the rollout to be observed", false, nil
+	}
+	if d.Status.UpdatedNumberScheduled < d.Status.DesiredNumberScheduled {
+		return fmt.Sprintf("%d of %d updated pods are scheduled", d.Status.UpdatedNumberScheduled, d.Status.DesiredNumberScheduled), false, nil
+	}
+	if d.Status.NumberAvailable < d.Status.DesiredNumberScheduled {
+		return fmt.Sprintf("%d of %d updated pods are available", d.Status.NumberAvailable, d.Status.DesiredNumberScheduled), false, nil
+	}
+	return "successfully rolled out", true, nil
+}
@@ -0,0 +1,1102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+
+	"github.com/testpractive123/assessment-devops.git/pkg/restarter"
+)
+
+// Operations a run can apply to the workloads it resolves.
+const (
+	OperationRestart = "restart"
+	OperationPause   = "pause"
+	OperationResume  = "resume"
+)
+
+// RunOptions holds the settings shared by every rule in a run.
+type RunOptions struct {
+	// Operation is OperationRestart (the default), OperationPause or
+	// OperationResume.
+	Operation string
+	// ExcludeNamespaces are never processed, whatever the rules say.
+	ExcludeNamespaces []string
+	// Scope is ScopeCluster (the default) or ScopeNamespace. In namespace
+	// scope, Namespace objects are never read, so namespace annotations do
+	// not apply.
+	Scope string
+	// DryRun resolves and reports the deployments without restarting them.
+	DryRun bool
+	// OptInOnly restricts restarts to workloads or namespaces annotated
+	// restarter.io/enabled=true.
+	OptInOnly bool
+	// DeleteOrphans deletes matched pods that have no controlling workload
+	// instead of reporting them as failures.
+	DeleteOrphans bool
+	// EvictOrphans deletes orphans through the Eviction API.
+	EvictOrphans bool
+	// Wait blocks after each restart until the rollout finishes or
+	// WaitTimeout elapses.
+	Wait        bool
+	WaitTimeout time.Duration
+	// RollbackOnFailure rolls a workload back to its previous revision when
+	// its rollout does not become healthy within WaitTimeout. Implies Wait.
+	RollbackOnFailure bool
+	// Strategy is the name of the RestartStrategy, StrategyRollout by
+	// default. Rules can override it.
+	Strategy string
+	// PDBCheck is PDBCheckSkip, PDBCheckWarn or PDBCheckOff and governs
+	// rollout restarts that would violate a PodDisruptionBudget.
+	PDBCheck string
+	// Cooldown skips workloads restarted less than this long ago. Rules can
+	// override it.
+	Cooldown time.Duration
+	// Confirmer, when set, is asked before every restart.
+	Confirmer *Confirmer
+	// Concurrency is how many matched pods are processed, and so how many
+	// workloads restarted, at the same time. Values below 1 mean 1.
+	Concurrency int
+	// ScanConcurrency is how many namespaces are listed and evaluated at
+	// the same time; 0 means Concurrency. Scans only read, so it can be far
+	// higher than Concurrency.
+	ScanConcurrency int
+	// ClusterWideList lists the pods of all target namespaces in a single
+	// paginated call across the cluster, instead of one call per namespace,
+	// and reads the annotations of only the namespaces with matched pods.
+	// Pods of other namespaces are listed too and dropped. It is ignored
+	// with a Cache and in namespace scope.
+	ClusterWideList bool
+	// MaxRestarts caps the number of workloads restarted (or orphans deleted)
+	// in a run. Candidates past the limit are reported as skipped. Zero means
+	// no limit.
+	MaxRestarts int
+	// NamespaceLimit, when set, caps the restarts per namespace over time.
+	// Candidates past it are reported as skipped.
+	NamespaceLimit *NamespaceLimiter
+	// MaintenanceWindows limit when restarts may happen; rules can override
+	// them. No windows means any time.
+	MaintenanceWindows []MaintenanceWindow
+	// OutsideWindow is OutsideWindowSkip (the default) or OutsideWindowWait.
+	OutsideWindow string
+	// Stop, when closed, makes the run skip the pods it has not started on;
+	// restarts in progress finish.
+	Stop <-chan struct{}
+	// Progress, when set, is called as each workload is restarted and each
+	// pod is done. It may be called from several workers at once.
+	Progress func(ProgressEvent)
+	// RecordEvents attaches an AutomatedRestart Event to every restarted
+	// workload, and an AutomatedRestartFailed one when the restart fails.
+	RecordEvents bool
+	// Audit, when set, records every mutating action.
+	Audit *AuditLog
+	// History, when set, keeps the last restart of every workload for
+	// cooldowns. NewRunner binds it to the Runner's client.
+	History *History
+	// Cache, when set, serves the pods, namespaces and owners scans read,
+	// instead of the API server.
+	Cache *WorkloadCache
+	// Metadata, when set, lists the pods of rules whose triggers only look
+	// at pod metadata as PartialObjectMetadata, a fraction of the size of
+	// whole pods. It must talk to the same cluster as the Runner's client.
+	Metadata metadata.Interface
+	// RestConfig, when set, is the client configuration exec hooks connect
+	// to pods with. It must talk to the same cluster as the Runner's client.
+	RestConfig *rest.Config
+	// RunID identifies the run in reports and templates. NewRunner fills in
+	// a new one.
+	RunID string
+	// Actor is the Kubernetes identity the run acts as, for the audit log,
+	// notifications and templates. NewRunner derives it from RestConfig, so
+	// every cluster of a run has its own.
+	Actor string
+	// Annotations are written to every restarted workload, rendered from
+	// their templates with the result.
+	Annotations map[string]*template.Template
+	// ArgoCD, when set, skips the workloads Argo CD manages, or syncs their
+	// Application after restarting them.
+	ArgoCD *ArgoCD
+	// Policy, when set, must allow every restart.
+	Policy *OPAPolicy
+	// RequireApproval annotates workloads that would be restarted
+	// restarter.io/pending-approval instead, and only restarts those
+	// annotated restarter.io/approved=true.
+	RequireApproval bool
+	// Canary restarts the first matched Deployment alone, waits for its
+	// rollout and health check, and aborts the run if it fails.
+	Canary CanaryOptions
+	// Freeze, when set, declares change freezes during which nothing is
+	// restarted. NewRunner binds it to the Runner's client.
+	Freeze *FreezeConfigMap
+	// DenyList holds the namespaces and workloads that are never acted on,
+	// whatever the rules match.
+	DenyList *DenyList
+	// Notifiers are told about the outcome of every Run that matched
+	// something.
+	Notifiers []Notifier
+	// Registry resolves image tags for the image drift trigger. NewRunner
+	// fills in one without credentials.
+	Registry *DigestResolver
+}
+
+// ReasonInterrupted is the reason of pods skipped because the run was
+// interrupted before it reached them.
+const ReasonInterrupted = "interrupted"
+
+// Progress phases.
+const (
+	PhaseRestarting = "restarting"
+	PhaseRollout    = "rollout"
+	PhaseDone       = "done"
+)
+
+// ProgressEvent is one step of a run, reported to RunOptions.Progress.
+type ProgressEvent struct {
+	Phase     string
+	Kind      string
+	Namespace string
+	Workload  string
+	// Message is the rollout status for PhaseRollout.
+	Message string
+	// Result is the outcome of a pod for PhaseDone.
+	Result *Result
+}
+
+// Runner executes rules against a cluster and keeps the state of one run.
+type Runner struct {
+	Client  kubernetes.Interface
+	Options RunOptions
+
+	// handled maps each workload key to what first selected it, so a
+	// workload behind several matching pods is acted on only once per run.
+	handled map[string]string
+	// restarts counts the restarts reserved against MaxRestarts.
+	restarts int
+	// namespaces holds the namespaces scanned, and pods counts the pods
+	// evaluated, for the run report.
+	namespaces map[string]bool
+	pods       int
+	// frozen is why restarts are frozen, or "", as of the freeze ConfigMap
+	// read at frozenAt. freezeMu guards both and serializes the reads.
+	frozen   string
+	frozenAt time.Time
+	freezeMu sync.Mutex
+	// canary is the canary state, and canaryWorkload the canary once picked.
+	canary         int
+	canaryWorkload string
+	// report describes the last Run.
+	report RunReport
+	// mu guards handled, restarts, namespaces, pods and the canary across
+	// workers.
+	mu sync.Mutex
+}
+
+// NewRunner returns a Runner for a single run.
+func NewRunner(client kubernetes.Interface, options RunOptions) *Runner {
+	if options.Registry == nil {
+		options.Registry = NewDigestResolver(DockerConfig{})
+	}
+	if options.RunID == "" {
+		options.RunID = newRunID()
+	}
+	if options.Actor == "" && options.RestConfig != nil {
+		options.Actor = configIdentity(options.RestConfig)
+	}
+	if options.History != nil && options.History.Client == nil {
+		history := *options.History
+		history.Client = client
+		options.History = &history
+	}
+	if options.Freeze != nil && options.Freeze.Client == nil {
+		freeze := *options.Freeze
+		freeze.Client = client
+		options.Freeze = &freeze
+	}
+	return &Runner{Client: client, Options: options, handled: map[string]string{}, namespaces: map[string]bool{}}
+}
+
+// api returns the restart engine for the Runner's clients.
+func (r *Runner) api() *restarter.Client {
+	client := apiClient(r.Client)
+	client.Metadata = r.Options.Metadata
+	return client
+}
+
+// Run processes every rule in order and reports whether any of them failed
+// to list its namespaces or pods.
+func (r *Runner) Run(ctx context.Context, rules []Rule) ([]Result, bool) {
+	started := time.Now()
+	var results []Result
+	failed := false
+	for i := range rules {
+		ruleResults, err := r.ProcessRule(ctx, &rules[i])
+		results = append(results, ruleResults...)
+		if err != nil {
+			logFor(ctx).errorf("Error processing rule %s: %v\n", rules[i].Name, err)
+			failed = true
+		}
+	}
+	r.mu.Lock()
+	r.report = newRunReport(r.Options, started, results, failed)
+	r.report.Namespaces, r.report.Pods = len(r.namespaces), r.pods
+	r.report.Cluster = contextCluster(ctx)
+	r.mu.Unlock()
+	notify(r.Options.Notifiers, r.report)
+	return results, failed
+}
+
+// Report describes the outcome of the last Run.
+func (r *Runner) Report() RunReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.report
+}
+
+// scanned counts a namespace listed and its pods for the run report.
+func (r *Runner) scanned(namespace string, pods int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces[namespace] = true
+	r.pods += pods
+}
+
+// ProcessRule restarts (or reports) the workloads owning the pods matched by
+// the rule and returns one Result per matched pod. Namespaces whose pods cannot
+// be listed are skipped and reported through the returned error.
+func (r *Runner) ProcessRule(ctx context.Context, rule *Rule) (results []Result, err error) {
+	options := r.Options
+	ctx, span := startSpan(ctx, "ProcessRule", attribute.String("restarter.rule", rule.Name))
+	defer func() {
+		span.SetAttributes(attribute.Int("restarter.results", len(results)))
+		endSpan(span, err)
+	}()
+	if rule.Name != "" {
+		logFor(ctx).with("rule", rule.Name).infof("Processing rule: %s\n", rule.Name)
+	}
+	if options.Scope == ScopeNamespace && len(rule.Namespaces) == 0 {
+		return nil, fmt.Errorf("rule %s names no namespaces, which --scope=%s requires", rule.Name, ScopeNamespace)
+	}
+	namespaces, err := r.targetNamespaces(ctx, rule)
+	if err != nil {
+		return nil, fmt.Errorf("error listing namespaces: %w", err)
+	}
+
+	// Candidates are collected per namespace first and then processed, so
+	// both phases can run on the worker pool while results keep the order in
+	// which the namespaces and pods were listed.
+	candidates := make([][]candidate, len(namespaces))
+	nsErrs := make([]error, len(namespaces))
+	scanWorkers := options.ScanConcurrency
+	if scanWorkers == 0 {
+		scanWorkers = options.Concurrency
+	}
+	if options.ClusterWideList && options.Cache == nil && options.Scope != ScopeNamespace {
+		if candidates, err = r.matchAllPods(ctx, rule, namespaces); err != nil {
+			return nil, err
+		}
+	} else {
+		forEach(len(namespaces), scanWorkers, func(i int) {
+			candidates[i], nsErrs[i] = r.matchPods(ctx, rule, namespaces[i])
+		})
+	}
+	var matched []candidate
+	for _, namespaceCandidates := range candidates {
+		matched = append(matched, namespaceCandidates...)
+	}
+
+	results = make([]Result, len(matched))
+	process := func(i int) {
+		results[i] = r.processPod(ctx, rule, matched[i].pod, matched[i].trigger, matched[i].nsAnnotations)
+		r.progress(ProgressEvent{Phase: PhaseDone, Kind: results[i].Kind, Namespace: results[i].Namespace, Workload: results[i].Workload, Result: &results[i]})
+	}
+	// Until the canary is settled, only pods that may belong to a Deployment
+	// are processed, one at a time, so nothing else is restarted before or
+	// alongside it. The others are held back for the rest of the run.
+	processed := make([]bool, len(matched))
+	for i := 0; i < len(matched) && r.needsCanary(); i++ {
+		if mayBeCanary(matched[i].pod) {
+			process(i)
+			processed[i] = true
+		}
+	}
+	var rest []int
+	for i := range matched {
+		if !processed[i] {
+			rest = append(rest, i)
+		}
+	}
+	forEach(len(rest), options.Concurrency, func(i int) { process(rest[i]) })
+	return results, utilerrors.NewAggregate(nsErrs)
+}
+
+// candidate is a matched pod whose trigger fired.
+type candidate struct {
+	pod           *v1.Pod
+	trigger       string
+	nsAnnotations map[string]string
+}
+
+// matchPods lists the pods of a namespace and returns those the rule matches
+// and whose trigger fired.
+func (r *Runner) matchPods(ctx context.Context, rule *Rule, namespace string) (matched []candidate, err error) {
+	ctx, span := startSpan(ctx, "ScanNamespace", attribute.String("k8s.namespace.name", namespace))
+	defer func() {
+		span.SetAttributes(attribute.Int("restarter.matched_pods", len(matched)))
+		endSpan(span, err)
+	}()
+	log := logFor(ctx).with("rule", rule.Name, "namespace", namespace)
+	if stopped(r.Options.Stop) {
+		log.infof("Skipping namespace %s: interrupted\n", namespace)
+		return nil, nil
+	}
+	log.infof("Processing namespace: %s\n", namespace)
+	var nsAnnotations map[string]string
+	if r.Options.Cache != nil {
+		nsAnnotations = r.Options.Cache.NamespaceAnnotations(namespace)
+	} else {
+		nsAnnotations = namespaceAnnotations(ctx, namespace, r.Options.Scope, r.Client)
+	}
+	var events map[types.UID][]v1.Event
+	if rule.triggers.NeedsEvents() {
+		if events, err = r.api().ListPodEvents(ctx, namespace); err != nil {
+			log.errorf("Error listing events in namespace %s: %v\n", namespace, err)
+			return nil, fmt.Errorf("namespace %s: %w", namespace, err)
+		}
+	}
+
+	// Pods are evaluated a page at a time and only the matched ones are
+	// kept.
+	pods := 0
+	evaluate := func(pod *v1.Pod) {
+		pods++
+		if fired, trigger := selectPod(ctx, rule, pod, r.observe(ctx, rule, pod, events[pod.UID])); fired {
+			matched = append(matched, candidate{pod: pod, trigger: trigger, nsAnnotations: nsAnnotations})
+		}
+	}
+	listOptions := metav1.ListOptions{LabelSelector: rule.podSelector(), FieldSelector: rule.FieldSelector}
+	if r.Options.Cache != nil {
+		err = r.Options.Cache.EachPod(namespace, rule, evaluate)
+	} else if r.Options.Metadata != nil && !rule.needsPodStatus() {
+		err = r.api().EachPodMetadata(ctx, namespace, listOptions, evaluate)
+	} else {
+		err = r.api().EachPod(ctx, namespace, listOptions, evaluate)
+	}
+	metricPodsScanned.WithLabelValues(contextCluster(ctx)).Add(float64(pods))
+	r.scanned(namespace, pods)
+	span.SetAttributes(attribute.Int("restarter.pods", pods))
+	if err != nil {
+		log.errorf("Error listing pods in namespace %s: %v\n", namespace, err)
+		return nil, fmt.Errorf("namespace %s: %w", namespace, err)
+	}
+	return matched, nil
+}
+
+// matchAllPods is matchPods for all the namespaces at once, from a single
+// list of the pods of the cluster. The candidates are grouped by namespace,
+// in the order of namespaces; pods of other namespaces are dropped.
+func (r *Runner) matchAllPods(ctx context.Context, rule *Rule, namespaces []string) (matched [][]candidate, err error) {
+	ctx, span := startSpan(ctx, "ScanCluster", attribute.Int("restarter.namespaces", len(namespaces)))
+	defer func() { endSpan(span, err) }()
+	log := logFor(ctx).with("rule", rule.Name)
+	matched = make([][]candidate, len(namespaces))
+	if stopped(r.Options.Stop) {
+		log.infof("Skipping %d namespaces: interrupted\n", len(namespaces))
+		return matched, nil
+	}
+	log.infof("Processing %d namespaces\n", len(namespaces))
+	index := make(map[string]int, len(namespaces))
+	for i, namespace := range namespaces {
+		index[namespace] = i
+	}
+	var events map[types.UID][]v1.Event
+	if rule.triggers.NeedsEvents() {
+		if events, err = r.api().ListPodEvents(ctx, metav1.NamespaceAll); err != nil {
+			log.errorf("Error listing events: %v\n", err)
+			return nil, err
+		}
+	}
+
+	pods := make([]int, len(namespaces))
+	nsAnnotations := map[string]map[string]string{}
+	evaluate := func(pod *v1.Pod) {
+		i, ok := index[pod.Namespace]
+		if !ok {
+			return
+		}
+		pods[i]++
+		if fired, trigger := selectPod(ctx, rule, pod, r.observe(ctx, rule, pod, events[pod.UID])); fired {
+			annotations, ok := nsAnnotations[pod.Namespace]
+			if !ok {
+				annotations = namespaceAnnotations(ctx, pod.Namespace, r.Options.Scope, r.Client)
+				nsAnnotations[pod.Namespace] = annotations
+			}
+			matched[i] = append(matched[i], candidate{pod: pod, trigger: trigger, nsAnnotations: annotations})
+		}
+	}
+	listOptions := metav1.ListOptions{LabelSelector: rule.podSelector(), FieldSelector: rule.FieldSelector}
+	if r.Options.Metadata != nil && !rule.needsPodStatus() {
+		err = r.api().EachPodMetadata(ctx, metav1.NamespaceAll, listOptions, evaluate)
+	} else {
+		err = r.api().EachPod(ctx, metav1.NamespaceAll, listOptions, evaluate)
+	}
+	total := 0
+	for i, namespace := range namespaces {
+		r.scanned(namespace, pods[i])
+		total += pods[i]
+	}
+	metricPodsScanned.WithLabelValues(contextCluster(ctx)).Add(float64(total))
+	span.SetAttributes(attribute.Int("restarter.pods", total))
+	if err != nil {
+		log.errorf("Error listing pods: %v\n", err)
+		return nil, err
+	}
+	return matched, nil
+}
+
+// observe gathers what the rule's triggers need about the pod. Image digests
+// are only resolved for pods the rule matches.
+func (r *Runner) observe(ctx context.Context, rule *Rule, pod *v1.Pod, events []v1.Event) Observations {
+	observed := Observations{Events: events}
+	if rule.triggers.NeedsDigests() && rule.matcher.Match(pod) {
+		observed.Digests = r.Options.Registry.PodDigests(ctx, pod, r.Client)
+	}
+	return observed
+}
+
+// selectPod reports whether the rule matches the pod and one of its triggers
+// fired, and which one.
+func selectPod(ctx context.Context, rule *Rule, pod *v1.Pod, observed Observations) (bool, string) {
+	if !rule.matcher.Match(pod) {
+		return false, ""
+	}
+	fired, trigger := rule.triggers.Evaluate(pod, observed, time.Now())
+	if !fired {
+		podLog(ctx, pod).with("rule", rule.Name).debugf("Pod %s matches but no trigger fired\n", pod.Name)
+		return false, ""
+	}
+	podLog(ctx, pod).with("rule", rule.Name, "trigger", trigger).infof("Matching pod found: %s\n", pod.Name)
+	return true, trigger
+}
+
+// forEach calls fn for every index below n on at most workers goroutines and
+// returns once all calls are done. With a single worker the calls run in
+// order on the calling goroutine.
+func forEach(n, workers int, fn func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	if workers > n {
+		workers = n
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// progress reports event to Options.Progress, if set.
+func (r *Runner) progress(event ProgressEvent) {
+	if r.Options.Progress != nil {
+		r.Options.Progress(event)
+	}
+}
+
+// reserveRestart takes one restart from the MaxRestarts budget and reports
+// whether one was left.
+func (r *Runner) reserveRestart(ctx context.Context) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Options.MaxRestarts > 0 && r.restarts >= r.Options.MaxRestarts {
+		return false
+	}
+	r.restarts++
+	if r.restarts == r.Options.MaxRestarts {
+		logFor(ctx).warnf("Reached --max-restarts (%d); remaining candidates are reported but not restarted\n", r.Options.MaxRestarts)
+	}
+	return true
+}
+
+// action names what the run does to the workloads of the rule, for the logs:
+// the operation, or the strategy of restarts other than rollouts.
+func (r *Runner) action(rule *Rule) string {
+	switch strategy := r.strategy(rule); {
+	case r.Options.Operation == OperationPause, r.Options.Operation == OperationResume:
+		return r.Options.Operation
+	case strategy != StrategyRollout:
+		return strategy
+	}
+	return OperationRestart
+}
+
+// strategy returns the restart strategy of the rule: its own, or --strategy.
+func (r *Runner) strategy(rule *Rule) string {
+	if rule.Strategy != "" {
+		return rule.Strategy
+	}
+	return r.Options.Strategy
+}
+
+// releaseRestart returns a reserved restart that did not happen.
+func (r *Runner) releaseRestart() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.restarts--
+}
+
+// limitResult marks result as skipped because MaxRestarts was reached.
+func (r *Runner) limitResult(result Result) Result {
+	result.Status, result.Reason = StatusSkipped, fmt.Sprintf("max restarts (%d) reached", r.Options.MaxRestarts)
+	return result
+}
+
+// claim records that source, such as "pod web-1", selected the workload and
+// reports whether it is the first to do so in this run; otherwise it returns
+// the first one.
+func (r *Runner) claim(workload *Workload, source string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if first, ok := r.handled[workload.String()]; ok {
+		return first, false
+	}
+	r.handled[workload.String()] = source
+	return "", true
+}
+
+func (r *Runner) processPod(ctx context.Context, rule *Rule, pod *v1.Pod, trigger string, nsAnnotations map[string]string) Result {
+	options, client := r.Options, r.Client
+	result := Result{Cluster: contextCluster(ctx), Rule: rule.Name, Namespace: pod.Namespace, Pod: pod.Name, Status: StatusMatched, Trigger: trigger}
+	if rule.Action == ActionReport {
+		return result
+	}
+	if stopped(options.Stop) {
+		result.Status, result.Reason = StatusSkipped, ReasonInterrupted
+		return result
+	}
+	if reason := r.canaryFailure(); reason != "" {
+		result.Status, result.Reason = StatusSkipped, reason
+		return result
+	}
+
+	resolveCtx, span := startSpan(ctx, "ResolveWorkload", attribute.String("k8s.namespace.name", pod.Namespace), attribute.String("k8s.pod.name", pod.Name))
+	var owners ownerReader = apiOwners{client}
+	if options.Cache != nil {
+		owners = options.Cache
+	}
+	workload, err := resolveWorkload(resolveCtx, pod, owners)
+	endSpan(span, err)
+	if err != nil && isOrphan(err) && options.DeleteOrphans {
+		return r.deleteOrphan(ctx, result, pod, err, nsAnnotations)
+	}
+	if err != nil {
+		podLog(ctx, pod).errorf("Error resolving workload for pod %s: %v\n", pod.Name, err)
+		result.fail(err)
+		return result
+	}
+	return r.processWorkload(ctx, rule, workload, "pod "+pod.Name, result, nsAnnotations)
+}
+
+// processWorkload applies the run's operation to a resolved workload, once per
+// run, subject to the opt-out annotations, Argo CD, cooldown, change freezes,
+// maintenance windows, PodDisruptionBudgets, policy, approvals and restart
+// limits, running the rule's hooks around the restart. source names what
+// selected it, e.g. "pod web-1".
+func (r *Runner) processWorkload(ctx context.Context, rule *Rule, workload *Workload, source string, result Result, nsAnnotations map[string]string) Result {
+	options, client := r.Options, r.Client
+	result.Kind, result.Workload, result.Release = workload.Kind, workload.Name, HelmRelease(workload)
+	kind := strings.ToLower(workload.Kind)
+	log := workloadLog(ctx, workload, r.action(rule)).with("rule", rule.Name)
+	if result.Pod != "" {
+		log = log.with("pod", result.Pod)
+	}
+
+	if first, ok := r.claim(workload, source); !ok {
+		log.debugf("Skipping %s %s/%s: already handled for %s\n", kind, workload.Namespace, workload.Name, first)
+		result.Status, result.Reason = StatusSkipped, "already handled for "+first
+		return result
+	}
+
+	if denied, reason := options.DenyList.Denies(workload.Namespace, workload, nsAnnotations); denied {
+		log.with("policy", "deny-list").warnf("Policy denial: not touching %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+		metricPolicyDenials.WithLabelValues(contextCluster(ctx), workload.Namespace).Inc()
+		result.Status, result.Reason = StatusSkipped, "denied: "+reason
+		return result
+	}
+
+	if rule.Release != "" {
+		if release := HelmRelease(workload); release != rule.Release {
+			reason := "not part of Helm release " + rule.Release
+			log.debugf("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+			result.Status, result.Reason = StatusSkipped, reason
+			return result
+		}
+	}
+	if rule.gate != nil {
+		if allowed, err := rule.gate.Eval(restarter.ExpressionWorkload, workload.Object); !allowed {
+			reason := "workloadExpression does not hold"
+			if err != nil {
+				reason = err.Error()
+			}
+			log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+			result.Status, result.Reason = StatusSkipped, reason
+			return result
+		}
+	}
+
+	if options.Operation == OperationPause || options.Operation == OperationResume {
+		return r.setPaused(ctx, workload, result, options.Operation == OperationPause)
+	}
+
+	if allowed, reason := RestartAllowed(workload.Annotations, nsAnnotations, options.OptInOnly); !allowed {
+		log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+		result.Status, result.Reason = StatusSkipped, reason
+		return result
+	}
+	app := ArgoCDApplication(workload)
+	if options.ArgoCD.skips(app) {
+		reason := "managed by Argo CD application " + app
+		log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+		result.Status, result.Reason = StatusSkipped, reason
+		return result
+	}
+	cooldown := options.Cooldown
+	if rule.Cooldown != nil {
+		cooldown = *rule.Cooldown
+	}
+	if cooling, reason := InCooldown(r.lastRestart(ctx, workload, cooldown), cooldown, time.Now()); cooling {
+		log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+		result.Status, result.Reason = StatusSkipped, reason
+		return result
+	}
+	if reason := r.freezeReason(ctx); reason != "" {
+		log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+		result.Status, result.Reason = StatusSkipped, reason
+		return result
+	}
+	windows := options.MaintenanceWindows
+	if rule.windows != nil {
+		windows = rule.windows
+	}
+	if now := time.Now(); !InMaintenanceWindow(windows, now) {
+		next := NextMaintenanceWindow(windows, now)
+		if options.OutsideWindow != OutsideWindowWait {
+			reason := "outside the maintenance windows, next opens at " + next.Format(time.RFC3339)
+			log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+			result.Status, result.Reason = StatusSkipped, reason
+			return result
+		}
+		result.Reason = "queued until " + next.Format(time.RFC3339)
+		if !options.DryRun {
+			log.infof("Holding the restart of %s until the maintenance window opens at %s\n", workload, next.Format(time.RFC3339))
+			if err := waitForMaintenanceWindow(ctx, windows, options.Stop); err == errInterrupted {
+				result.Status, result.Reason = StatusSkipped, ReasonInterrupted
+				return result
+			} else if err != nil {
+				result.fail(fmt.Errorf("maintenance window did not open: %w", err))
+				return result
+			}
+		}
+	}
+	strategy := r.strategy(rule)
+	if options.PDBCheck != PDBCheckOff {
+		blocked, reason, err := CheckDisruptionBudgets(ctx, workload, restartStrategies[strategy].Unavailable(workload), client)
+		if err != nil {
+			log.errorf("Error checking PodDisruptionBudgets for %s: %v\n", workload, err)
+			result.fail(err)
+			return result
+		}
+		if blocked && options.PDBCheck == PDBCheckWarn {
+			log.warnf("Restarting %s despite %s\n", workload, reason)
+		} else if blocked {
+			log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+			result.Status, result.Reason = StatusSkipped, reason
+			return result
+		}
+	}
+	if options.Policy != nil {
+		reason, err := r.checkPolicy(ctx, rule, workload, source, result, nsAnnotations)
+		if err != nil {
+			log.errorf("Error checking the policy for %s: %v\n", workload, err)
+			result.fail(err)
+			return result
+		}
+		if reason != "" {
+			log.with("policy", "opa").warnf("Policy denial: not restarting %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+			result.Status, result.Reason = StatusSkipped, reason
+			return result
+		}
+	}
+	if options.RequireApproval && !approvalGranted(workload.Annotations) {
+		return r.requestApproval(ctx, rule, workload, source, result, log)
+	}
+	if !r.reserveRestart(ctx) {
+		log.infof("Skipping %s %s/%s: max restarts reached\n", kind, workload.Namespace, workload.Name)
+		return r.limitResult(result)
+	}
+	if reason := r.limitNamespace(ctx, workload.Namespace); reason != "" {
+		r.releaseRestart()
+		log.infof("Skipping %s %s/%s: %s\n", kind, workload.Namespace, workload.Name, reason)
+		result.Status, result.Reason = StatusSkipped, reason
+		return result
+	}
+	if options.DryRun {
+		if r.takeCanary(workload) {
+			log.infof("[dry-run] Would restart %s %s/%s (%s) as the canary\n", kind, workload.Namespace, workload.Name, source)
+			r.settleCanary(true)
+		} else {
+			log.infof("[dry-run] Would restart %s %s/%s (%s)\n", kind, workload.Namespace, workload.Name, source)
+		}
+		result.Status = StatusDryRun
+		return result
+	}
+	if !options.Confirmer.Confirm(clusterPrefix(ctx) + fmt.Sprintf("Restart %s %s/%s?", kind, workload.Namespace, workload.Name)) {
+		r.releaseRestart()
+		r.unlimitNamespace(ctx, workload.Namespace)
+		log.infof("Skipping %s %s/%s\n", kind, workload.Namespace, workload.Name)
+		result.Status, result.Reason = StatusSkipped, "declined at prompt"
+		if stopped(options.Stop) {
+			result.Reason = ReasonInterrupted
+		}
+		return result
+	}
+	if err := r.runHooks(ctx, HookPreRestart, rule.PreRestart, rule, workload, result, log); err != nil {
+		r.releaseRestart()
+		r.unlimitNamespace(ctx, workload.Namespace)
+		log.errorf("Not restarting %s: %v\n", workload, err)
+		result.fail(err)
+		return result
+	}
+	r.progress(ProgressEvent{Phase: PhaseRestarting, Kind: workload.Kind, Namespace: workload.Namespace, Workload: workload.Name})
+	if r.takeCanary(workload) {
+		result = r.restartCanary(ctx, workload, strategy, source, result, log)
+	} else {
+		result = r.restart(ctx, workload, strategy, source, result, log, options.Wait)
+	}
+	options.Audit.Record(r.action(rule), options.Actor, result, source)
+	if options.RequireApproval {
+		if err := ClearApproval(ctx, workload, client); err != nil {
+			log.warnf("%v\n", err)
+		}
+	}
+	if result.Status == StatusRestarted {
+		options.History.Record(ctx, HistoryEntry{
+			Kind: workload.Kind, Namespace: workload.Namespace, Name: workload.Name,
+			Time: time.Now().UTC(), Action: r.action(rule), Rule: rule.Name, Trigger: result.Trigger, Source: source,
+		})
+		if app != "" {
+			if err := options.ArgoCD.Sync(ctx, app); err != nil {
+				log.warnf("%v\n", err)
+			}
+		}
+		r.annotate(ctx, workload, result, log)
+		if err := r.runHooks(ctx, HookPostRestart, rule.PostRestart, rule, workload, result, log); err != nil {
+			log.errorf("%v\n", err)
+			result.fail(err)
+		}
+	}
+	observeRestart(result)
+	if options.RecordEvents {
+		recordRestartEvent(ctx, workload, result, source, client, log)
+	}
+	return result
+}
+
+// freezeReason returns why restarts are frozen by the freeze ConfigMap, or
+// "". The ConfigMap is read at most once every freezeRefresh; when it cannot
+// be read, restarts are frozen.
+func (r *Runner) freezeReason(ctx context.Context) string {
+	if r.Options.Freeze == nil {
+		return ""
+	}
+	r.freezeMu.Lock()
+	defer r.freezeMu.Unlock()
+	if !r.frozenAt.IsZero() && time.Since(r.frozenAt) < freezeRefresh {
+		return r.frozen
+	}
+	frozen, err := r.Options.Freeze.Frozen(ctx, time.Now())
+	if err != nil {
+		logFor(ctx).warnf("Not restarting anything: %v\n", err)
+		frozen = "freeze ConfigMap unreadable"
+	} else if frozen != "" && frozen != r.frozen {
+		logFor(ctx).warnf("Not restarting anything: %s\n", frozen)
+	}
+	r.frozen, r.frozenAt = frozen, time.Now()
+	return r.frozen
+}
+
+// lastRestart returns when a workload was last restarted, going by its
+// restartedAt annotation and the history, or zero. Without a cooldown nothing
+// is looked up.
+func (r *Runner) lastRestart(ctx context.Context, workload *Workload, cooldown time.Duration) time.Time {
+	if cooldown <= 0 {
+		return time.Time{}
+	}
+	restartedAt, _ := LastRestart(workload)
+	if recorded, ok := r.Options.History.LastRestart(ctx, workload); ok && recorded.After(restartedAt) {
+		restartedAt = recorded
+	}
+	return restartedAt
+}
+
+// restart restarts a workload that passed every check with the named
+// strategy, waiting for its rollout when wait is set or the workload is
+// annotated with a health check, which is then checked.
+func (r *Runner) restart(ctx context.Context, workload *Workload, strategyName, source string, result Result, log logFields, wait bool) Result {
+	options, client := r.Options, r.Client
+	strategy := restartStrategies[strategyName]
+	endpoint, timeout := healthCheck(workload)
+	start := time.Now()
+	spanCtx, span := startSpan(ctx, "RestartWorkload", append(workloadAttributes(workload), attribute.String("restarter.strategy", strategyName))...)
+	done, err := strategy.Restart(spanCtx, workload, options.WaitTimeout, client)
+	endSpan(span, err)
+	metricRestartDuration.WithLabelValues(contextCluster(ctx), workload.Kind).Observe(time.Since(start).Seconds())
+	result.Reason = done
+	if err != nil {
+		log.errorf("Error restarting %s for %s: %v\n", strings.ToLower(workload.Kind), source, err)
+		result.fail(err)
+		return result
+	}
+	result.Status = StatusRestarted
+
+	if strategy.Waits() {
+		result.Rollout = RolloutComplete
+	} else if wait || endpoint != "" {
+		start := time.Now()
+		spanCtx, span := startSpan(ctx, "WaitForRollout", workloadAttributes(workload)...)
+		rollout, err := WaitForRollout(spanCtx, workload, options.WaitTimeout, client, func(message string) {
+			r.progress(ProgressEvent{Phase: PhaseRollout, Kind: workload.Kind, Namespace: workload.Namespace, Workload: workload.Name, Message: message})
+		})
+		span.SetAttributes(attribute.String("restarter.rollout", rollout))
+		endSpan(span, err)
+		metricRolloutDuration.WithLabelValues(contextCluster(ctx), workload.Kind, rollout).Observe(time.Since(start).Seconds())
+		result.Rollout = rollout
+		if err != nil {
+			log.errorf("Error waiting for %s: %v\n", workload, err)
+			result.fail(err)
+			if options.RollbackOnFailure && strategy.RollsBack() {
+				rollback(ctx, workload, &result, client)
+			}
+			return result
+		}
+	}
+	return r.checkHealth(ctx, workload, strategy, endpoint, timeout, result, log)
+}
+
+// checkHealth probes the health check endpoint of a restarted workload, if
+// any, and marks the restart failed and unhealthy when it does not pass.
+func (r *Runner) checkHealth(ctx context.Context, workload *Workload, strategy RestartStrategy, endpoint string, timeout time.Duration, result Result, log logFields) Result {
+	if endpoint == "" {
+		return result
+	}
+	log.infof("Checking the health of %s at %s\n", workload, endpoint)
+	if err := WaitForProbe(ctx, endpoint, timeout); err != nil {
+		log.errorf("%s is unhealthy after its restart: %v\n", workload, err)
+		result.Rollout = RolloutUnhealthy
+		result.fail(err)
+		if r.Options.RollbackOnFailure && strategy.RollsBack() {
+			rollback(ctx, workload, &result, r.Client)
+		}
+		return result
+	}
+	log.infof("%s is healthy\n", workload)
+	return result
+}
+
+// restartCanary restarts the canary of the run, waits for its rollout and
+// checks its health, and settles the canary with the outcome. A canary that
+// fails its health check is rolled back with RollbackOnFailure.
+func (r *Runner) restartCanary(ctx context.Context, workload *Workload, strategy, source string, result Result, log logFields) Result {
+	log.infof("Restarting %s as the canary\n", workload)
+	result = r.restart(ctx, workload, strategy, source, result, log, true)
+	if result.Status == StatusRestarted {
+		if err := verifyCanary(ctx, r.Options.Canary); err != nil {
+			log.errorf("Canary %s is unhealthy: %v\n", workload, err)
+			result.fail(fmt.Errorf("canary %w", err))
+			if r.Options.RollbackOnFailure && restartStrategies[strategy].RollsBack() {
+				rollback(ctx, workload, &result, r.Client)
+			}
+		}
+	}
+	if result.Status != StatusRestarted {
+		log.errorf("Canary %s failed; not restarting anything else\n", workload)
+		r.settleCanary(false)
+		return result
+	}
+	log.infof("Canary %s passed; restarting the rest\n", workload)
+	r.settleCanary(true)
+	return result
+}
+
+// setPaused pauses or resumes the rollout of a workload. The opt-out
+// annotations and cooldowns only govern restarts and do not apply here.
+func (r *Runner) setPaused(ctx context.Context, workload *Workload, result Result, paused bool) Result {
+	verb, prompt, status := "resume", "Resume", StatusResumed
+	if paused {
+		verb, prompt, status = "pause", "Pause", StatusPaused
+	}
+	log := workloadLog(ctx, workload, verb)
+
+	if r.Options.DryRun {
+		log.infof("[dry-run] Would %s the rollout of %s\n", verb, workload)
+		result.Status = StatusDryRun
+		return result
+	}
+	if !r.Options.Confirmer.Confirm(clusterPrefix(ctx) + fmt.Sprintf("%s the rollout of %s?", prompt, workload)) {
+		log.infof("Skipping %s\n", workload)
+		result.Status, result.Reason = StatusSkipped, "declined at prompt"
+		return result
+	}
+	if err := PauseWorkload(ctx, workload, paused, r.Client); err != nil {
+		log.errorf("Error trying to %s %s: %v\n", verb, workload, err)
+		result.fail(err)
+	} else {
+		result.Status = status
+	}
+	r.Options.Audit.Record(verb, r.Options.Actor, result, "")
+	return result
+}
+
+// rollback reverts a failed restart and records the outcome on result.
+func rollback(ctx context.Context, workload *Workload, result *Result, client kubernetes.Interface) {
+	log := workloadLog(ctx, workload, "rollback")
+	if err := RollbackRestart(ctx, workload, client); err != nil {
+		log.errorf("ROLLBACK FAILED for %s: %v\n", workload, err)
+		result.Error += "; rollback failed: " + err.Error()
+		return
+	}
+	log.errorf("ROLLED BACK %s after a failed rollout\n", workload)
+	result.Rollout = RolloutRolledBack
+}
+
+// deleteOrphan deletes (or evicts) a matched pod that has no workload to
+// restart.
+func (r *Runner) deleteOrphan(ctx context.Context, result Result, pod *v1.Pod, orphanErr error, nsAnnotations map[string]string) Result {
+	options, client := r.Options, r.Client
+	result.Kind, result.Workload, result.Reason = "Pod", pod.Name, orphanErr.Error()
+	verb, prompt := "delete", "Delete"
+	if options.EvictOrphans {
+		verb, prompt = "evict", "Evict"
+	}
+	log := podLog(ctx, pod).with("action", verb)
+
+	if denied, reason := options.DenyList.Denies(pod.Namespace, nil, nsAnnotations); denied {
+		log.with("policy", "deny-list").warnf("Policy denial: not touching orphaned pod %s/%s: %s\n", pod.Namespace, pod.Name, reason)
+		metricPolicyDenials.WithLabelValues(contextCluster(ctx), pod.Namespace).Inc()
+		result.Status, result.Reason = StatusSkipped, "denied: "+reason
+		return result
+	}
+	if !r.reserveRestart(ctx) {
+		log.infof("Skipping orphaned pod %s/%s: max restarts reached\n", pod.Namespace, pod.Name)
+		return r.limitResult(result)
+	}
+
+	if options.DryRun {
+		log.infof("[dry-run] Would %s orphaned pod %s/%s: %v\n", verb, pod.Namespace, pod.Name, orphanErr)
+		result.Status = StatusDryRun
+		return result
+	}
+	if !options.Confirmer.Confirm(clusterPrefix(ctx) + fmt.Sprintf("%s orphaned pod %s/%s?", prompt, pod.Namespace, pod.Name)) {
+		r.releaseRestart()
+		log.infof("Skipping pod %s/%s\n", pod.Namespace, pod.Name)
+		result.Status, result.Reason = StatusSkipped, "declined at prompt"
+		return result
+	}
+	if err := DeletePod(ctx, pod, options.EvictOrphans, client); err != nil {
+		log.errorf("Error deleting orphaned pod %s: %v\n", pod.Name, err)
+		result.fail(err)
+	} else {
+		result.Status = StatusDeleted
+	}
+	options.Audit.Record(verb, options.Actor, result, "")
+	return result
+}
+
+// DefaultExcludedNamespaces are the control-plane namespaces skipped unless
+// --exclude-namespaces is overridden.
+var DefaultExcludedNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// TargetNamespaces returns the namespaces a rule applies to. Explicit
+// namespaces are used as-is; otherwise the cluster's namespaces are listed,
+// filtered by the rule's namespace selector. Globally and rule-excluded
+// namespaces are always removed, even when explicitly included.
+func TargetNamespaces(ctx context.Context, rule *Rule, exclude []string, client kubernetes.Interface) ([]string, error) {
+	candidates := rule.Namespaces
+	if len(candidates) == 0 {
+		namespaces, err := apiClient(client).ListNamespaces(ctx, metav1.ListOptions{LabelSelector: rule.NamespaceSelector})
+		if err != nil {
+			return nil, err
+		}
+		for _, namespace := range namespaces.Items {
+			candidates = append(candidates, namespace.Name)
+		}
+	}
+	return filterNamespaces(rule, candidates, exclude), nil
+}
+
+// targetNamespaces is TargetNamespaces, with the namespaces read from the
+// cache when there is one.
+func (r *Runner) targetNamespaces(ctx context.Context, rule *Rule) ([]string, error) {
+	cache := r.Options.Cache
+	if cache == nil || cache.namespaces == nil || len(rule.Namespaces) > 0 {
+		return TargetNamespaces(ctx, rule, r.Options.ExcludeNamespaces, r.Client)
+	}
+	candidates, err := cache.Namespaces(rule.NamespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	return filterNamespaces(rule, candidates, r.Options.ExcludeNamespaces), nil
+}
+
+// filterNamespaces drops the namespaces excluded globally or by the rule.
+func filterNamespaces(rule *Rule, candidates []string, exclude []string) []string {
+	names := make([]string, 0, len(candidates))
+	for _, name := range candidates {
+		if namespaceExcluded(rule, name, exclude) {
+			debugf("Skipping excluded namespace: %s\n", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// namespaceExcluded reports whether name is excluded globally or by the rule.
+func namespaceExcluded(rule *Rule, name string, exclude []string) bool {
+	for _, excluded := range exclude {
+		if excluded == name {
+			return true
+		}
+	}
+	for _, excluded := range rule.ExcludeNamespaces {
+		if excluded == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ParseSchedule validates a cron expression. Standard five-field expressions,
+// descriptors such as @daily or @every 1h, and a CRON_TZ=<zone> prefix are
+// accepted.
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+	}
+	return schedule, nil
+}
+
+// withTimezone applies timezone to a schedule that does not name its own.
+func withTimezone(spec, timezone string) string {
+	if timezone == "" || strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return spec
+	}
+	return "CRON_TZ=" + timezone + " " + spec
+}
+
+// Scheduler runs each rule at the times given by its schedules.
+type Scheduler struct {
+	Client  kubernetes.Interface
+	Rules   []Rule
+	Options RunOptions
+	// Schedules apply to rules without schedules of their own. Rules left
+	// without any schedule run every Interval, or their own interval.
+	Schedules []string
+	Interval  time.Duration
+	// Timezone applies to schedules that do not set CRON_TZ; empty means the
+	// local timezone.
+	Timezone string
+	// RunContext derives the context of a single run, e.g. to apply --timeout.
+	RunContext func(ctx context.Context) (context.Context, context.CancelFunc)
+	Output     io.Writer
+	Format     string
+
+	writeMu sync.Mutex
+}
+
+// ruleRuns tracks the runs of one scheduled rule.
+type ruleRuns struct {
+	// running is held while the rule runs.
+	running sync.Mutex
+	// failures counts consecutive failed runs; retry is the pending retry
+	// of the last one. Both are guarded by running.
+	failures int
+	retry    *time.Timer
+}
+
+// Run schedules every rule and blocks until ctx is cancelled, then waits for
+// running rules to finish. A rule whose previous run is still going when it
+// fires again is skipped. Rules that run on an interval also run once at
+// start, like watch without schedules. Failed runs of rules with a backoff
+// are retried before their next scheduled run.
+func (s *Scheduler) Run(ctx context.Context) error {
+	c := cron.New()
+	runs := make([]ruleRuns, len(s.Rules))
+	var startup []func()
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		state := &runs[i]
+		job := func() {
+			if !state.running.TryLock() {
+				warnf("Skipping scheduled run of rule %s: the previous run is still going\n", rule.Name)
+				return
+			}
+			defer state.running.Unlock()
+			s.runScheduled(ctx, rule, state)
+		}
+		schedules := rule.Schedules
+		timezone := rule.Timezone
+		if len(schedules) == 0 {
+			schedules = s.Schedules
+		}
+		if timezone == "" {
+			timezone = s.Timezone
+		}
+		if len(schedules) == 0 {
+			interval := s.Interval
+			if rule.Interval != nil {
+				interval = *rule.Interval
+			}
+			schedules = []string{"@every " + interval.String()}
+			startup = append(startup, job)
+		}
+
+		for _, spec := range schedules {
+			spec = withTimezone(spec, timezone)
+			schedule, err := ParseSchedule(spec)
+			if err != nil {
+				return configError("rule %s: %v", rule.Name, err)
+			}
+			c.Schedule(schedule, cron.FuncJob(job))
+			infof("Scheduled rule %s at %q, next run at %s\n", rule.Name, spec, schedule.Next(time.Now()).Format(time.RFC3339))
+		}
+	}
+
+	c.Start()
+	for _, job := range startup {
+		go job()
+	}
+	<-ctx.Done()
+	infof("Shutting down, waiting for running rules\n")
+	<-c.Stop().Done()
+	// Waiting for each rule also waits for retries in progress; later ones
+	// return straight away since ctx is done.
+	for i := range runs {
+		runs[i].running.Lock()
+		if runs[i].retry != nil {
+			runs[i].retry.Stop()
+		}
+		runs[i].running.Unlock()
+	}
+	return nil
+}
+
+// runScheduled runs the rule with state.running held. When the run fails and
+// the rule has a backoff, it schedules a retry; any run replaces the pending
+// retry.
+func (s *Scheduler) runScheduled(ctx context.Context, rule *Rule, state *ruleRuns) {
+	if state.retry != nil {
+		state.retry.Stop()
+		state.retry = nil
+	}
+	if !s.runRule(ctx, rule) || ctx.Err() != nil {
+		state.failures = 0
+		return
+	}
+	state.failures++
+	if rule.Backoff == nil {
+		return
+	}
+	delay := rule.Backoff.Delay(state.failures)
+	withFields("rule", rule.Name, "failures", state.failures).infof("Rule %s failed %d time(s) in a row, retrying in %s\n", rule.Name, state.failures, delay)
+	state.retry = time.AfterFunc(delay, func() {
+		if !state.running.TryLock() {
+			return
+		}
+		defer state.running.Unlock()
+		s.runScheduled(ctx, rule, state)
+	})
+}
+
+// runRule processes a single rule once, writes its results and reports
+// whether the run failed.
+func (s *Scheduler) runRule(ctx context.Context, rule *Rule) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	runCtx, cancel := s.RunContext(ctx)
+	defer cancel()
+	runner := NewRunner(s.Client, s.Options)
+	results, listFailed := runner.Run(runCtx, []Rule{*rule})
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := WriteResults(s.Output, s.Format, results); err != nil {
+		errorf("Error writing results: %v\n", err)
+	}
+	logSummary(runner.Report())
+	return runFailed(results, listFailed)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "fmt"
+
+// Scopes of the RBAC the tool runs with.
+const (
+	// ScopeCluster lists namespaces and reads their annotations, which needs
+	// a ClusterRole.
+	ScopeCluster = "cluster"
+	// ScopeNamespace only touches namespaced resources of explicitly named
+	// namespaces, so a Role in each of them is enough.
+	ScopeNamespace = "namespace"
+)
+
+// ValidateScope rejects unknown --scope values.
+func ValidateScope(scope string) error {
+	switch scope {
+	case ScopeCluster, ScopeNamespace:
+		return nil
+	}
+	return fmt.Errorf("unknown scope %q (want %s or %s)", scope, ScopeCluster, ScopeNamespace)
+}
+
+// scopeRules pins rules without namespaces to the given ones in namespace
+// scope, so TargetNamespaces never lists the cluster's namespaces. Namespace
+// selectors need to read Namespace objects and are rejected.
+func scopeRules(rules []Rule, scope string, namespaces []string) ([]Rule, error) {
+	if scope != ScopeNamespace {
+		return rules, nil
+	}
+	for i := range rules {
+		if rules[i].NamespaceSelector != "" {
+			return nil, fmt.Errorf("rule %s: namespaceSelector is not supported with --scope=%s", rules[i].Name, ScopeNamespace)
+		}
+		if len(rules[i].Namespaces) == 0 {
+			rules[i].Namespaces = namespaces
+		}
+	}
+	return rules, nil
+}
+
+// singleNamespace returns the only namespace the rules target, or an error
+// when they target several. Informers in namespace scope watch one namespace.
+func singleNamespace(rules []Rule) (string, error) {
+	namespace := ""
+	for i := range rules {
+		for _, name := range rules[i].Namespaces {
+			if namespace != "" && name != namespace {
+				return "", fmt.Errorf("--scope=%s watches a single namespace, the rules target %s and %s", ScopeNamespace, namespace, name)
+			}
+			namespace = name
+		}
+	}
+	return namespace, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptible handles SIGINT and SIGTERM for a one-shot run. The first
+// signal closes the returned channel, so the run stops starting new work while
+// restarts in progress finish; a second one cancels the returned context and
+// aborts them. release stops the handling.
+func interruptible(ctx context.Context) (runCtx context.Context, stop <-chan struct{}, release func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	stopping := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			warnf("Received %s, finishing the restarts in progress; send it again to abort them\n", sig)
+			close(stopping)
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			warnf("Received %s again, aborting\n", sig)
+			cancel()
+		case <-done:
+		}
+	}()
+	return ctx, stopping, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// errInterrupted is returned by waits that were abandoned because the run was
+// interrupted.
+var errInterrupted = errors.New("interrupted")
+
+// stopped reports whether stop is closed. A nil channel is never closed.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
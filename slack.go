@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// DefaultSlackTemplate renders the summary line followed by the changed
+// workloads and the failures.
+const DefaultSlackTemplate = `*restarter {{.Operation}}{{if .Cluster}} on {{.Cluster}}{{end}}{{if .DryRun}} (dry run){{end}}*: {{.Summary}}
+{{- if .ListFailed}}
+:warning: some namespaces or pods could not be listed
+{{- end}}
+{{- range .Changed}}
+• {{.Status}} {{if .Workload}}{{lower .Kind}} {{.Namespace}}/{{.Workload}}{{else}}pod {{.Namespace}}/{{.Pod}}{{end}}{{if .Rollout}} (rollout {{.Rollout}}){{end}}
+{{- end}}
+{{- range .Failed}}
+• :x: {{if .Workload}}{{lower .Kind}} {{.Namespace}}/{{.Workload}}{{else}}pod {{.Namespace}}/{{.Pod}}{{end}}: {{.Error}}
+{{- end}}`
+
+// ParseNotifyTemplate parses a Go text/template rendered with a RunReport.
+// Besides the built-in functions, templates can use lower and upper.
+func ParseNotifyTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// renderNotifyTemplate executes the template with the report.
+func renderNotifyTemplate(tmpl *template.Template, report RunReport) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, report); err != nil {
+		return "", fmt.Errorf("error rendering the message: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// SlackNotifier posts run summaries to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	// Channel overrides the webhook's default channel, for webhooks that
+	// allow it.
+	Channel  string
+	Template *template.Template
+	HTTP     *http.Client
+}
+
+// Name implements Notifier.
+func (s *SlackNotifier) Name() string {
+	return "Slack"
+}
+
+// Notify implements Notifier.
+func (s *SlackNotifier) Notify(ctx context.Context, report RunReport) error {
+	text, err := renderNotifyTemplate(s.Template, report)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.HTTP, s.WebhookURL, nil, struct {
+		Text    string `json:"text"`
+		Channel string `json:"channel,omitempty"`
+	}{text, s.Channel})
+}
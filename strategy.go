@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Restart strategies.
+const (
+	// StrategyRollout restarts a workload by bumping its pod template, letting
+	// the controller replace the pods according to its update strategy.
+	StrategyRollout = "rollout"
+	// StrategyEvict restarts a workload by evicting its pods one at a time
+	// through the Eviction API, so PodDisruptionBudgets are honored.
+	StrategyEvict = "evict"
+	// StrategyDelete restarts a workload by deleting all its pods at once,
+	// for workloads whose pods must never run two versions side by side.
+	StrategyDelete = "delete"
+	// StrategyScaleCycle restarts a Deployment or StatefulSet by scaling it
+	// to zero and back, for workloads that must stop completely before
+	// starting again.
+	StrategyScaleCycle = "scale-cycle"
+)
+
+// RestartStrategy is a way of restarting a workload that passed every check.
+type RestartStrategy interface {
+	// Restart restarts the workload, taking at most timeout for every step
+	// it waits for. It returns what it did for the result's reason, if
+	// anything worth reporting.
+	Restart(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface) (string, error)
+	// Waits reports whether Restart only returns once the pods have been
+	// replaced, leaving no rollout to wait for.
+	Waits() bool
+	// RollsBack reports whether a failed restart can be rolled back by
+	// restoring the pod template's restartedAt annotation.
+	RollsBack() bool
+	// Unavailable returns how many pods of the workload the restart takes
+	// down at once, for the PodDisruptionBudget check; 0 skips the check.
+	Unavailable(workload *Workload) int32
+}
+
+// restartStrategies holds the strategies --strategy and rules can select.
+var restartStrategies = map[string]RestartStrategy{
+	StrategyRollout:    rolloutStrategy{},
+	StrategyEvict:      evictStrategy{},
+	StrategyDelete:     deleteStrategy{},
+	StrategyScaleCycle: scaleCycleStrategy{},
+}
+
+// strategyNames lists the strategies in restartStrategies, sorted.
+func strategyNames() []string {
+	names := make([]string, 0, len(restartStrategies))
+	for name := range restartStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateStrategy rejects unknown --strategy values.
+func ValidateStrategy(strategy string) error {
+	if _, ok := restartStrategies[strategy]; ok {
+		return nil
+	}
+	return fmt.Errorf("unknown strategy %q (want one of %s)", strategy, strings.Join(strategyNames(), ", "))
+}
+
+// rolloutStrategy implements StrategyRollout.
+type rolloutStrategy struct{}
+
+func (rolloutStrategy) Restart(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface) (string, error) {
+	return "", RestartWorkload(ctx, workload, client)
+}
+
+func (rolloutStrategy) Waits() bool     { return false }
+func (rolloutStrategy) RollsBack() bool { return true }
+
+func (rolloutStrategy) Unavailable(workload *Workload) int32 {
+	return rolloutUnavailable(workload)
+}
+
+// evictStrategy implements StrategyEvict with EvictWorkload.
+type evictStrategy struct{}
+
+func (evictStrategy) Restart(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface) (string, error) {
+	evicted, err := EvictWorkload(ctx, workload, timeout, client)
+	return fmt.Sprintf("evicted %d pods", evicted), err
+}
+
+func (evictStrategy) Waits() bool     { return true }
+func (evictStrategy) RollsBack() bool { return false }
+
+// Unavailable is 0: evictions honor PodDisruptionBudgets themselves.
+func (evictStrategy) Unavailable(workload *Workload) int32 { return 0 }
+
+// deleteStrategy implements StrategyDelete. It waits for the deleted pods to
+// go away, not for their replacements.
+type deleteStrategy struct{}
+
+func (deleteStrategy) Restart(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface) (string, error) {
+	pods, err := WorkloadPods(ctx, workload, client)
+	if err != nil {
+		return "", err
+	}
+	deleted := 0
+	for i := range pods {
+		if pods[i].DeletionTimestamp != nil {
+			continue
+		}
+		if err := DeletePod(ctx, &pods[i], false, client); err != nil {
+			return fmt.Sprintf("deleted %d pods", deleted), err
+		}
+		deleted++
+	}
+	for i := range pods {
+		if err := waitForPodGone(ctx, &pods[i], timeout, client); err != nil {
+			return fmt.Sprintf("deleted %d pods", deleted), err
+		}
+	}
+	return fmt.Sprintf("deleted %d pods", deleted), nil
+}
+
+func (deleteStrategy) Waits() bool     { return false }
+func (deleteStrategy) RollsBack() bool { return false }
+
+func (deleteStrategy) Unavailable(workload *Workload) int32 {
+	return workloadReplicas(workload)
+}
+
+// scaleCycleStrategy implements StrategyScaleCycle through the scale
+// subresource. The workload is scaled back up even when its pods do not go
+// away within the timeout or the run is interrupted, so it is never left at
+// zero replicas by the restarter.
+type scaleCycleStrategy struct{}
+
+// scaler is the scale subresource of Deployments and StatefulSets.
+type scaler interface {
+	GetScale(ctx context.Context, name string, options metav1.GetOptions) (*autoscalingv1.Scale, error)
+	UpdateScale(ctx context.Context, name string, scale *autoscalingv1.Scale, options metav1.UpdateOptions) (*autoscalingv1.Scale, error)
+}
+
+func (scaleCycleStrategy) Restart(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface) (string, error) {
+	var scales scaler
+	switch workload.Kind {
+	case KindDeployment:
+		scales = client.AppsV1().Deployments(workload.Namespace)
+	case KindStatefulSet:
+		scales = client.AppsV1().StatefulSets(workload.Namespace)
+	default:
+		return "", fmt.Errorf("%s cannot be restarted with --strategy=%s: only Deployments and StatefulSets scale", workload, StrategyScaleCycle)
+	}
+
+	replicas, err := scaleTo(ctx, workload, scales, 0)
+	if err != nil {
+		return "", err
+	}
+	if replicas == 0 {
+		return "already scaled to 0", nil
+	}
+	workloadLog(ctx, workload, StrategyScaleCycle).infof("Scaled %s from %d to 0 replicas\n", workload, replicas)
+	waitErr := waitForNoPods(ctx, workload, timeout, client)
+
+	// A fresh context, so an interrupt does not leave the workload at zero.
+	if _, err := scaleTo(context.Background(), workload, scales, replicas); err != nil {
+		return "", fmt.Errorf("error scaling %s back to %d replicas: %w", workload, replicas, err)
+	}
+	workloadLog(ctx, workload, StrategyScaleCycle).infof("Scaled %s back to %d replicas\n", workload, replicas)
+	if waitErr != nil {
+		return "", waitErr
+	}
+	return fmt.Sprintf("scaled from %d to 0 and back", replicas), nil
+}
+
+func (scaleCycleStrategy) Waits() bool     { return false }
+func (scaleCycleStrategy) RollsBack() bool { return false }
+
+func (scaleCycleStrategy) Unavailable(workload *Workload) int32 {
+	return workloadReplicas(workload)
+}
+
+// scaleTo sets the replicas of the workload through its scale subresource
+// and returns how many it had.
+func scaleTo(ctx context.Context, workload *Workload, scales scaler, replicas int32) (int32, error) {
+	var previous int32
+	err := callAPI(ctx, func(ctx context.Context) error {
+		scale, err := scales.GetScale(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		previous = scale.Spec.Replicas
+		if previous == replicas {
+			return nil
+		}
+		scale.Spec.Replicas = replicas
+		_, err = scales.UpdateScale(ctx, workload.Name, scale, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error scaling %s: %w", workload, err)
+	}
+	return previous, nil
+}
+
+// waitForNoPods waits until the workload no longer has pods.
+func waitForNoPods(ctx context.Context, workload *Workload, timeout time.Duration, client kubernetes.Interface) error {
+	err := wait.PollImmediateWithContext(ctx, time.Second, timeout, func(ctx context.Context) (bool, error) {
+		pods, err := WorkloadPods(ctx, workload, client)
+		return len(pods) == 0, err
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("the pods of %s were not removed within %s", workload, timeout)
+	}
+	return err
+}
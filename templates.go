@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// templateFuncs are the functions templates can use besides the built-in
+// ones.
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// ResultTemplateData is what --restart-annotation and --alert-template
+// templates are rendered with: a result, with the run it belongs to.
+type ResultTemplateData struct {
+	Result
+	// RunID identifies the run, the same in every result, notification and
+	// annotation of the run.
+	RunID string
+	// Actor is the Kubernetes identity the run acts as.
+	Actor string
+	// Time is when the template is rendered.
+	Time time.Time
+}
+
+// newRunID returns a new run ID.
+func newRunID() string {
+	return string(uuid.NewUUID())
+}
+
+// ParseResultTemplate parses a Go text/template rendered with a
+// ResultTemplateData.
+func ParseResultTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// renderResultTemplate executes the template for a result of the run runID,
+// acting as actor.
+func renderResultTemplate(tmpl *template.Template, result Result, runID, actor string) (string, error) {
+	var buf bytes.Buffer
+	data := ResultTemplateData{Result: result, RunID: runID, Actor: actor, Time: time.Now().UTC()}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error rendering %s: %v", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// ParseRestartAnnotations parses --restart-annotation values, written as
+// KEY=TEMPLATE, e.g. "example.com/restarted-by={{.Actor}}".
+func ParseRestartAnnotations(specs []string) (map[string]*template.Template, error) {
+	annotations := map[string]*template.Template{}
+	for _, spec := range specs {
+		key, text, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid annotation %q (want KEY=TEMPLATE)", spec)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid annotation key %q: %s", key, strings.Join(errs, "; "))
+		}
+		tmpl, err := ParseResultTemplate(key, text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of annotation %s: %v", key, err)
+		}
+		annotations[key] = tmpl
+	}
+	return annotations, nil
+}
+
+// annotate writes the run's restart annotations to a restarted workload.
+// Failures are only logged: the restart itself succeeded.
+func (r *Runner) annotate(ctx context.Context, workload *Workload, result Result, log logFields) {
+	if len(r.Options.Annotations) == 0 {
+		return
+	}
+	annotations := map[string]*string{}
+	for key, tmpl := range r.Options.Annotations {
+		value, err := renderResultTemplate(tmpl, result, r.Options.RunID, r.Options.Actor)
+		if err != nil {
+			log.warnf("Not annotating %s with %s: %v\n", workload, key, err)
+			continue
+		}
+		annotations[key] = &value
+	}
+	if len(annotations) == 0 {
+		return
+	}
+	if err := patchAnnotations(ctx, workload, annotations, r.Client); err != nil {
+		log.warnf("Error annotating %s: %v\n", workload, err)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// DefaultOOMWindow is how recent an OOM kill must be for the OOM trigger.
+const DefaultOOMWindow = time.Hour
+
+// DefaultRestartWindow is how recent the last container restart must be for
+// the restart-count trigger.
+const DefaultRestartWindow = time.Hour
+
+// DefaultEventWindow is how far back event triggers count occurrences.
+const DefaultEventWindow = time.Hour
+
+// unhealthyWaitingReasons are container waiting reasons that mark a pod as
+// unhealthy.
+var unhealthyWaitingReasons = map[string]bool{
+	"CrashLoopBackOff": true,
+	"ImagePullBackOff": true,
+	"ErrImagePull":     true,
+}
+
+// Triggers narrow the matched pods down to those in a state worth acting on.
+// A pod fires when any configured trigger fires; without triggers every
+// matched pod fires. OlderThan is a filter applied before the triggers.
+type Triggers struct {
+	// OlderThan only lets through pods that have been running at least this
+	// long. Zero disables the filter.
+	OlderThan time.Duration
+	// OnlyUnhealthy fires for pods in CrashLoopBackOff or ImagePullBackOff, or
+	// running but not Ready.
+	OnlyUnhealthy bool
+	// OOMKills fires for pods with a container whose last termination was an
+	// OOM kill within OOMWindow and that has restarted at least OOMKills
+	// times. Zero disables the trigger.
+	OOMKills  int32
+	OOMWindow time.Duration
+	// RestartCount fires for pods with a container that has restarted at
+	// least this many times, the last time within RestartWindow. Zero
+	// disables the trigger.
+	RestartCount  int32
+	RestartWindow time.Duration
+	// Events fire for pods with recent Kubernetes Events of a given reason.
+	Events []EventTrigger
+	// ImageDrift fires for pods with a container whose image tag now points to
+	// a different digest than the one it runs.
+	ImageDrift bool
+}
+
+// Observations are what triggers look at besides the pod itself.
+type Observations struct {
+	// Events involve the pod; only event triggers use them.
+	Events []v1.Event
+	// Digests map the pod's images to the digests their tags currently
+	// resolve to; only the image drift trigger uses them.
+	Digests map[string]string
+}
+
+// EventTrigger fires when the Events of a pod with Reason, and a message
+// containing Message if set, occurred at least Count times within Window.
+type EventTrigger struct {
+	Reason  string
+	Message string
+	Count   int32
+	Window  time.Duration
+}
+
+// configured reports whether any trigger is set.
+func (t *Triggers) configured() bool {
+	return t.OnlyUnhealthy || t.OOMKills > 0 || t.RestartCount > 0 || len(t.Events) > 0 || t.ImageDrift
+}
+
+// Active reports whether the pod selection is narrowed by any trigger or by
+// the age filter.
+func (t *Triggers) Active() bool {
+	return t.configured() || t.OlderThan > 0
+}
+
+// NeedsEvents reports whether Evaluate needs the pod's Events.
+func (t *Triggers) NeedsEvents() bool {
+	return len(t.Events) > 0
+}
+
+// NeedsDigests reports whether Evaluate needs the digests of the pod's
+// images.
+func (t *Triggers) NeedsDigests() bool {
+	return t.ImageDrift
+}
+
+// NeedsPodStatus reports whether Evaluate looks at more of the pod than its
+// metadata, i.e. at its spec or status. Event triggers only need its UID.
+func (t *Triggers) NeedsPodStatus() bool {
+	return t.OlderThan > 0 || t.OnlyUnhealthy || t.OOMKills > 0 || t.RestartCount > 0 || t.ImageDrift
+}
+
+// Evaluate reports whether the pod fires and, if a trigger was responsible,
+// which one.
+func (t *Triggers) Evaluate(pod *v1.Pod, observed Observations, now time.Time) (bool, string) {
+	age, started := PodAge(pod, now)
+	if t.OlderThan > 0 && (!started || age < t.OlderThan) {
+		return false, ""
+	}
+	if !t.configured() {
+		if t.OlderThan > 0 {
+			return true, fmt.Sprintf("pod running for %s", age.Round(time.Minute))
+		}
+		return true, ""
+	}
+	if t.OnlyUnhealthy {
+		if unhealthy, reason := PodUnhealthy(pod); unhealthy {
+			return true, reason
+		}
+	}
+	if t.OOMKills > 0 {
+		if killed, reason := RecentlyOOMKilled(pod, t.OOMKills, t.OOMWindow, now); killed {
+			return true, reason
+		}
+	}
+	if t.RestartCount > 0 {
+		if restarting, reason := FrequentlyRestarted(pod, t.RestartCount, t.RestartWindow, now); restarting {
+			return true, reason
+		}
+	}
+	for _, trigger := range t.Events {
+		if fired, reason := trigger.Evaluate(observed.Events, now); fired {
+			return true, reason
+		}
+	}
+	if t.ImageDrift {
+		if drifted, reason := ImageDrifted(pod, observed.Digests); drifted {
+			return true, reason
+		}
+	}
+	return false, ""
+}
+
+// ImageDrifted reports whether a container of the pod runs another digest
+// than the one its image tag resolves to now. Only containers that pull their
+// image on every start count, since a restart would not fix the others;
+// neither do containers that have not pulled their image yet, or whose image
+// is missing from digests.
+func ImageDrifted(pod *v1.Pod, digests map[string]string) (bool, string) {
+	images := map[string]string{}
+	for _, container := range pod.Spec.Containers {
+		if container.ImagePullPolicy == v1.PullAlways {
+			images[container.Name] = container.Image
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		image, ok := images[status.Name]
+		if !ok {
+			continue
+		}
+		current := digests[image]
+		_, running, ok := strings.Cut(status.ImageID, "@")
+		if current == "" || !ok || running == current {
+			continue
+		}
+		return true, fmt.Sprintf("image %s moved to %s (running %s)", image, shortDigest(current), shortDigest(running))
+	}
+	return false, ""
+}
+
+// shortDigest abbreviates a digest the way docker does.
+func shortDigest(digest string) string {
+	algorithm, hash, ok := strings.Cut(digest, ":")
+	if ok && len(hash) > 12 {
+		return algorithm + ":" + hash[:12]
+	}
+	return digest
+}
+
+// Evaluate counts the occurrences of matching events seen within the window.
+// An Event aggregates repeats in its count, so that count is used for events
+// last seen within the window.
+func (e EventTrigger) Evaluate(events []v1.Event, now time.Time) (bool, string) {
+	var occurrences int32
+	for i := range events {
+		event := &events[i]
+		if event.Reason != e.Reason || (e.Message != "" && !strings.Contains(event.Message, e.Message)) {
+			continue
+		}
+		if e.Window > 0 && now.Sub(eventLastSeen(event)) > e.Window {
+			continue
+		}
+		occurrences += eventCount(event)
+	}
+	if occurrences < e.Count {
+		return false, ""
+	}
+	return true, fmt.Sprintf("%d %s events", occurrences, e.Reason)
+}
+
+// eventLastSeen returns when the event was last observed.
+func eventLastSeen(event *v1.Event) time.Time {
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// eventCount returns how many times the event occurred.
+func eventCount(event *v1.Event) int32 {
+	switch {
+	case event.Series != nil && event.Series.Count > 0:
+		return event.Series.Count
+	case event.Count > 0:
+		return event.Count
+	}
+	return 1
+}
+
+// PodUnhealthy reports whether the pod is unhealthy and why.
+func PodUnhealthy(pod *v1.Pod) (bool, string) {
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil && unhealthyWaitingReasons[waiting.Reason] {
+			return true, fmt.Sprintf("container %s is in %s", status.Name, waiting.Reason)
+		}
+	}
+	if pod.Status.Phase != v1.PodRunning {
+		return false, ""
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady && condition.Status != v1.ConditionTrue {
+			return true, "pod is not Ready"
+		}
+	}
+	return false, ""
+}
+
+// RecentlyOOMKilled reports whether a container of the pod was last
+// terminated by the OOM killer within window and has restarted at least
+// threshold times. The kubelet only keeps the last termination, so the
+// restart count of an OOM-killed container stands in for its OOM count.
+func RecentlyOOMKilled(pod *v1.Pod, threshold int32, window time.Duration, now time.Time) (bool, string) {
+	for _, status := range pod.Status.ContainerStatuses {
+		terminated := status.LastTerminationState.Terminated
+		if terminated == nil || terminated.Reason != "OOMKilled" || status.RestartCount < threshold {
+			continue
+		}
+		if window > 0 && now.Sub(terminated.FinishedAt.Time) > window {
+			continue
+		}
+		return true, fmt.Sprintf("container %s was OOMKilled (%d restarts)", status.Name, status.RestartCount)
+	}
+	return false, ""
+}
+
+// FrequentlyRestarted reports whether a container of the pod has restarted at
+// least threshold times and was last terminated within window. The restart
+// count is cumulative, so the window only ensures the container is still
+// restarting rather than having recovered long ago.
+func FrequentlyRestarted(pod *v1.Pod, threshold int32, window time.Duration, now time.Time) (bool, string) {
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.RestartCount < threshold {
+			continue
+		}
+		terminated := status.LastTerminationState.Terminated
+		if window > 0 && (terminated == nil || now.Sub(terminated.FinishedAt.Time) > window) {
+			continue
+		}
+		return true, fmt.Sprintf("container %s restarted %d times", status.Name, status.RestartCount)
+	}
+	return false, ""
+}
+
+// PodAge returns how long the pod has been running. It reports false for pods
+// that the kubelet has not started yet.
+func PodAge(pod *v1.Pod, now time.Time) (time.Duration, bool) {
+	if pod.Status.StartTime == nil {
+		return 0, false
+	}
+	return now.Sub(pod.Status.StartTime.Time), true
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RevisionAnnotation holds the revision of the ReplicaSets of a Deployment.
+const RevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Revision is a past pod template of a workload, as kept by its controller:
+// in a ReplicaSet for Deployments, in a ControllerRevision for StatefulSets
+// and DaemonSets.
+type Revision struct {
+	Number   int64
+	Template v1.PodTemplateSpec
+}
+
+// ParseWorkloadRef parses KIND/NAME as kubectl takes it, e.g. deployment/web,
+// deploy/web or sts/db, and returns the workload kind and name.
+func ParseWorkloadRef(ref string) (string, string, error) {
+	kind, name, found := strings.Cut(ref, "/")
+	if !found || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("%q is not KIND/NAME", ref)
+	}
+	switch strings.TrimSuffix(strings.ToLower(kind), ".apps") {
+	case "deployment", "deployments", "deploy":
+		return KindDeployment, name, nil
+	case "statefulset", "statefulsets", "sts":
+		return KindStatefulSet, name, nil
+	case "daemonset", "daemonsets", "ds":
+		return KindDaemonSet, name, nil
+	}
+	return "", "", fmt.Errorf("unsupported kind %q (want deployment, statefulset or daemonset)", kind)
+}
+
+// GetWorkload reads the Deployment, StatefulSet or DaemonSet kind/namespace/name.
+func GetWorkload(ctx context.Context, kind, namespace, name string, client kubernetes.Interface) (*Workload, error) {
+	owners := apiOwners{client}
+	switch kind {
+	case KindDeployment:
+		deployment, err := owners.Deployment(ctx, namespace, name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s %s/%s: %w", strings.ToLower(kind), namespace, name, err)
+		}
+		return &Workload{Kind: kind, Namespace: namespace, Name: name, Annotations: deployment.Annotations, Object: deployment}, nil
+	case KindStatefulSet:
+		statefulSet, err := owners.StatefulSet(ctx, namespace, name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s %s/%s: %w", strings.ToLower(kind), namespace, name, err)
+		}
+		return &Workload{Kind: kind, Namespace: namespace, Name: name, Annotations: statefulSet.Annotations, Object: statefulSet}, nil
+	case KindDaemonSet:
+		daemonSet, err := owners.DaemonSet(ctx, namespace, name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s %s/%s: %w", strings.ToLower(kind), namespace, name, err)
+		}
+		return &Workload{Kind: kind, Namespace: namespace, Name: name, Annotations: daemonSet.Annotations, Object: daemonSet}, nil
+	}
+	return nil, fmt.Errorf("unsupported workload kind %s", kind)
+}
+
+// WorkloadRevisions returns the revisions of the workload, oldest first.
+func WorkloadRevisions(ctx context.Context, workload *Workload, client kubernetes.Interface) ([]Revision, error) {
+	owner, ok := workload.Object.(metav1.Object)
+	if !ok {
+		return nil, fmt.Errorf("cannot read the revisions of %s", workload)
+	}
+	var selector *metav1.LabelSelector
+	switch obj := workload.Object.(type) {
+	case *appsv1.Deployment:
+		selector = obj.Spec.Selector
+	case *appsv1.StatefulSet:
+		selector = obj.Spec.Selector
+	case *appsv1.DaemonSet:
+		selector = obj.Spec.Selector
+	}
+	listOptions := metav1.ListOptions{LabelSelector: metav1.FormatLabelSelector(selector)}
+
+	var revisions []Revision
+	if workload.Kind == KindDeployment {
+		var replicaSets *appsv1.ReplicaSetList
+		err := callAPI(ctx, func(ctx context.Context) error {
+			var err error
+			replicaSets, err = client.AppsV1().ReplicaSets(workload.Namespace).List(ctx, listOptions)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing replicasets: %w", err)
+		}
+		for _, replicaSet := range replicaSets.Items {
+			if !metav1.IsControlledBy(&replicaSet, owner) {
+				continue
+			}
+			number, err := strconv.ParseInt(replicaSet.Annotations[RevisionAnnotation], 10, 64)
+			if err != nil {
+				continue
+			}
+			template := *replicaSet.Spec.Template.DeepCopy()
+			delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+			revisions = append(revisions, Revision{Number: number, Template: template})
+		}
+	} else {
+		var controllerRevisions *appsv1.ControllerRevisionList
+		err := callAPI(ctx, func(ctx context.Context) error {
+			var err error
+			controllerRevisions, err = client.AppsV1().ControllerRevisions(workload.Namespace).List(ctx, listOptions)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing controllerrevisions: %w", err)
+		}
+		for _, controllerRevision := range controllerRevisions.Items {
+			if !metav1.IsControlledBy(&controllerRevision, owner) {
+				continue
+			}
+			// The data is a patch of the workload that replaces its template.
+			var data struct {
+				Spec struct {
+					Template v1.PodTemplateSpec `json:"template"`
+				} `json:"spec"`
+			}
+			if err := json.Unmarshal(controllerRevision.Data.Raw, &data); err != nil {
+				return nil, fmt.Errorf("error decoding controllerrevision %s: %v", controllerRevision.Name, err)
+			}
+			revisions = append(revisions, Revision{Number: controllerRevision.Revision, Template: data.Spec.Template})
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Number < revisions[j].Number })
+	return revisions, nil
+}
+
+// UndoTarget picks the revision UndoWorkload rolls back to: toRevision, or
+// the one before the current one for 0. Unless force is set, rolling back to
+// the previous revision is refused when it differs from the current one in
+// more than the restartedAt annotation, i.e. when the last change was not a
+// restart.
+func UndoTarget(revisions []Revision, toRevision int64, force bool) (Revision, error) {
+	if len(revisions) == 0 {
+		return Revision{}, fmt.Errorf("no revisions found")
+	}
+	current := revisions[len(revisions)-1]
+	if toRevision == 0 {
+		if len(revisions) < 2 {
+			return Revision{}, fmt.Errorf("no previous revision to roll back to")
+		}
+		previous := revisions[len(revisions)-2]
+		if !force && !onlyRestartedAtDiffers(current.Template, previous.Template) {
+			return Revision{}, fmt.Errorf("revision %d changed more than the restartedAt annotation, so the last change was not a restart; pass --to-revision=%d or --force to roll it back anyway", current.Number, previous.Number)
+		}
+		return previous, nil
+	}
+	if toRevision == current.Number {
+		return Revision{}, fmt.Errorf("revision %d is the current revision", toRevision)
+	}
+	for _, revision := range revisions {
+		if revision.Number == toRevision {
+			return revision, nil
+		}
+	}
+	return Revision{}, fmt.Errorf("revision %d not found", toRevision)
+}
+
+// onlyRestartedAtDiffers reports whether the templates are equal but for the
+// restartedAt annotation.
+func onlyRestartedAtDiffers(a, b v1.PodTemplateSpec) bool {
+	a, b = *a.DeepCopy(), *b.DeepCopy()
+	delete(a.Annotations, RestartedAtAnnotation)
+	delete(b.Annotations, RestartedAtAnnotation)
+	if len(a.Annotations) == 0 {
+		a.Annotations = nil
+	}
+	if len(b.Annotations) == 0 {
+		b.Annotations = nil
+	}
+	return equality.Semantic.DeepEqual(a, b)
+}
+
+// UndoWorkload replaces the pod template of the workload with the one of
+// revision, like kubectl rollout undo, so the controller rolls it back.
+func UndoWorkload(ctx context.Context, workload *Workload, revision Revision, client kubernetes.Interface) error {
+	workloadLog(ctx, workload, "undo").infof("Rolling back %s to revision %d\n", workload, revision.Number)
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/spec/template", "value": revision.Template},
+	})
+	if err != nil {
+		return err
+	}
+	apps := client.AppsV1()
+	err = callAPI(ctx, func(ctx context.Context) error {
+		var err error
+		switch workload.Kind {
+		case KindDeployment:
+			_, err = apps.Deployments(workload.Namespace).Patch(ctx, workload.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+		case KindStatefulSet:
+			_, err = apps.StatefulSets(workload.Namespace).Patch(ctx, workload.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+		case KindDaemonSet:
+			_, err = apps.DaemonSets(workload.Namespace).Patch(ctx, workload.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+		default:
+			err = fmt.Errorf("unsupported workload kind %s", workload.Kind)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error rolling back %s: %w", strings.ToLower(workload.Kind), err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the body of outbound webhook
+// requests, keyed with the webhook secret, as "sha256=<hex>".
+const SignatureHeader = "X-Restarter-Signature"
+
+// webhookRetryDelay is the delay before the first retry of a webhook
+// request; it doubles with every further retry.
+var webhookRetryDelay = time.Second
+
+// WebhookEvent is the JSON document posted for every restart.
+type WebhookEvent struct {
+	// Type is "restart", "pause" or "resume", after the operation.
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Result     Result    `json:"result"`
+	RunStarted time.Time `json:"runStarted"`
+	RunSummary string    `json:"runSummary"`
+	// RunID identifies the run; Actor is the Kubernetes identity it acted
+	// as.
+	RunID string `json:"runID,omitempty"`
+	Actor string `json:"actor,omitempty"`
+}
+
+// WebhookNotifier posts a signed WebhookEvent for every workload a run
+// restarted, deleted, paused or resumed, or failed to.
+type WebhookNotifier struct {
+	URL string
+	// Secret keys the signature; without it requests are not signed.
+	Secret string
+	// Retries is how often a request is repeated after a network error, a
+	// 429 or a 5xx response.
+	Retries int
+	HTTP    *http.Client
+}
+
+// Name implements Notifier.
+func (w *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Notify implements Notifier. Events are posted one at a time, in the order
+// of the results; the first one that cannot be delivered stops the rest.
+func (w *WebhookNotifier) Notify(ctx context.Context, report RunReport) error {
+	for _, result := range report.Results {
+		if !isRestartEvent(result) {
+			continue
+		}
+		data, err := json.Marshal(WebhookEvent{
+			Type:       report.Operation,
+			Time:       time.Now().UTC(),
+			Result:     result,
+			RunStarted: report.Started.UTC(),
+			RunSummary: report.Summary,
+			RunID:      report.RunID,
+			Actor:      report.Actor,
+		})
+		if err != nil {
+			return fmt.Errorf("error encoding the event: %v", err)
+		}
+		if err := w.post(ctx, data); err != nil {
+			return fmt.Errorf("error posting the event of %s/%s: %v", result.Namespace, result.Workload, err)
+		}
+	}
+	return nil
+}
+
+// isRestartEvent reports whether a result describes a change, or a failed
+// attempt at one, of a workload.
+func isRestartEvent(result Result) bool {
+	switch result.Status {
+	case StatusRestarted, StatusDeleted, StatusPaused, StatusResumed:
+		return true
+	case StatusFailed:
+		return result.Workload != ""
+	}
+	return false
+}
+
+// post delivers one event, retrying transient failures with exponential
+// backoff.
+func (w *WebhookNotifier) post(ctx context.Context, data []byte) error {
+	header := http.Header{}
+	if w.Secret != "" {
+		header.Set(SignatureHeader, signPayload(w.Secret, data))
+	}
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		code, err := post(ctx, w.HTTP, w.URL, header, data)
+		if err == nil || attempt >= w.Retries || !retryableStatus(code) {
+			return err
+		}
+		debugf("Retrying the webhook in %s: %v\n", delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// retryableStatus reports whether a request that got this status, zero for
+// none, may succeed when repeated.
+func retryableStatus(code int) bool {
+	return code == 0 || code == http.StatusTooManyRequests || code >= 500
+}
+
+// signPayload returns the SignatureHeader value of a body.
+func signPayload(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
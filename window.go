@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// What to do with a restart that falls outside every maintenance window.
+const (
+	// OutsideWindowSkip reports the workload as skipped.
+	OutsideWindowSkip = "skip"
+	// OutsideWindowWait holds the restart until the next window opens.
+	OutsideWindowWait = "wait"
+)
+
+// ValidateOutsideWindow rejects unknown --outside-window values.
+func ValidateOutsideWindow(mode string) error {
+	switch mode {
+	case OutsideWindowSkip, OutsideWindowWait:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q (want %s or %s)", mode, OutsideWindowSkip, OutsideWindowWait)
+}
+
+// MaintenanceWindow is a weekly time range in which restarts are allowed,
+// written as "Sat 02:00-04:00 UTC". Days are a comma-separated list of day
+// names or ranges ("Mon-Fri", "Sat,Sun", "*" for every day). A range whose
+// end is not after its start runs past midnight into the next day. The
+// timezone is optional and defaults to UTC.
+type MaintenanceWindow struct {
+	spec string
+	// days holds the weekdays on which the window opens.
+	days [7]bool
+	// start and end are offsets from midnight.
+	start, end time.Duration
+	location   *time.Location
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseMaintenanceWindow parses a window such as "Mon-Fri 22:00-06:00 Europe/Berlin".
+func ParseMaintenanceWindow(spec string) (MaintenanceWindow, error) {
+	window := MaintenanceWindow{spec: spec, location: time.UTC}
+	fields := strings.Fields(spec)
+	if len(fields) != 2 && len(fields) != 3 {
+		return window, fmt.Errorf("invalid maintenance window %q: want \"<days> <HH:MM>-<HH:MM> [timezone]\"", spec)
+	}
+	if err := window.parseDays(fields[0]); err != nil {
+		return window, fmt.Errorf("invalid maintenance window %q: %v", spec, err)
+	}
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return window, fmt.Errorf("invalid maintenance window %q: want a time range such as 02:00-04:00", spec)
+	}
+	var err error
+	if window.start, err = parseTimeOfDay(times[0]); err != nil {
+		return window, fmt.Errorf("invalid maintenance window %q: %v", spec, err)
+	}
+	if window.end, err = parseTimeOfDay(times[1]); err != nil {
+		return window, fmt.Errorf("invalid maintenance window %q: %v", spec, err)
+	}
+	if len(fields) == 3 {
+		if window.location, err = time.LoadLocation(fields[2]); err != nil {
+			return window, fmt.Errorf("invalid maintenance window %q: %v", spec, err)
+		}
+	}
+	return window, nil
+}
+
+func (w *MaintenanceWindow) parseDays(value string) error {
+	if value == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return nil
+	}
+	for _, part := range strings.Split(strings.ToLower(value), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdayNames[bounds[0]]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdayNames[bounds[1]]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w MaintenanceWindow) String() string {
+	return w.spec
+}
+
+// length returns how long the window stays open.
+func (w MaintenanceWindow) length() time.Duration {
+	if w.end > w.start {
+		return w.end - w.start
+	}
+	return w.end + 24*time.Hour - w.start
+}
+
+// Contains reports whether t falls inside the window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	// The window may have opened today or, past midnight, yesterday.
+	for _, daysAgo := range []int{0, 1} {
+		day := t.AddDate(0, 0, -daysAgo)
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		opens := atOffset(day, w.start)
+		if !t.Before(opens) && t.Before(opens.Add(w.length())) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the next time at or after t at which the window opens.
+func (w MaintenanceWindow) NextOpen(t time.Time) time.Time {
+	t = t.In(w.location)
+	for daysAhead := 0; daysAhead <= 7; daysAhead++ {
+		day := t.AddDate(0, 0, daysAhead)
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		if opens := atOffset(day, w.start); !opens.Before(t) {
+			return opens
+		}
+	}
+	return t
+}
+
+// atOffset returns the wall-clock time offset from midnight on the day of t,
+// so windows keep their local times across daylight saving changes.
+func atOffset(t time.Time, offset time.Duration) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, t.Location())
+}
+
+// InMaintenanceWindow reports whether t falls inside any of the windows. No
+// windows means restarts are always allowed.
+func InMaintenanceWindow(windows []MaintenanceWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextMaintenanceWindow returns when the earliest of the windows next opens.
+func NextMaintenanceWindow(windows []MaintenanceWindow, t time.Time) time.Time {
+	var next time.Time
+	for _, window := range windows {
+		if opens := window.NextOpen(t); next.IsZero() || opens.Before(next) {
+			next = opens
+		}
+	}
+	return next
+}
+
+// waitForMaintenanceWindow blocks until one of the windows is open, ctx is
+// done or stop is closed. A cancelled ctx or a closed stop interrupt the wait
+// and return errInterrupted; a ctx past its deadline returns its error.
+func waitForMaintenanceWindow(ctx context.Context, windows []MaintenanceWindow, stop <-chan struct{}) error {
+	for !InMaintenanceWindow(windows, time.Now()) {
+		next := NextMaintenanceWindow(windows, time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if ctx.Err() == context.Canceled {
+				return errInterrupted
+			}
+			return ctx.Err()
+		case <-stop:
+			timer.Stop()
+			return errInterrupted
+		case <-timer.C:
+		}
+	}
+	return nil
+}
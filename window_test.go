@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// 2024-05-04 is a Saturday.
+func at(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("Mon 2006-01-02 15:04 MST", value)
+	if err != nil {
+		t.Fatalf("invalid test time %q: %v", value, err)
+	}
+	return parsed
+}
+
+func mustParseWindow(t *testing.T, spec string) MaintenanceWindow {
+	t.Helper()
+	window, err := ParseMaintenanceWindow(spec)
+	if err != nil {
+		t.Fatalf("ParseMaintenanceWindow(%q) error = %v", spec, err)
+	}
+	return window
+}
+
+func TestParseMaintenanceWindowErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"Sat",
+		"Sat 02:00-04:00 UTC extra",
+		"Caturday 02:00-04:00",
+		"Sat-Funday 02:00-04:00",
+		"Sat 02:00",
+		"Sat 2-4",
+		"Sat 02:00-25:00",
+		"Sat 02:00-04:00 Mars/Olympus_Mons",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := ParseMaintenanceWindow(spec); err == nil {
+				t.Errorf("ParseMaintenanceWindow(%q) succeeded, want an error", spec)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	tests := []struct {
+		window string
+		time   string
+		want   bool
+	}{
+		// A plain window: the start is inside, the end is not.
+		{"Sat 02:00-04:00", "Sat 2024-05-04 02:00 UTC", true},
+		{"Sat 02:00-04:00", "Sat 2024-05-04 03:59 UTC", true},
+		{"Sat 02:00-04:00", "Sat 2024-05-04 04:00 UTC", false},
+		{"Sat 02:00-04:00", "Sat 2024-05-04 01:59 UTC", false},
+		{"Sat 02:00-04:00", "Sun 2024-05-05 03:00 UTC", false},
+
+		// Windows past midnight run into the next day, even one not listed.
+		{"Fri 22:00-02:00", "Fri 2024-05-03 23:00 UTC", true},
+		{"Fri 22:00-02:00", "Sat 2024-05-04 01:59 UTC", true},
+		{"Fri 22:00-02:00", "Sat 2024-05-04 02:00 UTC", false},
+		{"Fri 22:00-02:00", "Fri 2024-05-03 21:59 UTC", false},
+		{"Fri 22:00-02:00", "Fri 2024-05-03 01:00 UTC", false},
+		{"Fri 22:00-02:00", "Sat 2024-05-04 23:00 UTC", false},
+		// ... and across the end of the week.
+		{"Sat 23:00-01:00", "Sun 2024-05-05 00:30 UTC", true},
+		{"Sun 23:00-01:00", "Mon 2024-05-06 00:30 UTC", true},
+		{"Sun 23:00-01:00", "Sun 2024-05-05 00:30 UTC", false},
+		// Equal start and end make a whole day.
+		{"Sat 00:00-00:00", "Sat 2024-05-04 23:59 UTC", true},
+		{"Sat 00:00-00:00", "Sun 2024-05-05 00:00 UTC", false},
+
+		// Day ranges, lists and wildcards, including ranges that wrap
+		// around the week.
+		{"Mon-Fri 09:00-17:00", "Mon 2024-05-06 12:00 UTC", true},
+		{"Mon-Fri 09:00-17:00", "Fri 2024-05-03 12:00 UTC", true},
+		{"Mon-Fri 09:00-17:00", "Sat 2024-05-04 12:00 UTC", false},
+		{"Fri-Mon 00:00-01:00", "Sun 2024-05-05 00:30 UTC", true},
+		{"Fri-Mon 00:00-01:00", "Mon 2024-05-06 00:30 UTC", true},
+		{"Fri-Mon 00:00-01:00", "Tue 2024-05-07 00:30 UTC", false},
+		{"Fri-Mon 00:00-01:00", "Thu 2024-05-02 00:30 UTC", false},
+		{"Sat,Sun 02:00-04:00", "Sun 2024-05-05 03:00 UTC", true},
+		{"Sat,Sun 02:00-04:00", "Mon 2024-05-06 03:00 UTC", false},
+		{"sat 02:00-04:00", "Sat 2024-05-04 03:00 UTC", true},
+		{"* 12:00-13:00", "Wed 2024-05-08 12:30 UTC", true},
+		{"* 12:00-13:00", "Wed 2024-05-08 13:30 UTC", false},
+
+		// Time zones: Berlin is UTC+2 and New York UTC-4 in May.
+		{"Mon-Fri 22:00-06:00 Europe/Berlin", "Mon 2024-05-06 20:30 UTC", true},
+		{"Mon-Fri 22:00-06:00 Europe/Berlin", "Mon 2024-05-06 19:30 UTC", false},
+		{"Mon-Fri 22:00-06:00 Europe/Berlin", "Sat 2024-05-04 03:00 UTC", true},
+		{"Mon-Fri 22:00-06:00 Europe/Berlin", "Sat 2024-05-04 04:00 UTC", false},
+		{"Mon-Fri 22:00-06:00 Europe/Berlin", "Fri 2024-05-10 21:00 UTC", true},
+		{"Mon-Fri 22:00-06:00 Europe/Berlin", "Sat 2024-05-11 21:00 UTC", false},
+		{"Sat,Sun 02:00-04:00 America/New_York", "Sat 2024-05-04 06:30 UTC", true},
+		{"Sat,Sun 02:00-04:00 America/New_York", "Sat 2024-05-04 02:30 UTC", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window+" at "+tt.time, func(t *testing.T) {
+			window := mustParseWindow(t, tt.window)
+			if got := window.Contains(at(t, tt.time)); got != tt.want {
+				t.Errorf("Contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowNextOpen(t *testing.T) {
+	tests := []struct {
+		window string
+		time   string
+		want   string
+	}{
+		{"Sat 02:00-04:00", "Sat 2024-05-04 01:00 UTC", "Sat 2024-05-04 02:00 UTC"},
+		{"Sat 02:00-04:00", "Sat 2024-05-04 02:00 UTC", "Sat 2024-05-04 02:00 UTC"},
+		{"Sat 02:00-04:00", "Sat 2024-05-04 03:00 UTC", "Sat 2024-05-11 02:00 UTC"},
+		{"Sat 02:00-04:00", "Sun 2024-05-05 03:00 UTC", "Sat 2024-05-11 02:00 UTC"},
+		{"Mon-Fri 22:00-06:00", "Fri 2024-05-03 23:00 UTC", "Mon 2024-05-06 22:00 UTC"},
+		{"Sat 02:00-04:00 Europe/Berlin", "Fri 2024-05-03 12:00 UTC", "Sat 2024-05-04 00:00 UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window+" at "+tt.time, func(t *testing.T) {
+			window := mustParseWindow(t, tt.window)
+			if got, want := window.NextOpen(at(t, tt.time)), at(t, tt.want); !got.Equal(want) {
+				t.Errorf("NextOpen() = %s, want %s", got, want.UTC())
+			}
+		})
+	}
+}
+
+func TestInMaintenanceWindow(t *testing.T) {
+	weekend := mustParseWindow(t, "Sat,Sun 02:00-04:00")
+	weeknights := mustParseWindow(t, "Mon-Fri 22:00-23:00")
+	tests := []struct {
+		name    string
+		windows []MaintenanceWindow
+		time    string
+		want    bool
+		next    string
+	}{
+		{name: "no windows", time: "Wed 2024-05-08 12:00 UTC", want: true},
+		{name: "in the first", windows: []MaintenanceWindow{weekend, weeknights}, time: "Sat 2024-05-04 03:00 UTC", want: true},
+		{name: "in the second", windows: []MaintenanceWindow{weekend, weeknights}, time: "Wed 2024-05-08 22:30 UTC", want: true},
+		{name: "in none", windows: []MaintenanceWindow{weekend, weeknights}, time: "Wed 2024-05-08 12:00 UTC", next: "Wed 2024-05-08 22:00 UTC"},
+		{name: "in none before the weekend", windows: []MaintenanceWindow{weekend, weeknights}, time: "Fri 2024-05-10 23:30 UTC", next: "Sat 2024-05-11 02:00 UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := at(t, tt.time)
+			if got := InMaintenanceWindow(tt.windows, now); got != tt.want {
+				t.Errorf("InMaintenanceWindow() = %v, want %v", got, tt.want)
+			}
+			if tt.next == "" {
+				return
+			}
+			if got, want := NextMaintenanceWindow(tt.windows, now), at(t, tt.next); !got.Equal(want) {
+				t.Errorf("NextMaintenanceWindow() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// closedWindow returns a window that is not open now nor within the next
+// day: it opens three days from now, for a minute.
+func closedWindow(t *testing.T) []MaintenanceWindow {
+	day := (time.Now().UTC().Weekday() + 3) % 7
+	return []MaintenanceWindow{mustParseWindow(t, day.String()[:3]+" 00:00-00:01 UTC")}
+}
+
+func TestWaitForMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []MaintenanceWindow
+		// ctx and stop return the context and stop channel of the wait.
+		ctx     func() (context.Context, context.CancelFunc)
+		stop    func() <-chan struct{}
+		wantErr error
+	}{
+		{
+			name:    "open",
+			windows: []MaintenanceWindow{mustParseWindow(t, "* 00:00-00:00")},
+		},
+		{
+			name:    "stopped",
+			windows: closedWindow(t),
+			stop: func() <-chan struct{} {
+				stop := make(chan struct{})
+				time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+				return stop
+			},
+			wantErr: errInterrupted,
+		},
+		{
+			name:    "cancelled",
+			windows: closedWindow(t),
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr: errInterrupted,
+		},
+		{
+			name:    "timed out",
+			windows: closedWindow(t),
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+			var stop <-chan struct{}
+			if tt.stop != nil {
+				stop = tt.stop()
+			}
+			done := make(chan error, 1)
+			go func() { done <- waitForMaintenanceWindow(ctx, tt.windows, stop) }()
+			select {
+			case err := <-done:
+				if err != tt.wantErr {
+					t.Errorf("waitForMaintenanceWindow() = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("waitForMaintenanceWindow() did not return")
+			}
+		})
+	}
+}
+
+// TestHeldRestartStops checks that a restart held for a maintenance window is
+// skipped, not left waiting, when the run stops, as the long-running modes do
+// on shutdown.
+func TestHeldRestartStops(t *testing.T) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"}}
+	stop := make(chan struct{})
+	runner := NewRunner(fake.NewSimpleClientset(deployment), RunOptions{
+		MaintenanceWindows: closedWindow(t),
+		OutsideWindow:      OutsideWindowWait,
+		Stop:               stop,
+	})
+	workload := &Workload{Kind: KindDeployment, Namespace: "shop", Name: "web", Object: deployment}
+	done := make(chan Result, 1)
+	go func() {
+		done <- runner.processWorkload(context.Background(), &Rule{Name: "test"}, workload, "pod web-1", Result{Rule: "test", Status: StatusMatched}, nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	select {
+	case result := <-done:
+		if result.Status != StatusSkipped || result.Reason != ReasonInterrupted {
+			t.Errorf("result = %s (%s), want %s (%s)", result.Status, result.Reason, StatusSkipped, ReasonInterrupted)
+		}
+		if result.Error != "" {
+			t.Errorf("result error = %q, want none", result.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the held restart did not stop")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/testpractive123/assessment-devops.git/pkg/restarter"
+)
+
+// requestTimeout bounds every individual API call.
+var requestTimeout = restarter.DefaultOptions().RequestTimeout
+
+// apiRetries is how many times callAPI repeats a call that failed with a
+// transient error.
+var apiRetries = restarter.DefaultOptions().Retries
+
+// apiRetryDelay is the delay before the first repetition; it doubles after
+// each one.
+var apiRetryDelay = restarter.DefaultOptions().RetryDelay
+
+// listPageSize is how many objects each List call asks for; 0 lists
+// everything in one call.
+var listPageSize = restarter.DefaultOptions().PageSize
+
+// apiOptions returns the options of the restart engine, as set by the API
+// flags.
+func apiOptions() restarter.Options {
+	return restarter.Options{RequestTimeout: requestTimeout, Retries: apiRetries, RetryDelay: apiRetryDelay, PageSize: listPageSize, Debugf: debugf}
+}
+
+// apiClient returns the restart engine for client.
+func apiClient(client kubernetes.Interface) *restarter.Client {
+	return restarter.New(client, apiOptions())
+}
+
+// withRequestTimeout derives the context for a single API call.
+func withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return apiOptions().WithRequestTimeout(ctx)
+}
+
+// callAPI makes an API call with restarter.Options.Call: each attempt has its
+// own request timeout, and attempts that fail with a transient error are
+// repeated with exponential backoff.
+func callAPI(ctx context.Context, call func(context.Context) error) error {
+	return apiOptions().Call(ctx, call)
+}
+
+// Workload kinds that can be restarted.
+const (
+	KindDeployment  = restarter.KindDeployment
+	KindStatefulSet = restarter.KindStatefulSet
+	KindDaemonSet   = restarter.KindDaemonSet
+)
+
+// RestartedAtAnnotation is the pod template annotation kubectl rollout
+// restart sets to trigger a new rollout.
+const RestartedAtAnnotation = restarter.RestartedAtAnnotation
+
+// Workload is a controller whose pods can be rolled by changing its pod
+// template.
+type Workload struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Annotations map[string]string
+	// Object is the typed resource, e.g. *appsv1.Deployment.
+	Object runtime.Object
+
+	// previousRestartedAt is the pod template's restartedAt annotation before
+	// RestartWorkload changed it; nil when it was not set.
+	previousRestartedAt *string
+}
+
+// PodTemplate returns the workload's pod template.
+func (w *Workload) PodTemplate() *v1.PodTemplateSpec {
+	switch obj := w.Object.(type) {
+	case *appsv1.Deployment:
+		return &obj.Spec.Template
+	case *appsv1.StatefulSet:
+		return &obj.Spec.Template
+	case *appsv1.DaemonSet:
+		return &obj.Spec.Template
+	}
+	return nil
+}
+
+// String returns kind/namespace/name, which also identifies the workload.
+func (w *Workload) String() string {
+	return w.Kind + "/" + w.Namespace + "/" + w.Name
+}
+
+// orphanError reports a pod without a live controlling workload: either it
+// never had a controller or the controller no longer exists.
+type orphanError struct {
+	msg string
+}
+
+func (e *orphanError) Error() string {
+	return e.msg
+}
+
+// Is makes errors.Is(err, restarter.ErrNoOwnerFound) hold for orphanErrors.
+func (e *orphanError) Is(target error) bool {
+	return target == restarter.ErrNoOwnerFound
+}
+
+// isOrphan reports whether err comes from resolving an orphaned pod.
+func isOrphan(err error) bool {
+	return errors.Is(err, restarter.ErrNoOwnerFound)
+}
+
+// getOwnerError describes a failed Get of a pod's owner, turning NotFound
+// into an orphanError.
+func getOwnerError(kind string, name string, err error) error {
+	if apierrors.IsNotFound(err) {
+		return &orphanError{msg: fmt.Sprintf("%s %s no longer exists", strings.ToLower(kind), name)}
+	}
+	return fmt.Errorf("error getting %s %s: %w", strings.ToLower(kind), name, err)
+}
+
+// ownerReader reads the ReplicaSets and workloads that own pods, from the
+// API server or a WorkloadCache.
+type ownerReader interface {
+	ReplicaSet(ctx context.Context, namespace, name string) (*appsv1.ReplicaSet, error)
+	Deployment(ctx context.Context, namespace, name string) (*appsv1.Deployment, error)
+	StatefulSet(ctx context.Context, namespace, name string) (*appsv1.StatefulSet, error)
+	DaemonSet(ctx context.Context, namespace, name string) (*appsv1.DaemonSet, error)
+}
+
+// apiOwners reads owners from the API server with callAPI.
+type apiOwners struct {
+	client kubernetes.Interface
+}
+
+func (o apiOwners) ReplicaSet(ctx context.Context, namespace, name string) (replicaSet *appsv1.ReplicaSet, err error) {
+	err = callAPI(ctx, func(ctx context.Context) error {
+		replicaSet, err = o.client.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	return replicaSet, err
+}
+
+func (o apiOwners) Deployment(ctx context.Context, namespace, name string) (deployment *appsv1.Deployment, err error) {
+	err = callAPI(ctx, func(ctx context.Context) error {
+		deployment, err = o.client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	return deployment, err
+}
+
+func (o apiOwners) StatefulSet(ctx context.Context, namespace, name string) (statefulSet *appsv1.StatefulSet, err error) {
+	err = callAPI(ctx, func(ctx context.Context) error {
+		statefulSet, err = o.client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	return statefulSet, err
+}
+
+func (o apiOwners) DaemonSet(ctx context.Context, namespace, name string) (daemonSet *appsv1.DaemonSet, err error) {
+	err = callAPI(ctx, func(ctx context.Context) error {
+		daemonSet, err = o.client.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	return daemonSet, err
+}
+
+// ResolveWorkload finds the workload that controls the pod.
+func ResolveWorkload(ctx context.Context, pod *v1.Pod, client kubernetes.Interface) (*Workload, error) {
+	return resolveWorkload(ctx, pod, apiOwners{client})
+}
+
+// resolveWorkload finds the workload that controls the pod through owners.
+func resolveWorkload(ctx context.Context, pod *v1.Pod, owners ownerReader) (*Workload, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil, &orphanError{msg: fmt.Sprintf("pod %s has no controller", pod.Name)}
+	}
+
+	switch owner.Kind {
+	case "ReplicaSet":
+		deployment, err := resolveDeployment(ctx, pod, owners)
+		if err != nil {
+			return nil, err
+		}
+		return &Workload{Kind: KindDeployment, Namespace: deployment.Namespace, Name: deployment.Name, Annotations: deployment.Annotations, Object: deployment}, nil
+	case KindStatefulSet:
+		statefulSet, err := owners.StatefulSet(ctx, pod.Namespace, owner.Name)
+		if err != nil {
+			return nil, getOwnerError(KindStatefulSet, owner.Name, err)
+		}
+		return &Workload{Kind: KindStatefulSet, Namespace: statefulSet.Namespace, Name: statefulSet.Name, Annotations: statefulSet.Annotations, Object: statefulSet}, nil
+	case KindDaemonSet:
+		daemonSet, err := owners.DaemonSet(ctx, pod.Namespace, owner.Name)
+		if err != nil {
+			return nil, getOwnerError(KindDaemonSet, owner.Name, err)
+		}
+		return &Workload{Kind: KindDaemonSet, Namespace: daemonSet.Namespace, Name: daemonSet.Name, Annotations: daemonSet.Annotations, Object: daemonSet}, nil
+	}
+	return nil, fmt.Errorf("pod %s is controlled by unsupported %s %s", pod.Name, owner.Kind, owner.Name)
+}
+
+// RestartWorkload triggers a rollout restart of the workload. The workload is
+// re-read first, to remember the restartedAt annotation RollbackRestart
+// restores.
+func RestartWorkload(ctx context.Context, workload *Workload, client kubernetes.Interface) error {
+	if err := RefreshWorkload(ctx, workload, client); err != nil {
+		return err
+	}
+	workload.previousRestartedAt = nil
+	if value, ok := workload.PodTemplate().Annotations[RestartedAtAnnotation]; ok {
+		workload.previousRestartedAt = &value
+	}
+	kind := strings.ToLower(workload.Kind)
+	logFor(ctx).with("namespace", workload.Namespace, kind, workload.Name, "action", OperationRestart).infof("Restarting %s: %s\n", kind, workload.Name)
+	_, err := apiClient(client).Restart(ctx, workload.Kind, workload.Namespace, workload.Name)
+	return err
+}
+
+// RefreshWorkload replaces the workload's object with a fresh copy from the
+// API server.
+func RefreshWorkload(ctx context.Context, workload *Workload, client kubernetes.Interface) error {
+	var obj metav1.Object
+	var err error
+	owners := apiOwners{client}
+	switch workload.Kind {
+	case KindDeployment:
+		var deployment *appsv1.Deployment
+		deployment, err = owners.Deployment(ctx, workload.Namespace, workload.Name)
+		if err == nil {
+			obj, workload.Object = deployment, deployment
+		}
+	case KindStatefulSet:
+		var statefulSet *appsv1.StatefulSet
+		statefulSet, err = owners.StatefulSet(ctx, workload.Namespace, workload.Name)
+		if err == nil {
+			obj, workload.Object = statefulSet, statefulSet
+		}
+	case KindDaemonSet:
+		var daemonSet *appsv1.DaemonSet
+		daemonSet, err = owners.DaemonSet(ctx, workload.Namespace, workload.Name)
+		if err == nil {
+			obj, workload.Object = daemonSet, daemonSet
+		}
+	default:
+		return fmt.Errorf("unsupported workload kind %s", workload.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting %s: %w", strings.ToLower(workload.Kind), err)
+	}
+
+	if obj.GetDeletionTimestamp() != nil {
+		return fmt.Errorf("%s is being deleted", workload)
+	}
+	workload.Annotations = obj.GetAnnotations()
+	return nil
+}
+
+// ResolveDeployment finds the deployment that owns the pod by following its
+// controller references: Pod -> ReplicaSet -> Deployment.
+func ResolveDeployment(ctx context.Context, pod *v1.Pod, client kubernetes.Interface) (*appsv1.Deployment, error) {
+	return resolveDeployment(ctx, pod, apiOwners{client})
+}
+
+func resolveDeployment(ctx context.Context, pod *v1.Pod, owners ownerReader) (*appsv1.Deployment, error) {
+	podOwner := metav1.GetControllerOf(pod)
+	if podOwner == nil {
+		return nil, &orphanError{msg: fmt.Sprintf("pod %s has no controller", pod.Name)}
+	}
+	if podOwner.Kind != "ReplicaSet" {
+		return nil, fmt.Errorf("pod %s is controlled by %s %s, not a Deployment", pod.Name, podOwner.Kind, podOwner.Name)
+	}
+
+	replicaSet, err := owners.ReplicaSet(ctx, pod.Namespace, podOwner.Name)
+	if err != nil {
+		return nil, getOwnerError("ReplicaSet", podOwner.Name, err)
+	}
+	rsOwner := metav1.GetControllerOf(replicaSet)
+	if rsOwner == nil || rsOwner.Kind != "Deployment" {
+		return nil, fmt.Errorf("replicaset %s of pod %s is not owned by a Deployment", replicaSet.Name, pod.Name)
+	}
+
+	deployment, err := owners.Deployment(ctx, pod.Namespace, rsOwner.Name)
+	if err != nil {
+		return nil, getOwnerError(KindDeployment, rsOwner.Name, err)
+	}
+	if deployment.UID != rsOwner.UID {
+		return nil, &orphanError{msg: fmt.Sprintf("deployment %s was replaced since replicaset %s was created", deployment.Name, replicaSet.Name)}
+	}
+	return deployment, nil
+}
+
+// FieldManager is the server-side apply field manager restarter sets the
+// restartedAt annotation with.
+const FieldManager = restarter.FieldManager
+
+// RollbackRestart undoes the last RestartWorkload by restoring the previous
+// restartedAt annotation. The pod template then matches the previous revision
+// again, so the controller scales the previous pods back up.
+func RollbackRestart(ctx context.Context, workload *Workload, client kubernetes.Interface) error {
+	workloadLog(ctx, workload, "rollback").warnf("Rolling back %s to its previous revision\n", workload)
+	if err := apiClient(client).ApplyRestartedAt(ctx, workload.Kind, workload.Namespace, workload.Name, workload.previousRestartedAt); err != nil {
+		return fmt.Errorf("error rolling back %s: %w", strings.ToLower(workload.Kind), err)
+	}
+	return nil
+}
+
+// PauseWorkload pauses or resumes the rollout of a Deployment, like kubectl
+// rollout pause/resume. Other kinds have no paused field and are rejected.
+func PauseWorkload(ctx context.Context, workload *Workload, paused bool, client kubernetes.Interface) error {
+	if workload.Kind != KindDeployment {
+		return fmt.Errorf("%s rollouts cannot be paused", strings.ToLower(workload.Kind))
+	}
+	log := workloadLog(ctx, workload, OperationResume)
+	if paused {
+		log = workloadLog(ctx, workload, OperationPause)
+	}
+	if deployment, ok := workload.Object.(*appsv1.Deployment); ok && deployment.Spec.Paused == paused {
+		log.debugf("%s is already in the requested paused=%t state\n", workload, paused)
+		return nil
+	}
+
+	if paused {
+		log.infof("Pausing %s\n", workload)
+	} else {
+		log.infof("Resuming %s\n", workload)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"paused": paused},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	_, err = client.AppsV1().Deployments(workload.Namespace).Patch(ctx, workload.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("error patching deployment: %w", restarter.Forbidden(err))
+	}
+	return nil
+}
+
+// DeletePod removes a pod directly, or through the Eviction API when evict is
+// set so PodDisruptionBudgets are honored.
+func DeletePod(ctx context.Context, pod *v1.Pod, evict bool, client kubernetes.Interface) error {
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+
+	if evict {
+		podLog(ctx, pod).with("action", "evict").infof("Evicting pod: %s\n", pod.Name)
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		if err := client.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction); err != nil {
+			return fmt.Errorf("error evicting pod: %w", restarter.Forbidden(err))
+		}
+		return nil
+	}
+
+	podLog(ctx, pod).with("action", "delete").infof("Deleting pod: %s\n", pod.Name)
+	if err := client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("error deleting pod: %w", restarter.Forbidden(err))
+	}
+	return nil
+}